@@ -0,0 +1,86 @@
+// Package v1alpha1 holds the types of the ApplicationGroup custom resource, which
+// groups workloads into members with a minimum of healthy pods each. The controller
+// reads and writes the resource as unstructured objects through the dynamic client.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the api group and version of the custom resources.
+var GroupVersion = schema.GroupVersion{Group: "utility.shani1998.io", Version: "v1alpha1"}
+
+// ApplicationGroupResource is the resource of the ApplicationGroup custom resource.
+var ApplicationGroupResource = GroupVersion.WithResource("applicationgroups")
+
+// the condition types of an ApplicationGroup
+const (
+	// ConditionReady is true when every member runs its minimum of healthy pods
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when at least one member runs fewer pods than desired, with the
+	// reason MembersUnhealthy when it is below its minimum of healthy pods and ReplicasUnavailable
+	// when it runs its minimum but not all of its desired pods
+	ConditionDegraded = "Degraded"
+)
+
+// ApplicationGroup groups the workloads of an application into members and reports
+// whether every member runs its minimum of healthy pods.
+type ApplicationGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ApplicationGroupSpec   `json:"spec"`
+	Status ApplicationGroupStatus `json:"status,omitempty"`
+}
+
+// ApplicationGroupSpec lists the members of the group.
+type ApplicationGroupSpec struct {
+	Members []Member `json:"members"`
+}
+
+// Member selects the workloads of one part of the application, e.g. the checkout service.
+type Member struct {
+	// Name of the member, unique within the group.
+	Name string `json:"name"`
+	// Cluster the workloads run in, empty selects the cluster the group is created in.
+	Cluster string `json:"cluster,omitempty"`
+	// Kind limits the member to one workload kind, e.g. Deployment, empty selects every kind.
+	Kind string `json:"kind,omitempty"`
+	// Selector matches the labels of the workloads in the namespace of the group.
+	Selector metav1.LabelSelector `json:"selector"`
+	// MinHealthyPods is the number of ready pods the member needs to be healthy,
+	// unset requires every desired replica of the workloads to be ready.
+	MinHealthyPods *int32 `json:"minHealthyPods,omitempty"`
+}
+
+// ApplicationGroupStatus reports the health of the members.
+type ApplicationGroupStatus struct {
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Members reports every member of the spec in the same order.
+	Members []MemberStatus `json:"members,omitempty"`
+	// ReadyMembers is the number of healthy members.
+	ReadyMembers int32 `json:"readyMembers"`
+	// TotalMembers is the number of members.
+	TotalMembers int32 `json:"totalMembers"`
+	// Conditions holds the Ready and Degraded conditions.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// MemberStatus reports the workloads selected by a member and their ready pods.
+type MemberStatus struct {
+	Name string `json:"name"`
+	// Services lists the selected workloads as <kind>/<name>.
+	Services []string `json:"services,omitempty"`
+	// ReadyPods is the number of ready pods of the selected workloads.
+	ReadyPods int32 `json:"readyPods"`
+	// DesiredPods is the number of pods the selected workloads are expected to run.
+	DesiredPods int32 `json:"desiredPods"`
+	// MinHealthyPods is the number of ready pods required, the spec value or DesiredPods when unset.
+	MinHealthyPods int32 `json:"minHealthyPods"`
+	// Healthy is set when the member runs at least MinHealthyPods ready pods.
+	Healthy bool `json:"healthy"`
+	// Message explains why the member is not healthy.
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,136 @@
+// Package certs serves TLS with certificates which are reloaded when they
+// change on disk, e.g. when cert-manager rotates a mounted secret.
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Config names the files TLS is served with.
+type Config struct {
+	// CertFile and KeyFile hold the PEM encoded serving certificate and key.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the PEM encoded CA bundle client certificates are verified
+	// against, client certificates are not requested when empty.
+	ClientCAFile string
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration
+}
+
+// Reloader holds the certificate and client CA pool read from the configured files
+// and replaces them when the files change.
+type Reloader struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	// contents of the files the current certificate and pool were read from
+	certPEM, keyPEM, caPEM []byte
+}
+
+// NewReloader reads the configured files, it fails when they do not hold a valid
+// certificate, key and CA bundle.
+func NewReloader(cfg Config) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+	r := &Reloader{cfg: cfg}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run checks the files for changes every reload interval until stopCh is closed.
+// Files which can not be read or do not parse keep the previous certificate in use.
+func (r *Reloader) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		changed, err := r.reload()
+		if err != nil {
+			log.Errorf("failed to reload certificates, keeping the current ones: %v", err)
+			return
+		}
+		if changed {
+			log.Infof("reloaded certificates from %s", r.cfg.CertFile)
+		}
+	}, r.cfg.ReloadInterval, stopCh)
+}
+
+// reload reads the files and replaces the certificate and pool when their content changed.
+func (r *Reloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.cfg.CertFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(r.cfg.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	var caPEM []byte
+	if r.cfg.ClientCAFile != "" {
+		if caPEM, err = os.ReadFile(r.cfg.ClientCAFile); err != nil {
+			return false, err
+		}
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) && bytes.Equal(caPEM, r.caPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("invalid certificate %s or key %s: %w", r.cfg.CertFile, r.cfg.KeyFile, err)
+	}
+	var clientCA *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(caPEM) {
+			return false, fmt.Errorf("no certificates found in client CA bundle %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCA = &cert, clientCA
+	r.certPEM, r.keyPEM, r.caPEM = certPEM, keyPEM, caPEM
+	return true, nil
+}
+
+// TLSConfig returns a server TLS config which always uses the current certificate.
+// With a client CA bundle every client has to present a certificate signed by it.
+// The config of each handshake is a clone of the returned one, so HTTP/2 and the
+// session ticket keys are kept.
+func (r *Reloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		clientCfg := base.Clone()
+		clientCfg.Certificates = []tls.Certificate{*r.cert}
+		if r.clientCA != nil {
+			clientCfg.ClientAuth = tls.RequireAndVerifyClientCert
+			clientCfg.ClientCAs = r.clientCA
+		}
+		return clientCfg, nil
+	}
+	return cfg
+}
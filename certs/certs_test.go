@@ -0,0 +1,259 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate along with its key, signed by parent or self-signed when nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	kpem []byte
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"team-a"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key %v", err)
+	}
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		kpem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.pem, c.kpem)
+	if err != nil {
+		t.Fatalf("invalid key pair %v", err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// startTLSServer serves TLS with the config of the reloader and returns its url.
+func startTLSServer(t *testing.T, r *Reloader) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// servedSerial connects to the server and returns the serial of the served certificate.
+func servedSerial(t *testing.T, url string, ca *testCert, client *testCert) (int64, error) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{client.tlsCertificate(t)}
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestReloaderRotatesCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", 1, true, nil)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := newTestCert(t, "localhost", 2, false, ca)
+	writeFile(t, certFile, first.pem)
+	writeFile(t, keyFile, first.kpem)
+
+	r, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, ReloadInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	url := startTLSServer(t, r)
+	if serial, err := servedSerial(t, url, ca, nil); err != nil || serial != 2 {
+		t.Fatalf("mismatched served certificate: want=2, got=%v, err=%v", serial, err)
+	}
+
+	// a broken certificate keeps the current one in use
+	writeFile(t, certFile, []byte("not a certificate"))
+	if _, err := r.reload(); err == nil {
+		t.Errorf("expected error reloading an invalid certificate")
+	}
+	if serial, err := servedSerial(t, url, ca, nil); err != nil || serial != 2 {
+		t.Fatalf("mismatched served certificate: want=2, got=%v, err=%v", serial, err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go r.Run(stopCh)
+	second := newTestCert(t, "localhost", 3, false, ca)
+	writeFile(t, keyFile, second.kpem)
+	writeFile(t, certFile, second.pem)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		serial, err := servedSerial(t, url, ca, nil)
+		if err == nil && serial == 3 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated certificate not served: got=%v, err=%v", serial, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloaderVerifiesClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", 1, true, nil)
+	otherCA := newTestCert(t, "other-ca", 1, true, nil)
+	server := newTestCert(t, "localhost", 2, false, ca)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, server.pem)
+	writeFile(t, keyFile, server.kpem)
+	writeFile(t, caFile, ca.pem)
+
+	r, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ReloadInterval: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	url := startTLSServer(t, r)
+
+	tests := []struct {
+		name   string
+		client *testCert
+	}{
+		{name: "client signed by the CA", client: newTestCert(t, "alice", 3, false, ca)},
+		{name: "Failure no client certificate"},
+		{name: "Failure client signed by another CA", client: newTestCert(t, "mallory", 3, false, otherCA)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := servedSerial(t, url, ca, tt.client)
+			if wantErr := strings.Contains(tt.name, "Failure"); (err != nil) != wantErr {
+				t.Errorf("mismatched error: wantErr=%v, got=%v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReloaderKeepsHTTP2AndSessionTickets(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", 1, true, nil)
+	server := newTestCert(t, "localhost", 2, false, ca)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, certFile, server.pem)
+	writeFile(t, keyFile, server.kpem)
+
+	r, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	url := startTLSServer(t, r)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	conn, err := tls.Dial("tcp", strings.TrimPrefix(url, "https://"), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	conn.Close()
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+		t.Errorf("mismatched negotiated protocol: want=h2, got=%q", proto)
+	}
+
+	cfg := &tls.Config{RootCAs: roots, ServerName: "localhost", ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+	for i, wantResumed := range []bool{false, true} {
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		resp.Body.Close()
+		if resp.TLS.DidResume != wantResumed {
+			t.Errorf("mismatched session resumption of request %d: want=%v, got=%v", i, wantResumed, resp.TLS.DidResume)
+		}
+	}
+}
+
+func TestNewReloaderInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", 1, true, nil)
+	server := newTestCert(t, "localhost", 2, false, ca)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, server.pem)
+	writeFile(t, keyFile, server.kpem)
+	writeFile(t, caFile, []byte("no certificates"))
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "missing key file", cfg: Config{CertFile: certFile}},
+		{name: "unreadable certificate", cfg: Config{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile}},
+		{name: "mismatched key", cfg: Config{CertFile: certFile, KeyFile: certFile}},
+		{name: "empty client CA bundle", cfg: Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReloader(tt.cfg); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -1,7 +1,31 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shani1998/k8s-utility-controller/controller"
+	"github.com/shani1998/k8s-utility-controller/events"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/leader"
+	"github.com/shani1998/k8s-utility-controller/manager"
+	"github.com/shani1998/k8s-utility-controller/notify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
 	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -12,18 +36,577 @@ const (
 	defaultHealthAddress      = "0.0.0.0"
 	defaultHealthPort         = "8089"
 
+	defaultTLSReloadInterval = 10 * time.Second
+
+	defaultShutdownDrainPeriod = 5 * time.Second
+	defaultShutdownTimeout     = 20 * time.Second
+	// shutdownHeadroom is left between the shutdown of the api server and the timeout
+	// of the manager, so the server returns before the manager gives up on it.
+	shutdownHeadroom = time.Second
+
+	defaultCacheResync = 10 * time.Minute
+	defaultNamespace   = "default"
+	defaultGroupKey    = "applicationGroup"
+
+	defaultKubeQPS       = 20
+	defaultKubeBurst     = 30
+	defaultKubeUserAgent = "k8s-utility-controller"
+
+	defaultClusterProbeInterval = 30 * time.Second
+
+	defaultLeaderElectionNamespace     = "default"
+	defaultLeaderElectionLeaseName     = "k8s-utility-controller"
+	defaultLeaderElectionLeaseDuration = 15 * time.Second
+	defaultLeaderElectionRenewDeadline = 10 * time.Second
+	defaultLeaderElectionRetryPeriod   = 2 * time.Second
+
+	defaultEventsEnable   = true
+	defaultEventsBurst    = 10
+	defaultEventsInterval = 5 * time.Minute
+
+	defaultNotifyQueueSize   = 1000
+	defaultNotifyMaxAttempts = 10
+	defaultNotifyBackoff     = time.Second
+	defaultNotifyMaxBackoff  = 5 * time.Minute
+
+	defaultApplicationGroupsEnable  = true
+	defaultApplicationGroupsWorkers = 2
+
+	defaultAuthEnable   = false
+	defaultAuthCacheTTL = time.Minute
+
 	defaultLogLevel  = "info"
 	defaultLogFormat = "json"
 )
 
-var (
-	_ = pflag.String("server.host", defaultServerAddr, "address on which server will run")
-	_ = pflag.String("server.port", defaultServerPort, "port to bind the server listener to")
+// eventSource is the component reported as the source of the recorded events
+const eventSource = "k8s-utility-controller"
 
-	_ = pflag.Bool("healthz.enable", defaultHealthServerEnable, "the flag that indicates whether the heath-check endpoint is enabled, default: true")
-	_ = pflag.String("healthz.host", defaultHealthAddress, "address and port to bind the health check listener to")
-	_ = pflag.String("healthz.port", defaultHealthPort, "port to bind the health check listener to")
+// envPrefix prefixes the environment variables of the settings, e.g. K8S_UTIL_SERVER_PORT for server.port
+const envPrefix = "K8S_UTIL"
 
-	_ = pflag.String("log.level", defaultLogLevel, "set the logging level(debug, info, warning, error, fatal, panic) default: info")
-	_ = pflag.String("log.format", defaultLogFormat, "set the logging format(json,text) default: json")
+// flags which control how the configuration is loaded, they are not settings themselves
+const (
+	configFlag      = "config"
+	printConfigFlag = "print-config"
 )
+
+// addFlags defines the settings as flags, the flag names are the keys of the settings
+// in the config file and the environment variables.
+func addFlags(fs *pflag.FlagSet) {
+	fs.String(configFlag, "", "path to a YAML or TOML config file, its keys are the flag names e.g. server.port")
+	fs.Bool(printConfigFlag, false, "print the effective settings merged from defaults, config file, environment and flags, and exit")
+
+	fs.String("server.host", defaultServerAddr, "address on which server will run")
+	fs.String("server.port", defaultServerPort, "port to bind the server listener to")
+
+	fs.Bool("healthz.enable", defaultHealthServerEnable, "the flag that indicates whether the heath-check endpoint is enabled, default: true")
+	fs.String("healthz.host", defaultHealthAddress, "address and port to bind the health check listener to")
+	fs.String("healthz.port", defaultHealthPort, "port to bind the health check listener to")
+
+	fs.String("tls.cert-file", "", "path to the PEM encoded serving certificate, serves TLS on the api and health listeners when set")
+	fs.String("tls.key-file", "", "path to the PEM encoded key of the serving certificate")
+	fs.String("tls.client-ca-file", "", "path to a PEM encoded CA bundle, clients have to present a certificate signed by it when set")
+	fs.Duration("tls.reload-interval", defaultTLSReloadInterval, "how often the certificate, key and client CA files are checked for changes")
+	fs.Bool("healthz.plain-http", false, "keep serving the health check endpoints on plain HTTP when TLS is enabled")
+
+	fs.Duration("shutdown.drain-period", defaultShutdownDrainPeriod, "how long the api server keeps serving after readiness fails on shutdown")
+	fs.Duration("shutdown.timeout", defaultShutdownTimeout, "how long shutdown waits for each server and informer to stop")
+
+	fs.String("kubeconfig", "", "path to a kubeconfig file, defaults to the KUBECONFIG environment variable, the in-cluster config and ~/.kube/config in that order")
+	fs.String("context", "", "kubeconfig context to connect with, defaults to the current context")
+	fs.String("master", "", "address of the api server, overrides the server of the kubeconfig")
+	fs.Float32("kube.qps", defaultKubeQPS, "maximum queries per second sent to the api server")
+	fs.Int("kube.burst", defaultKubeBurst, "maximum burst of queries sent to the api server")
+	fs.Duration("kube.timeout", 0, "timeout of a single request to the api server, 0 does not time out")
+	fs.String("kube.user-agent", defaultKubeUserAgent, "user agent sent with the requests to the api server")
+
+	fs.String("cluster.name", "", "name of the cluster the kube client connects to, reported in the cluster field of the services")
+	fs.StringSlice("clusters.contexts", nil, "comma separated list of kubeconfig contexts whose clusters are aggregated, named after the context")
+	fs.String("clusters.kubeconfig-dir", "", "directory with a kubeconfig file per aggregated cluster, named after the file without extension")
+	fs.Duration("clusters.probe-interval", defaultClusterProbeInterval, "how often the api servers of the aggregated clusters are probed, the services of unreachable clusters are left out")
+
+	fs.Bool("leader-election.enable", false, "elect a leader through a Lease, only the leader runs the stateful loops while every replica serves reads")
+	fs.String("leader-election.namespace", defaultLeaderElectionNamespace, "namespace of the leader election Lease, usually the namespace the controller runs in")
+	fs.String("leader-election.lease-name", defaultLeaderElectionLeaseName, "name of the leader election Lease")
+	fs.String("leader-election.identity", "", "identity of this replica in the Lease, defaults to the host name with a random suffix")
+	fs.Duration("leader-election.lease-duration", defaultLeaderElectionLeaseDuration, "how long followers wait before taking over a Lease which is not renewed")
+	fs.Duration("leader-election.renew-deadline", defaultLeaderElectionRenewDeadline, "how long the leader retries renewing the Lease before it steps down")
+	fs.Duration("leader-election.retry-period", defaultLeaderElectionRetryPeriod, "how long to wait between attempts to acquire or renew the Lease")
+
+	fs.Bool("events.enable", defaultEventsEnable, "record Kubernetes Events on the workloads whose health changes, on the leader")
+	fs.Int("events.burst", defaultEventsBurst, "number of events recorded per object before they are rate limited")
+	fs.Duration("events.interval", defaultEventsInterval, "how often an event is recorded per object once the burst is used up")
+
+	fs.String("notify.targets-file", "", "path to a YAML file listing the webhooks health transitions are posted to, on the leader")
+	fs.String("notify.queue-dir", "", "directory the pending webhook notifications are persisted in, empty keeps them in memory only")
+	fs.Int("notify.queue-size", defaultNotifyQueueSize, "maximum number of pending notifications per webhook, the oldest are dropped")
+	fs.Int("notify.max-attempts", defaultNotifyMaxAttempts, "number of delivery attempts before a notification is dropped")
+	fs.Duration("notify.backoff", defaultNotifyBackoff, "delay before the first retry of a notification, doubled with every retry")
+	fs.Duration("notify.max-backoff", defaultNotifyMaxBackoff, "maximum delay between the retries of a notification")
+
+	fs.Bool("application-groups.enable", defaultApplicationGroupsEnable, "reconcile the ApplicationGroup custom resources of the watched namespaces and write their status, on the leader")
+	fs.Int("application-groups.workers", defaultApplicationGroupsWorkers, "number of ApplicationGroups reconciled in parallel")
+
+	fs.StringSlice("namespaces", []string{defaultNamespace}, "comma separated list of namespaces to watch for services")
+	fs.Bool("all-namespaces", false, "watch services in all namespaces, overrides --namespaces")
+	fs.StringSlice("kinds", handlers.BuiltinKinds(), "comma separated list of workload kinds reported as services")
+	fs.StringSlice("group.keys", []string{defaultGroupKey}, "comma separated fallback chain of labels and annotations the application group is read from, e.g. label:app.kubernetes.io/part-of,annotation:team")
+	fs.String("sources.file", "", "path to a YAML file listing custom resources reported as services")
+	fs.Duration("cache.resync", defaultCacheResync, "resync period of the workload informer cache, 0 disables resync")
+
+	fs.Bool("auth.enable", defaultAuthEnable, "authenticate api requests with the TokenReview api and authorize them per namespace with the SubjectAccessReview api")
+	fs.Duration("auth.cache-ttl", defaultAuthCacheTTL, "how long token and access review decisions are cached")
+
+	fs.String("log.level", defaultLogLevel, "set the logging level(debug, info, warning, error, fatal, panic) default: info")
+	fs.String("log.format", defaultLogFormat, "set the logging format(json,text) default: json")
+}
+
+// envName returns the environment variable of the setting with the given key.
+func envName(key string) string {
+	return envPrefix + "_" + strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(key))
+}
+
+// settingKeys returns the keys of the settings defined by the flags.
+func settingKeys(fs *pflag.FlagSet) []string {
+	var keys []string
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Name != configFlag && f.Name != printConfigFlag {
+			keys = append(keys, f.Name)
+		}
+	})
+	return keys
+}
+
+// loadConfig merges the config file, the K8S_UTIL_* environment variables and the flags
+// into v, in increasing order of precedence, and validates the result. Every problem
+// found is reported in the returned error.
+func loadConfig(v *viper.Viper, fs *pflag.FlagSet, environ []string) error {
+	if err := v.BindPFlags(fs); err != nil {
+		return err
+	}
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+
+	var problems []error
+	if configFile := v.GetString(configFlag); configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file %s: %w", configFile, err)
+		}
+		problems = append(problems, unknownConfigKeys(v, fs)...)
+	}
+
+	envKeys := make(map[string]string)
+	for _, key := range settingKeys(fs) {
+		envKeys[envName(key)] = key
+	}
+	setFromEnv := make(map[string]bool)
+	for _, env := range environ {
+		name, _, _ := strings.Cut(env, "=")
+		if key, ok := envKeys[name]; ok {
+			setFromEnv[key] = true
+		} else if strings.HasPrefix(name, envPrefix+"_") {
+			problems = append(problems, fmt.Errorf("%s: unknown environment variable", name))
+		}
+	}
+
+	// lists from the environment are comma separated like the flags
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Value.Type() != "stringSlice" || f.Changed || !setFromEnv[f.Name] {
+			return
+		}
+		items := strings.Split(v.GetString(f.Name), ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		v.Set(f.Name, items)
+	})
+
+	problems = append(problems, validateConfig(v, fs)...)
+	return configError(problems)
+}
+
+// unknownConfigKeys reports the keys of the config file which are no setting.
+func unknownConfigKeys(v *viper.Viper, fs *pflag.FlagSet) []error {
+	known := make(map[string]bool)
+	for _, key := range settingKeys(fs) {
+		known[key] = true
+	}
+	var problems []error
+	for _, key := range v.AllKeys() {
+		if !known[key] && v.InConfig(key) {
+			problems = append(problems, fmt.Errorf("%s: unknown key in config file %s", key, v.ConfigFileUsed()))
+		}
+	}
+	return problems
+}
+
+// configError reports the problems with the configuration in a single error, nil without problems.
+func configError(problems []error) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, "  - "+problem.Error())
+	}
+	sort.Strings(messages)
+	return fmt.Errorf("invalid configuration:\n%s", strings.Join(messages, "\n"))
+}
+
+// validateConfig returns every problem with the settings in v.
+func validateConfig(v *viper.Viper, fs *pflag.FlagSet) []error {
+	var problems []error
+	// reported keys are not checked further, a value which does not parse reads as zero
+	reported := make(map[string]bool)
+	invalid := func(key string, format string, args ...interface{}) {
+		if !reported[key] {
+			reported[key] = true
+			problems = append(problems, fmt.Errorf("%s: "+format, append([]interface{}{key}, args...)...))
+		}
+	}
+
+	// values from the config file and the environment are not parsed by the flags
+	fs.VisitAll(func(f *pflag.Flag) {
+		var err error
+		switch f.Value.Type() {
+		case "bool":
+			_, err = cast.ToBoolE(v.Get(f.Name))
+		case "duration":
+			var d time.Duration
+			if d, err = cast.ToDurationE(v.Get(f.Name)); err == nil && d < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "stringSlice":
+			_, err = cast.ToStringSliceE(v.Get(f.Name))
+		case "int":
+			_, err = cast.ToIntE(v.Get(f.Name))
+		case "float32":
+			_, err = cast.ToFloat32E(v.Get(f.Name))
+		}
+		if err != nil {
+			invalid(f.Name, "invalid value %q: %v", fmt.Sprint(v.Get(f.Name)), err)
+		}
+	})
+
+	if _, ok := formatterMap[v.GetString("log.format")]; !ok {
+		invalid("log.format", "must be one of json, text, got %q", v.GetString("log.format"))
+	}
+	if _, err := logrus.ParseLevel(v.GetString("log.level")); err != nil {
+		invalid("log.level", "must be one of debug, info, warning, error, fatal, panic, got %q", v.GetString("log.level"))
+	}
+
+	for _, key := range []string{"server.port", "healthz.port"} {
+		if port, err := strconv.Atoi(v.GetString(key)); err != nil || port < 0 || port > 65535 {
+			invalid(key, "must be a port number, got %q", v.GetString(key))
+		}
+	}
+	for _, key := range []string{"server.host", "healthz.host"} {
+		if host := v.GetString(key); host != "" && net.ParseIP(host) == nil && len(validation.IsDNS1123Subdomain(host)) > 0 {
+			invalid(key, "must be an IP address or host name, got %q", host)
+		}
+	}
+
+	certFile, keyFile := v.GetString("tls.cert-file"), v.GetString("tls.key-file")
+	if (certFile == "") != (keyFile == "") {
+		invalid("tls.cert-file", "tls.cert-file and tls.key-file have to be set together")
+	}
+	if v.GetString("tls.client-ca-file") != "" && certFile == "" {
+		invalid("tls.client-ca-file", "requires tls.cert-file and tls.key-file")
+	}
+	for _, key := range []string{"tls.cert-file", "tls.key-file", "tls.client-ca-file", "sources.file", "notify.targets-file"} {
+		if path := v.GetString(key); path != "" {
+			if _, err := os.Stat(path); err != nil {
+				invalid(key, "%v", err)
+			}
+		}
+	}
+	if path := v.GetString("kubeconfig"); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			invalid("kubeconfig", "%v", err)
+		}
+	}
+	if master := v.GetString("master"); master != "" {
+		if u, err := url.Parse(master); err != nil || u.Host == "" {
+			invalid("master", "must be a URL e.g. https://10.0.0.1:6443, got %q", master)
+		}
+	}
+	if v.GetFloat64("kube.qps") <= 0 {
+		invalid("kube.qps", "must be positive")
+	}
+	if v.GetInt("kube.burst") <= 0 {
+		invalid("kube.burst", "must be positive")
+	}
+	if len(v.GetStringSlice("clusters.contexts")) > 0 || v.GetString("clusters.kubeconfig-dir") != "" {
+		if v.GetString("cluster.name") == "" {
+			invalid("cluster.name", "is required when clusters are aggregated")
+		}
+		for _, contextName := range v.GetStringSlice("clusters.contexts") {
+			if contextName == v.GetString("cluster.name") {
+				invalid("clusters.contexts", "context %q has the name of the local cluster", contextName)
+			}
+		}
+	}
+	if dir := v.GetString("clusters.kubeconfig-dir"); dir != "" {
+		if info, err := os.Stat(dir); err != nil {
+			invalid("clusters.kubeconfig-dir", "%v", err)
+		} else if !info.IsDir() {
+			invalid("clusters.kubeconfig-dir", "%s is not a directory", dir)
+		}
+	}
+	if v.GetDuration("clusters.probe-interval") == 0 {
+		invalid("clusters.probe-interval", "must be positive")
+	}
+	if errs := validation.IsDNS1123Label(v.GetString("leader-election.namespace")); len(errs) > 0 {
+		invalid("leader-election.namespace", "invalid namespace %q: %s", v.GetString("leader-election.namespace"), strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(v.GetString("leader-election.lease-name")); len(errs) > 0 {
+		invalid("leader-election.lease-name", "invalid name %q: %s", v.GetString("leader-election.lease-name"), strings.Join(errs, ", "))
+	}
+	if v.GetDuration("leader-election.retry-period") == 0 {
+		invalid("leader-election.retry-period", "must be positive")
+	}
+	if v.GetDuration("leader-election.renew-deadline") <= v.GetDuration("leader-election.retry-period") {
+		invalid("leader-election.renew-deadline", "must be longer than leader-election.retry-period")
+	}
+	if v.GetDuration("leader-election.lease-duration") <= v.GetDuration("leader-election.renew-deadline") {
+		invalid("leader-election.lease-duration", "must be longer than leader-election.renew-deadline")
+	}
+	if v.GetInt("events.burst") <= 0 {
+		invalid("events.burst", "must be positive")
+	}
+	if v.GetDuration("events.interval") <= 0 {
+		invalid("events.interval", "must be positive")
+	}
+	if v.GetInt("notify.queue-size") <= 0 {
+		invalid("notify.queue-size", "must be positive")
+	}
+	if v.GetInt("notify.max-attempts") <= 0 {
+		invalid("notify.max-attempts", "must be positive")
+	}
+	if v.GetDuration("notify.backoff") <= 0 {
+		invalid("notify.backoff", "must be positive")
+	}
+	if v.GetDuration("notify.max-backoff") < v.GetDuration("notify.backoff") {
+		invalid("notify.max-backoff", "must not be shorter than notify.backoff")
+	}
+	if v.GetInt("application-groups.workers") <= 0 {
+		invalid("application-groups.workers", "must be positive")
+	}
+	if v.GetDuration("tls.reload-interval") == 0 {
+		invalid("tls.reload-interval", "must be positive")
+	}
+	if v.GetDuration("auth.cache-ttl") == 0 {
+		invalid("auth.cache-ttl", "must be positive")
+	}
+	if v.GetDuration("shutdown.timeout") < v.GetDuration("shutdown.drain-period")+2*shutdownHeadroom {
+		invalid("shutdown.timeout", fmt.Sprintf("must exceed shutdown.drain-period by at least %s", 2*shutdownHeadroom))
+	}
+
+	if !v.GetBool("all-namespaces") {
+		namespaces := v.GetStringSlice("namespaces")
+		if len(namespaces) == 0 {
+			invalid("namespaces", "must not be empty, use all-namespaces to watch every namespace")
+		}
+		for _, ns := range namespaces {
+			if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+				invalid("namespaces", "invalid namespace %q: %s", ns, strings.Join(errs, ", "))
+			}
+		}
+	}
+	builtinKinds := make(map[string]bool)
+	for _, kind := range handlers.BuiltinKinds() {
+		builtinKinds[strings.ToLower(kind)] = true
+	}
+	for _, kind := range v.GetStringSlice("kinds") {
+		if !builtinKinds[strings.ToLower(kind)] {
+			invalid("kinds", "unknown kind %q, must be one of %s", kind, strings.Join(handlers.BuiltinKinds(), ", "))
+		}
+	}
+	if err := handlers.ValidateGroupKeys(v.GetStringSlice("group.keys")); err != nil {
+		invalid("group.keys", "%v", err)
+	}
+	if sourcesFile := v.GetString("sources.file"); sourcesFile != "" {
+		if _, err := os.Stat(sourcesFile); err == nil {
+			if _, err := handlers.LoadWorkloadSources(sourcesFile); err != nil {
+				invalid("sources.file", "%v", err)
+			}
+		}
+	}
+	if targetsFile := v.GetString("notify.targets-file"); targetsFile != "" {
+		if _, err := os.Stat(targetsFile); err == nil {
+			if _, err := notify.LoadTargets(targetsFile); err != nil {
+				invalid("notify.targets-file", "%v", err)
+			}
+		}
+	}
+	return problems
+}
+
+// effectiveSettings returns the value of every setting by its key, durations are formatted.
+func effectiveSettings(v *viper.Viper, fs *pflag.FlagSet) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, key := range settingKeys(fs) {
+		switch fs.Lookup(key).Value.Type() {
+		case "bool":
+			settings[key] = v.GetBool(key)
+		case "duration":
+			settings[key] = v.GetDuration(key).String()
+		case "stringSlice":
+			settings[key] = v.GetStringSlice(key)
+		case "int":
+			settings[key] = v.GetInt(key)
+		case "float32":
+			settings[key] = v.GetFloat64(key)
+		default:
+			settings[key] = v.GetString(key)
+		}
+	}
+	return settings
+}
+
+// printConfig writes the effective settings as YAML, nested by the dotted keys.
+func printConfig(w io.Writer, v *viper.Viper, fs *pflag.FlagSet) error {
+	settings := make(map[string]interface{})
+	for key, value := range effectiveSettings(v, fs) {
+		parts := strings.Split(key, ".")
+		parent := settings
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := parent[part].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				parent[part] = child
+			}
+			parent = child
+		}
+		parent[parts[len(parts)-1]] = value
+	}
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if configFile := v.GetString(configFlag); configFile != "" {
+		if abs, err := filepath.Abs(configFile); err == nil {
+			configFile = abs
+		}
+		fmt.Fprintf(w, "# config file: %s\n", configFile)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// clientConfig returns the api client config of the settings in v.
+func clientConfig(v *viper.Viper) handlers.ClientConfig {
+	return handlers.ClientConfig{
+		Kubeconfig: v.GetString("kubeconfig"),
+		Context:    v.GetString("context"),
+		Master:     v.GetString("master"),
+		QPS:        float32(v.GetFloat64("kube.qps")),
+		Burst:      v.GetInt("kube.burst"),
+		Timeout:    v.GetDuration("kube.timeout"),
+		UserAgent:  v.GetString("kube.user-agent"),
+	}
+}
+
+// clustersConfig returns the config of the aggregated clusters of the settings in v.
+func clustersConfig(v *viper.Viper) handlers.ClustersConfig {
+	return handlers.ClustersConfig{
+		Name:          v.GetString("cluster.name"),
+		Contexts:      v.GetStringSlice("clusters.contexts"),
+		KubeconfigDir: v.GetString("clusters.kubeconfig-dir"),
+		ProbeInterval: v.GetDuration("clusters.probe-interval"),
+	}
+}
+
+// leaderConfig returns the leader election config of the settings in v.
+func leaderConfig(v *viper.Viper) leader.Config {
+	identity := v.GetString("leader-election.identity")
+	if identity == "" {
+		// the host name is the pod name, the suffix tells restarted containers apart
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = defaultLeaderElectionLeaseName
+		}
+		identity = hostname + "_" + uuid.NewString()
+	}
+	return leader.Config{
+		Enabled:         v.GetBool("leader-election.enable"),
+		Namespace:       v.GetString("leader-election.namespace"),
+		Name:            v.GetString("leader-election.lease-name"),
+		Identity:        identity,
+		LeaseDuration:   v.GetDuration("leader-election.lease-duration"),
+		RenewDeadline:   v.GetDuration("leader-election.renew-deadline"),
+		RetryPeriod:     v.GetDuration("leader-election.retry-period"),
+		ShutdownTimeout: v.GetDuration("shutdown.timeout"),
+	}
+}
+
+// serverOptions returns the drain period and shutdown timeout of the api server of the
+// settings in v, both fit within the shutdown timeout of the manager with headroom to spare.
+func serverOptions(v *viper.Viper) manager.ServerOptions {
+	drain := v.GetDuration("shutdown.drain-period")
+	return manager.ServerOptions{
+		DrainPeriod:     drain,
+		ShutdownTimeout: v.GetDuration("shutdown.timeout") - drain - shutdownHeadroom,
+	}
+}
+
+// eventsConfig returns the config of the recorded events of the settings in v.
+func eventsConfig(v *viper.Viper) events.Config {
+	return events.Config{
+		Record:    v.GetBool("events.enable"),
+		Component: eventSource,
+		Burst:     v.GetInt("events.burst"),
+		Interval:  v.GetDuration("events.interval"),
+	}
+}
+
+// notifyConfig returns the webhook notifier config of the settings in v.
+func notifyConfig(v *viper.Viper) (notify.Config, error) {
+	targets, err := notify.LoadTargets(v.GetString("notify.targets-file"))
+	if err != nil {
+		return notify.Config{}, fmt.Errorf("failed to load notification targets: %w", err)
+	}
+	return notify.Config{
+		Targets:     targets,
+		QueueDir:    v.GetString("notify.queue-dir"),
+		QueueSize:   v.GetInt("notify.queue-size"),
+		MaxAttempts: v.GetInt("notify.max-attempts"),
+		Backoff:     v.GetDuration("notify.backoff"),
+		MaxBackoff:  v.GetDuration("notify.max-backoff"),
+	}, nil
+}
+
+// controllerConfig returns the ApplicationGroup controller config of the settings in v.
+func controllerConfig(v *viper.Viper) controller.Config {
+	cfg := controller.Config{
+		Workers: v.GetInt("application-groups.workers"),
+		Resync:  v.GetDuration("cache.resync"),
+	}
+	if !v.GetBool("all-namespaces") {
+		cfg.Namespaces = v.GetStringSlice("namespaces")
+	}
+	return cfg
+}
+
+// cacheConfig returns the workload cache config of the settings in v.
+func cacheConfig(v *viper.Viper) (handlers.CacheConfig, error) {
+	cfg := handlers.CacheConfig{
+		Kinds:     v.GetStringSlice("kinds"),
+		GroupKeys: v.GetStringSlice("group.keys"),
+		Resync:    v.GetDuration("cache.resync"),
+	}
+	if !v.GetBool("all-namespaces") {
+		cfg.Namespaces = v.GetStringSlice("namespaces")
+	}
+	if sourcesFile := v.GetString("sources.file"); sourcesFile != "" {
+		sources, err := handlers.LoadWorkloadSources(sourcesFile)
+		if err != nil {
+			return handlers.CacheConfig{}, fmt.Errorf("failed to load workload sources: %w", err)
+		}
+		cfg.Sources = sources
+	}
+	return cfg, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// newTestConfig loads the settings from the given flags, environment and config file content.
+func newTestConfig(t *testing.T, args []string, environ []string, configFile string) (*viper.Viper, *pflag.FlagSet, error) {
+	t.Helper()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addFlags(fs)
+	if configFile != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(configFile), 0o600); err != nil {
+			t.Fatalf("failed to write config file %v", err)
+		}
+		args = append(args, "--config="+path)
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("failed to parse flags %v", err)
+	}
+	// AutomaticEnv reads the process environment
+	for _, env := range environ {
+		name, value, _ := strings.Cut(env, "=")
+		t.Setenv(name, value)
+	}
+	v := viper.New()
+	return v, fs, loadConfig(v, fs, environ)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	config := `
+server:
+  port: 9090
+  host: 0.0.0.0
+namespaces: [team-a, team-b]
+cache:
+  resync: 1m
+log:
+  level: debug
+`
+	v, _, err := newTestConfig(t,
+		[]string{"--log.level=warn"},
+		[]string{"K8S_UTIL_SERVER_PORT=9191", "K8S_UTIL_KINDS=Deployment, Job", "K8S_UTIL_AUTH_CACHE_TTL=5m"},
+		config,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		got  interface{}
+		want interface{}
+	}{
+		{key: "server.host", got: v.GetString("server.host"), want: "0.0.0.0"},
+		{key: "server.port", got: v.GetString("server.port"), want: "9191"},
+		{key: "namespaces", got: v.GetStringSlice("namespaces"), want: []string{"team-a", "team-b"}},
+		{key: "kinds", got: v.GetStringSlice("kinds"), want: []string{"Deployment", "Job"}},
+		{key: "cache.resync", got: v.GetDuration("cache.resync"), want: time.Minute},
+		{key: "auth.cache-ttl", got: v.GetDuration("auth.cache-ttl"), want: 5 * time.Minute},
+		{key: "log.level", got: v.GetString("log.level"), want: "warn"},
+		{key: "log.format", got: v.GetString("log.format"), want: defaultLogFormat},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("mismatched %s: want=%v, got=%v", tt.key, tt.want, tt.got)
+		}
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		environ    []string
+		config     string
+		wantErrors []string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:    "Failure every problem is reported",
+			args:    []string{"--log.format=xml", "--kinds=Deployment,Rollout", "--server.port=http"},
+			environ: []string{"K8S_UTIL_SHUTDOWN_TIMEOUT=1s", "K8S_UTIL_SERVR_PORT=80"},
+			config:  "namespaces: [Team_A]\ngroup:\n  keys: [\"annotation:bad key\"]\n",
+			wantErrors: []string{
+				"K8S_UTIL_SERVR_PORT: unknown environment variable",
+				"group.keys: invalid group key",
+				`kinds: unknown kind "Rollout"`,
+				`log.format: must be one of json, text, got "xml"`,
+				`namespaces: invalid namespace "Team_A"`,
+				`server.port: must be a port number, got "http"`,
+				"shutdown.timeout: must exceed shutdown.drain-period by at least 2s",
+			},
+		},
+		{
+			name:   "Failure values of the config file are type checked",
+			config: "cache:\n  resync: soon\nhealthz:\n  enable: maybe\nlog:\n  level: loud\n",
+			wantErrors: []string{
+				`cache.resync: invalid value "soon"`,
+				`healthz.enable: invalid value "maybe"`,
+				`log.level: must be one of debug, info, warning, error, fatal, panic, got "loud"`,
+			},
+		},
+		{
+			name:       "Failure unknown key in config file",
+			config:     "server:\n  prot: 8080\n",
+			wantErrors: []string{"server.prot: unknown key in config file"},
+		},
+		{
+			name: "Failure incomplete tls settings",
+			args: []string{"--tls.key-file=/missing/tls.key", "--tls.client-ca-file=/missing/ca.crt"},
+			wantErrors: []string{
+				"tls.cert-file: tls.cert-file and tls.key-file have to be set together",
+				"tls.client-ca-file: requires tls.cert-file and tls.key-file",
+				"tls.key-file: stat /missing/tls.key",
+			},
+		},
+		{
+			name:    "Failure invalid client settings",
+			args:    []string{"--kubeconfig=/missing/kubeconfig", "--master=10.0.0.1", "--kube.burst=0"},
+			environ: []string{"K8S_UTIL_KUBE_QPS=fast"},
+			wantErrors: []string{
+				`kube.burst: must be positive`,
+				`kube.qps: invalid value "fast"`,
+				"kubeconfig: stat /missing/kubeconfig",
+				`master: must be a URL e.g. https://10.0.0.1:6443, got "10.0.0.1"`,
+			},
+		},
+		{
+			name: "Failure aggregated clusters without a local cluster name",
+			args: []string{"--clusters.contexts=prod-1,prod-2", "--clusters.kubeconfig-dir=/missing/clusters"},
+			wantErrors: []string{
+				"cluster.name: is required when clusters are aggregated",
+				"clusters.kubeconfig-dir: stat /missing/clusters",
+			},
+		},
+		{
+			name: "Failure leader election periods out of order",
+			args: []string{"--leader-election.lease-duration=5s", "--leader-election.renew-deadline=10s", "--leader-election.lease-name=Controller"},
+			wantErrors: []string{
+				"leader-election.lease-duration: must be longer than leader-election.renew-deadline",
+				`leader-election.lease-name: invalid name "Controller"`,
+			},
+		},
+		{
+			name: "Failure invalid notifier settings",
+			args: []string{"--notify.targets-file=/missing/targets.yaml", "--notify.max-attempts=0", "--notify.backoff=1m", "--notify.max-backoff=30s"},
+			wantErrors: []string{
+				"notify.max-attempts: must be positive",
+				"notify.max-backoff: must not be shorter than notify.backoff",
+				"notify.targets-file: stat /missing/targets.yaml",
+			},
+		},
+		{
+			name: "Failure no application group workers and events",
+			args: []string{"--application-groups.workers=0", "--events.burst=0", "--events.interval=0s"},
+			wantErrors: []string{
+				"application-groups.workers: must be positive",
+				"events.burst: must be positive",
+				"events.interval: must be positive",
+			},
+		},
+		{
+			name:       "Failure drain period leaves no time to shut down the api server",
+			args:       []string{"--shutdown.drain-period=20s", "--shutdown.timeout=21s"},
+			wantErrors: []string{"shutdown.timeout: must exceed shutdown.drain-period by at least 2s"},
+		},
+		{
+			name: "shutdown timeout just above the drain period",
+			args: []string{"--shutdown.drain-period=20s", "--shutdown.timeout=22s"},
+		},
+		{
+			name:   "namespaces are not checked when watching all namespaces",
+			args:   []string{"--all-namespaces"},
+			config: "namespaces: []\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newTestConfig(t, tt.args, tt.environ, tt.config)
+			if !strings.Contains(tt.name, "Failure") {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			lines := strings.Split(err.Error(), "\n")[1:]
+			if len(lines) != len(tt.wantErrors) {
+				t.Errorf("mismatched number of problems: want=%d, got=%d\n%v", len(tt.wantErrors), len(lines), err)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(err.Error(), "  - "+want) {
+					t.Errorf("problem %q not reported in\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	v, _, err := newTestConfig(t, []string{"--shutdown.drain-period=5s", "--shutdown.timeout=20s"}, nil, "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	opts := serverOptions(v)
+	if opts.DrainPeriod != 5*time.Second || opts.ShutdownTimeout != 14*time.Second {
+		t.Errorf("unexpected drain period %s and shutdown timeout %s", opts.DrainPeriod, opts.ShutdownTimeout)
+	}
+	// the manager waits for the whole shutdown timeout, the server has to return before
+	if opts.DrainPeriod+opts.ShutdownTimeout >= v.GetDuration("shutdown.timeout") {
+		t.Errorf("no headroom left below the shutdown timeout of the manager")
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	v, fs, err := newTestConfig(t, []string{"--server.port=9090", "--namespaces=team-a,team-b"}, nil, "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var out bytes.Buffer
+	if err := printConfig(&out, v, fs); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, want := range []string{
+		"server:\n  host: 127.0.0.1\n  port: \"9090\"\n",
+		"namespaces:\n- team-a\n- team-b\n",
+		"shutdown:\n  drain-period: 5s\n  timeout: 20s\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("effective config does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), printConfigFlag) {
+		t.Errorf("effective config contains the %s flag:\n%s", printConfigFlag, out.String())
+	}
+}
@@ -1,41 +1,61 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"net"
 	"net/http"
 
 	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/health"
+	"github.com/shani1998/k8s-utility-controller/leader"
 	log "github.com/sirupsen/logrus"
 )
 
-var healthError error
+var (
+	// livez fails only when the process has to be restarted
+	livez = health.NewRegistry("livez")
+	// readyz fails while the controller can not serve requests
+	readyz = health.NewRegistry("readyz")
+	// routerReady is set while the api server is accepting requests
+	routerReady = health.NewFlag("router", "server is not accepting requests")
+)
 
-// healthz starts http server which handles the requests for readiness check
-func healthz(host, port string) {
-	log.Infof("starting healthz at %s:%s", host, port)
+func init() {
+	livez.Add(health.PingCheck)
+	readyz.Add(
+		health.PingCheck,
+		health.NamedCheck("apiserver", handlers.CheckAPIServer),
+		health.NamedCheck("informer-sync", handlers.CheckCacheSynced),
+		routerReady,
+	)
+}
 
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		if healthError != nil {
-			log.Errorf("health check failed, error: %v", healthError)
-			w.WriteHeader(http.StatusServiceUnavailable)
-		} else {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("ok"))
-		}
-	})
-	address := net.JoinHostPort(host, port)
-	if err := http.ListenAndServe(address, nil); err != nil {
-		log.Errorf("failed to start healthz server, Reason %v", err)
-	}
+// healthServer returns the http server which serves the /livez and /readyz endpoints,
+// /healthz is kept as an alias of /readyz, and the leader election status at /leader.
+// It serves TLS when tlsConfig is set.
+func healthServer(host, port string, tlsConfig *tls.Config, elector *leader.Elector) *http.Server {
+	mux := http.NewServeMux()
+	health.InstallHandler(mux, livez)
+	health.InstallHandler(mux, readyz)
+	health.InstallPathHandler(mux, "/healthz", readyz)
+	mux.Handle("/leader", leaderHandler(elector))
+
+	return &http.Server{Addr: net.JoinHostPort(host, port), Handler: mux, TLSConfig: tlsConfig}
 }
 
-// updateHealthStatus updates the values of healthError variable upon
-// receiving the error value  on channel from handlers.
-func updateHealthStatus() {
-	for {
-		healthError = <-handlers.HealthChan
-		if healthError != nil {
-			log.Debugf("received health error msg from channel, %v", healthError)
+// leaderHandler reports the current leader and whether this replica leads.
+func leaderHandler(elector *leader.Elector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		respBytes, err := json.Marshal(elector.Status())
+		if err != nil {
+			log.Errorf("error marshaling leader status %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(respBytes); err != nil {
+			log.Errorf("failed to write leader status %v", err)
 		}
-	}
+	})
 }
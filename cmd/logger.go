@@ -24,16 +24,19 @@ var formatterMap = map[string]logrus.Formatter{
 	"text": &logrus.TextFormatter{},
 }
 
-func initializeLogger(format, level string) {
+// initializeLogger configures logrus, it fails on an unknown format or level
+// instead of leaving logrus with a nil formatter.
+func initializeLogger(format, level string) error {
 	formatter, ok := formatterMap[format]
 	if !ok {
-		logrus.Errorf("invalid log format [%v]", format)
+		return fmt.Errorf("invalid log format [%v]", format)
 	}
 	logLevel, err := logrus.ParseLevel(level)
 	if err != nil {
-		logrus.Errorf("invalid log level [%v], error: %v", logLevel, err)
+		return fmt.Errorf("invalid log level [%v], error: %v", level, err)
 	}
 	logrus.SetFormatter(formatter)
 	logrus.SetLevel(logLevel)
 	logrus.SetReportCaller(true)
+	return nil
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
@@ -11,65 +13,209 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/certs"
+	"github.com/shani1998/k8s-utility-controller/controller"
+	"github.com/shani1998/k8s-utility-controller/events"
 	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/leader"
+	"github.com/shani1998/k8s-utility-controller/manager"
+	"github.com/shani1998/k8s-utility-controller/metrics"
+	"github.com/shani1998/k8s-utility-controller/notify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-func init() {
+func main() {
+	addFlags(pflag.CommandLine)
 	pflag.Parse()
-	_ = viper.BindPFlags(pflag.CommandLine)
 
-	initializeLogger(viper.GetString("log.format"), viper.GetString("log.level"))
-}
+	// settings are read from the config file, K8S_UTIL_* environment variables and flags
+	if err := loadConfig(viper.GetViper(), pflag.CommandLine, os.Environ()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if viper.GetBool(printConfigFlag) {
+		if err := printConfig(os.Stdout, viper.GetViper(), pflag.CommandLine); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-func main() {
-	// setup check endpoint to monitor the health of the controller,
-	// it becomes unhealthy if at all any error occurs while serving requests
+	if err := initializeLogger(viper.GetString("log.format"), viper.GetString("log.level")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// settings changed in the config file are applied while running
+	reloader := newConfigReloader(viper.GetViper(), pflag.CommandLine)
+
+	// accepts os Interrupt signal to shut down the controller gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// client-go reports the rest client metrics to the registry set up
+	// before the first client is created
+	metrics.RegisterClientGoMetrics()
+	metrics.Registry.MustRegister(handlers.NewCacheCollector())
+
+	// runnables start in order and stop in reverse order, the health server
+	// keeps answering probes until everything else has stopped
+	mgr := manager.New(viper.GetDuration("shutdown.timeout"))
+
+	// serve TLS with the certificates from disk, they are reloaded when rotated
+	var tlsConfig *tls.Config
+	if certFile := viper.GetString("tls.cert-file"); certFile != "" {
+		certReloader, err := certs.NewReloader(certs.Config{
+			CertFile:       certFile,
+			KeyFile:        viper.GetString("tls.key-file"),
+			ClientCAFile:   viper.GetString("tls.client-ca-file"),
+			ReloadInterval: viper.GetDuration("tls.reload-interval"),
+		})
+		if err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+		tlsConfig = certReloader.TLSConfig()
+		mgr.Add(manager.RunnableFunc("certificate reloader", func(ctx context.Context, ready func()) error {
+			ready()
+			certReloader.Run(ctx.Done())
+			return nil
+		}))
+	}
+
+	// every replica serves reads, the stateful and mutating loops added to the
+	// elector run only on the replica holding the Lease
+	elector := leader.New(leaderConfig(viper.GetViper()), handlers.KubeClient)
+	livez.Add(elector.HealthCheck())
+
+	// setup check endpoints to monitor the health of the controller, the
+	// controller is ready once the api server is reachable, the cache has synced
+	// and the server accepts requests
 	if viper.GetBool("healthz.enable") {
-		go updateHealthStatus()
-		go healthz(viper.GetString("healthz.host"), viper.GetString("healthz.port"))
+		healthTLSConfig := tlsConfig
+		if viper.GetBool("healthz.plain-http") {
+			healthTLSConfig = nil
+		}
+		srv := healthServer(viper.GetString("healthz.host"), viper.GetString("healthz.port"), healthTLSConfig, elector)
+		mgr.Add(manager.NewServer("health server", srv, manager.ServerOptions{
+			ShutdownTimeout: viper.GetDuration("shutdown.timeout"),
+		}))
+	}
+
+	// retry until we initialize the kube client successfully using the
+	// in-cluster config or a kubeconfig selected by the client settings.
+	kubeClientConfig := clientConfig(viper.GetViper())
+	mgr.Add(manager.RunnableFunc("kube client", func(ctx context.Context, _ func()) error {
+		for handlers.InitKubeClient(kubeClientConfig) != nil {
+			// Wait for 30s to 1m before making a request to api server
+			jitter := time.Duration(rand.Intn(30*1000)) * time.Millisecond
+			duration := 30*time.Second + jitter
+			log.Infof("retry initializing kube client in %v", duration)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(duration):
+			}
+		}
+		return nil
+	}))
+
+	// register the clusters whose services are aggregated and probe their api
+	// servers, the services of unreachable clusters are left out of the responses
+	clusters := clustersConfig(viper.GetViper())
+	mgr.Add(manager.RunnableFunc("cluster registry", func(ctx context.Context, ready func()) error {
+		if err := handlers.InitClusters(kubeClientConfig, clusters); err != nil {
+			return err
+		}
+		ready()
+		handlers.RunClusterProbes(ctx.Done(), clusters.ProbeInterval)
+		return nil
+	}))
+
+	// start the workload cache and wait until it has synced before serving
+	// requests, handlers read from the cache instead of the api server. The cache
+	// is reinitialized when its settings change in the config file.
+	initialCacheConfig, err := cacheConfig(viper.GetViper())
+	if err != nil {
+		log.Fatal(err)
 	}
+	mgr.Add(manager.RunnableFunc("workload cache", func(ctx context.Context, ready func()) error {
+		return runWorkloadCache(ctx, initialCacheConfig, reloader.cacheConfigs, ready)
+	}))
 
-	// retry until we initialize the kube client successfully
-	// using either inCluster or outCluster config.
-	for handlers.InitKubeClient() != nil {
-		// Wait for 30s to 1m before making a request to api server
-		jitter := time.Duration(rand.Intn(30*1000)) * time.Millisecond
-		duration := 30*time.Second + jitter
-		log.Infof("retry initializing kube client in %v", duration)
-		time.Sleep(duration)
+	// the leader reports the health transitions of the workloads as events and to
+	// the webhooks, and writes the status of the ApplicationGroups from the workload cache
+	var notifier *notify.Notifier
+	if viper.GetString("notify.targets-file") != "" {
+		notifyCfg, err := notifyConfig(viper.GetViper())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if notifier, err = notify.New(notifyCfg); err != nil {
+			log.Fatal(err)
+		}
+		metrics.Registry.MustRegister(notify.Collectors()...)
+		elector.Add(notifier)
+	}
+	var reporter controller.Reporter
+	if viper.GetBool("events.enable") || notifier != nil {
+		eventsCfg := eventsConfig(viper.GetViper())
+		if notifier != nil {
+			eventsCfg.Notify = notifier.Notify
+		}
+		recorder := events.New(eventsCfg, handlers.KubeClient)
+		reloader.onChange([]string{"events.burst", "events.interval"}, func(v *viper.Viper) {
+			recorder.SetRateLimit(v.GetInt("events.burst"), v.GetDuration("events.interval"))
+		})
+		elector.Add(recorder)
+		reporter = recorder
+	}
+	if viper.GetBool("application-groups.enable") {
+		groupsConfig := controllerConfig(viper.GetViper())
+		groupsConfig.Reporter = reporter
+		groups := controller.New(groupsConfig, handlers.KubeClient, handlers.DynamicClient)
+		reloader.onChange([]string{"namespaces", "all-namespaces"}, func(v *viper.Viper) {
+			groups.SetNamespaces(controllerConfig(v).Namespaces)
+		})
+		elector.Add(groups)
 	}
 
+	// campaign for leadership once the cache has synced
+	mgr.Add(elector)
+
 	// initialize http router
 	router := httprouter.New()
-	// get services
-	router.GET("/services", handlers.GetServices)
-	// get services by application group
-	router.GET("/services/:applicationGroup", handlers.GetServicesByAppLabel)
+	// unversioned routes serve v1 for existing clients
+	registerServiceRoutes(router, "", handlers.APIVersionV1)
+	registerServiceRoutes(router, "/v1", handlers.APIVersionV1)
+	registerServiceRoutes(router, "/v2", handlers.APIVersionV2)
+	// expose metrics in the prometheus text format, with auth.enable to callers
+	// allowed to get the path as a non-resource url
+	metricsHandler := metrics.Handler()
+	router.GET("/metrics", handlers.WithPathAuth(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		metricsHandler.ServeHTTP(w, r)
+	}))
+	// report the version of the applied configuration
+	router.GET("/admin/config", metrics.InstrumentRoute("/admin/config", handlers.WithPathAuth(handlers.GetConfigVersion)))
 
 	srv := &http.Server{
 		Addr:    net.JoinHostPort(viper.GetString("server.host"), viper.GetString("server.port")),
-		Handler: router,
+		Handler: handlers.WithRequestID(handlers.WithAuditLog(router)),
+		// the server is served over TLS when set
+		TLSConfig: tlsConfig,
 	}
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("error while servingon %v", err)
-		}
-	}()
-	log.Infof("started server on address %s", srv.Addr)
-
-	// accepts os Interrupt signal to shut down server gracefully
-	doneC := make(chan os.Signal, 1)
-	signal.Notify(doneC, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	log.Infof("received signal %v", <-doneC)
-
-	// shut down http server gracefully
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("graceful server shutdown failed: %v", err)
+	// readiness fails for the drain period before the server shuts down, both
+	// within the shutdown timeout of the manager
+	serverOpts := serverOptions(viper.GetViper())
+	serverOpts.Ready = routerReady
+	mgr.Add(manager.NewServer("api server", srv, serverOpts))
+
+	// apply changes of the config file at runtime
+	reloader.watch()
+
+	if err := mgr.Run(ctx); err != nil {
+		log.Fatalf("controller stopped: %v", err)
 	}
-	log.Infof("gracefully stopped server listening on %s", srv.Addr)
+	log.Infof("controller stopped gracefully")
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// reloadableSettings are applied at runtime when the config file changes, the
+// other settings, e.g. the listen addresses, keep their value until the controller restarts.
+var reloadableSettings = map[string]bool{
+	"log.level":       true,
+	"log.format":      true,
+	"auth.enable":     true,
+	"auth.cache-ttl":  true,
+	"kube.qps":        true,
+	"kube.burst":      true,
+	"events.burst":    true,
+	"events.interval": true,
+	"namespaces":      true,
+	"all-namespaces":  true,
+	"kinds":           true,
+	"group.keys":      true,
+	"sources.file":    true,
+	"cache.resync":    true,
+}
+
+// cacheSettings are the settings the workload cache is reinitialized for when they change.
+var cacheSettings = []string{"namespaces", "all-namespaces", "kinds", "group.keys", "sources.file", "cache.resync"}
+
+// reloadHook applies the changes of settings to a component created after the reloader.
+type reloadHook struct {
+	settings []string
+	apply    func(v *viper.Viper)
+}
+
+// configReloader applies the changes of the config file to the running controller.
+type configReloader struct {
+	v  *viper.Viper
+	fs *pflag.FlagSet
+	// cacheConfigs receives the config the workload cache is reinitialized with,
+	// only the latest one is kept
+	cacheConfigs chan handlers.CacheConfig
+	hooks        []reloadHook
+
+	mu sync.Mutex
+	// applied holds the settings the controller runs with
+	applied map[string]interface{}
+	// pending holds the changed settings which wait for a restart
+	pending   map[string]bool
+	version   int64
+	appliedAt time.Time
+}
+
+// newConfigReloader returns a reloader for the settings loaded into v and applies them.
+func newConfigReloader(v *viper.Viper, fs *pflag.FlagSet) *configReloader {
+	c := &configReloader{
+		v:            v,
+		fs:           fs,
+		cacheConfigs: make(chan handlers.CacheConfig, 1),
+		applied:      effectiveSettings(v, fs),
+		pending:      make(map[string]bool),
+		version:      1,
+		appliedAt:    time.Now().UTC(),
+	}
+	handlers.SetAuthConfig(authConfig(v))
+	c.publish()
+	return c
+}
+
+// authConfig returns the auth config of the settings in v.
+func authConfig(v *viper.Viper) handlers.AuthConfig {
+	return handlers.AuthConfig{Enabled: v.GetBool("auth.enable"), CacheTTL: v.GetDuration("auth.cache-ttl")}
+}
+
+// onChange calls apply with the reloaded settings whenever one of the settings changed.
+func (c *configReloader) onChange(settings []string, apply func(v *viper.Viper)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, reloadHook{settings: settings, apply: apply})
+}
+
+// watch reloads the settings whenever the config file changes.
+func (c *configReloader) watch() {
+	if c.v.ConfigFileUsed() == "" {
+		return
+	}
+	c.v.OnConfigChange(func(e fsnotify.Event) {
+		log.Infof("config file %s changed (%s)", e.Name, e.Op)
+		c.reload()
+	})
+	c.v.WatchConfig()
+	log.Infof("watching config file %s for changes", c.v.ConfigFileUsed())
+}
+
+// reload validates the settings read from the changed config file and applies the
+// reloadable ones. Invalid configs are rejected as a whole.
+func (c *configReloader) reload() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	problems := append(unknownConfigKeys(c.v, c.fs), validateConfig(c.v, c.fs)...)
+	if err := configError(problems); err != nil {
+		log.Errorf("rejected config change, keeping version %d: %v", c.version, err)
+		return
+	}
+
+	next := effectiveSettings(c.v, c.fs)
+	var changed []string
+	for key, value := range next {
+		if reflect.DeepEqual(value, c.applied[key]) {
+			delete(c.pending, key)
+			continue
+		}
+		if !reloadableSettings[key] {
+			log.Warnf("setting %s changed to %v but requires a restart, keeping %v", key, value, c.applied[key])
+			c.pending[key] = true
+			continue
+		}
+		changed = append(changed, key)
+	}
+	if len(changed) == 0 {
+		c.publish()
+		return
+	}
+	sort.Strings(changed)
+
+	cacheChanged := false
+	for _, key := range cacheSettings {
+		if !reflect.DeepEqual(next[key], c.applied[key]) {
+			cacheChanged = true
+		}
+	}
+	var cacheCfg handlers.CacheConfig
+	if cacheChanged {
+		var err error
+		if cacheCfg, err = cacheConfig(c.v); err != nil {
+			log.Errorf("rejected config change, keeping version %d: %v", c.version, err)
+			return
+		}
+	}
+
+	if err := initializeLogger(c.v.GetString("log.format"), c.v.GetString("log.level")); err != nil {
+		log.Errorf("failed to reconfigure logger: %v", err)
+	}
+	handlers.SetAuthConfig(authConfig(c.v))
+	if slices.Contains(changed, "kube.qps") || slices.Contains(changed, "kube.burst") {
+		client := clientConfig(c.v)
+		handlers.SetClientRateLimits(client.QPS, client.Burst)
+	}
+	for _, hook := range c.hooks {
+		if slices.ContainsFunc(hook.settings, func(key string) bool { return slices.Contains(changed, key) }) {
+			hook.apply(c.v)
+		}
+	}
+	if cacheChanged {
+		// replace a config the cache has not picked up yet
+		select {
+		case <-c.cacheConfigs:
+		default:
+		}
+		c.cacheConfigs <- cacheCfg
+	}
+	for _, key := range changed {
+		c.applied[key] = next[key]
+	}
+	c.version++
+	c.appliedAt = time.Now().UTC()
+	log.Infof("applied config version %d, changed %v", c.version, changed)
+	c.publish()
+}
+
+// publish reports the applied config on the admin endpoint.
+func (c *configReloader) publish() {
+	pending := make([]string, 0, len(c.pending))
+	for key := range c.pending {
+		pending = append(pending, key)
+	}
+	sort.Strings(pending)
+	// map keys are marshaled in sorted order, the checksum is stable
+	encoded, _ := json.Marshal(c.applied)
+	sum := sha256.Sum256(encoded)
+	handlers.SetConfigVersion(models.ConfigVersion{
+		Version:        c.version,
+		Checksum:       hex.EncodeToString(sum[:]),
+		AppliedAt:      c.appliedAt,
+		PendingRestart: pending,
+	})
+}
+
+// runWorkloadCache runs the workload cache until ctx is cancelled and reinitializes it with
+// every config received from reloads. The cache of a new config is built and synced next to
+// the running one, which keeps serving until it is replaced. When the cache can not be
+// initialized with a new config the previous one keeps running.
+func runWorkloadCache(ctx context.Context, cfg handlers.CacheConfig, reloads <-chan handlers.CacheConfig, ready func()) error {
+	current, err := handlers.NewWorkloadCache(cfg)
+	if err != nil {
+		return err
+	}
+	handlers.InstallWorkloadCache(current)
+	stopCurrent, ok := startWorkloadCache(ctx, current)
+	if !ok {
+		// stopped before the cache synced
+		return ctx.Err()
+	}
+	log.Infof("workload cache synced")
+	ready()
+
+	for {
+		var next handlers.CacheConfig
+		select {
+		case <-ctx.Done():
+			stopCurrent()
+			return nil
+		case next = <-reloads:
+		}
+
+		log.Infof("reinitializing workload cache")
+		replacement, err := handlers.NewWorkloadCache(next)
+		if err != nil {
+			log.Errorf("failed to reinitialize workload cache, keeping the previous config: %v", err)
+			continue
+		}
+		stopReplacement, ok := startWorkloadCache(ctx, replacement)
+		if !ok {
+			// stopped before the replacement synced
+			stopCurrent()
+			return nil
+		}
+		handlers.InstallWorkloadCache(replacement)
+		stopCurrent()
+		stopCurrent = stopReplacement
+		log.Infof("workload cache synced, replaced the previous cache")
+	}
+}
+
+// startWorkloadCache starts the cache and waits for it to sync. It returns a function
+// stopping the cache, the cache is already stopped when it did not sync before ctx was cancelled.
+func startWorkloadCache(ctx context.Context, wc *handlers.WorkloadCache) (stop func(), synced bool) {
+	cacheCtx, cancel := context.WithCancel(ctx)
+	stop = func() {
+		cancel()
+		wc.Stop()
+	}
+	if !wc.Start(cacheCtx.Done()) {
+		stop()
+		return nil, false
+	}
+	return stop, true
+}
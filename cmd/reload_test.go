@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// currentConfigVersion returns the config version reported on the admin endpoint.
+func currentConfigVersion(t *testing.T) models.ConfigVersion {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handlers.GetConfigVersion(w, httptest.NewRequest("GET", "http://test-service.com/admin/config", nil), nil)
+	var version models.ConfigVersion
+	if err := json.Unmarshal(w.Body.Bytes(), &version); err != nil {
+		t.Fatalf("failed to unmarshal config version %v", err)
+	}
+	return version
+}
+
+func TestConfigReloaderReload(t *testing.T) {
+	initial := "log:\n  level: info\nserver:\n  port: 8080\nnamespaces: [team-a]\n"
+	v, fs, err := newTestConfig(t, nil, nil, initial)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { logrus.SetLevel(logrus.InfoLevel) })
+	c := newConfigReloader(v, fs)
+	first := currentConfigVersion(t)
+	if first.Version != 1 || first.Checksum == "" || len(first.PendingRestart) != 0 {
+		t.Fatalf("mismatched initial config version %+v", first)
+	}
+
+	tests := []struct {
+		name        string
+		config      string
+		wantVersion int64
+		wantPending []string
+		wantLevel   logrus.Level
+		wantCache   []string
+	}{
+		{
+			name:        "reloadable settings are applied",
+			config:      "log:\n  level: debug\nserver:\n  port: 8080\nnamespaces: [team-a, team-b]\n",
+			wantVersion: 2,
+			wantPending: []string{},
+			wantLevel:   logrus.DebugLevel,
+			wantCache:   []string{"team-a", "team-b"},
+		},
+		{
+			name:        "listen address waits for a restart",
+			config:      "log:\n  level: debug\nserver:\n  port: 9090\nnamespaces: [team-a, team-b]\n",
+			wantVersion: 2,
+			wantPending: []string{"server.port"},
+			wantLevel:   logrus.DebugLevel,
+		},
+		{
+			name:        "Failure invalid config is rejected as a whole",
+			config:      "log:\n  level: loud\nserver:\n  port: 8080\nnamespaces: [team-c]\n",
+			wantVersion: 2,
+			wantPending: []string{"server.port"},
+			wantLevel:   logrus.DebugLevel,
+		},
+		{
+			name:        "reverted listen address is no longer pending",
+			config:      "log:\n  level: warn\nserver:\n  port: 8080\nnamespaces: [team-a, team-b]\n",
+			wantVersion: 3,
+			wantPending: []string{},
+			wantLevel:   logrus.WarnLevel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(v.ConfigFileUsed(), []byte(tt.config), 0o600); err != nil {
+				t.Fatalf("failed to write config file %v", err)
+			}
+			if err := v.ReadInConfig(); err != nil {
+				t.Fatalf("failed to read config file %v", err)
+			}
+			c.reload()
+
+			version := currentConfigVersion(t)
+			if version.Version != tt.wantVersion || !reflect.DeepEqual(version.PendingRestart, tt.wantPending) {
+				t.Errorf("mismatched config version: want=%d %v, got=%d %v", tt.wantVersion, tt.wantPending, version.Version, version.PendingRestart)
+			}
+			if got := logrus.GetLevel(); got != tt.wantLevel {
+				t.Errorf("mismatched log level: want=%v, got=%v", tt.wantLevel, got)
+			}
+			select {
+			case cfg := <-c.cacheConfigs:
+				if !reflect.DeepEqual(cfg.Namespaces, tt.wantCache) {
+					t.Errorf("mismatched cache namespaces: want=%v, got=%v", tt.wantCache, cfg.Namespaces)
+				}
+			default:
+				if tt.wantCache != nil {
+					t.Errorf("cache not reinitialized")
+				}
+			}
+		})
+	}
+}
+
+func TestConfigReloaderWatch(t *testing.T) {
+	v, fs, err := newTestConfig(t, nil, nil, "log:\n  level: info\n")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { logrus.SetLevel(logrus.InfoLevel) })
+	c := newConfigReloader(v, fs)
+	c.watch()
+
+	if err := os.WriteFile(v.ConfigFileUsed(), []byte("log:\n  level: error\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config file %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for currentConfigVersion(t).Version != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("config change not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := logrus.GetLevel(); got != logrus.ErrorLevel {
+		t.Errorf("mismatched log level: want=%v, got=%v", logrus.ErrorLevel, got)
+	}
+}
+
+func TestConfigReloaderHooks(t *testing.T) {
+	v, fs, err := newTestConfig(t, nil, nil, "events:\n  burst: 10\nnamespaces: [team-a]\n")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	c := newConfigReloader(v, fs)
+	var bursts []int
+	c.onChange([]string{"events.burst", "events.interval"}, func(v *viper.Viper) {
+		bursts = append(bursts, v.GetInt("events.burst"))
+	})
+
+	for _, config := range []string{
+		"events:\n  burst: 20\nnamespaces: [team-a]\n",
+		// other changes do not call the hook
+		"events:\n  burst: 20\nnamespaces: [team-b]\n",
+	} {
+		if err := os.WriteFile(v.ConfigFileUsed(), []byte(config), 0o600); err != nil {
+			t.Fatalf("failed to write config file %v", err)
+		}
+		if err := v.ReadInConfig(); err != nil {
+			t.Fatalf("failed to read config file %v", err)
+		}
+		c.reload()
+	}
+	if !reflect.DeepEqual(bursts, []int{20}) {
+		t.Errorf("mismatched hook calls: want=[20], got=%v", bursts)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/metrics"
+)
+
+// registerServiceRoutes registers the services api below the prefix, served in the given api version.
+// With auth.enable the callers only see the services of the namespaces their RBAC allows.
+func registerServiceRoutes(router *httprouter.Router, prefix, version string) {
+	route := func(path string, handle httprouter.Handle) {
+		handle = handlers.WithAuth(handlers.WithAPIVersion(version, handle))
+		router.GET(prefix+path, metrics.InstrumentRoute(prefix+path, handle))
+	}
+	// get services
+	route("/services", handlers.GetServices)
+	// get services by application group, /services/watch streams service changes
+	route("/services/:applicationGroup", handlers.GetServicesByAppLabel)
+	// get pods of a service
+	route("/services/:applicationGroup/:name/pods", handlers.GetServicePods)
+	// get the summary of every application group
+	route("/groups", handlers.GetGroups)
+	// get the summary of one application group
+	route("/groups/:applicationGroup/summary", handlers.GetGroupSummary)
+}
@@ -0,0 +1,352 @@
+// Package controller reconciles ApplicationGroup custom resources. It reads the
+// workloads of the members from the workload cache and writes the health of the
+// group to the status subresource, so kubectl shows it without the http api.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/api/v1alpha1"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// the reasons of the events recorded on ApplicationGroups
+const (
+	ReasonGroupDegraded  = "GroupDegraded"
+	ReasonGroupRecovered = "GroupRecovered"
+)
+
+// discoveryInterval is how often the controller checks whether the CRD has been installed.
+var discoveryInterval = time.Minute
+
+// Config configures the ApplicationGroup controller.
+type Config struct {
+	// Namespaces the groups are watched in, empty watches every namespace.
+	Namespaces []string
+	// Workers is the number of groups reconciled in parallel.
+	Workers int
+	// Resync is how often every group is reconciled without a change.
+	Resync time.Duration
+	// Reporter is told when the Ready condition of a group changes, nil reports nothing.
+	Reporter Reporter
+}
+
+// Reporter reports the health transitions of objects, e.g. as Kubernetes Events.
+type Reporter interface {
+	Report(obj runtime.Object, n models.Notification)
+}
+
+// Controller writes the status of the ApplicationGroups.
+type Controller struct {
+	cfg     Config
+	client  func() kubernetes.Interface
+	dynamic func() dynamic.Interface
+	// listServices returns the services matching a filter, handlers.ListServices
+	// outside of tests
+	listServices func(handlers.ServiceFilter) ([]models.Service, []string, error)
+
+	// namespaces receives the namespaces the groups are watched in after they changed,
+	// only the latest ones are kept
+	namespaces chan []string
+
+	queue workqueue.TypedRateLimitingInterface[string]
+	// informersMu guards the informers, which are replaced when the namespaces change
+	informersMu sync.RWMutex
+	informers   []cache.SharedIndexInformer
+}
+
+// New returns a controller for cfg, client and dynamic return the clients once they have been initialized.
+func New(cfg Config, client func() kubernetes.Interface, dynamic func() dynamic.Interface) *Controller {
+	return &Controller{cfg: cfg, client: client, dynamic: dynamic, listServices: handlers.ListServices, namespaces: make(chan []string, 1)}
+}
+
+// SetNamespaces changes the namespaces the groups are watched in, empty watches every
+// namespace. The groups of the new namespaces are watched and reconciled once synced.
+func (c *Controller) SetNamespaces(namespaces []string) {
+	// replace namespaces the controller has not picked up yet
+	select {
+	case <-c.namespaces:
+	default:
+	}
+	c.namespaces <- namespaces
+}
+
+func (c *Controller) Name() string { return "application group controller" }
+
+// Start reconciles the groups until ctx is cancelled. It is ready right away, until
+// the CRD is installed the controller only checks for it every discoveryInterval.
+// Every group is reconciled when it changes, when a service in its namespace
+// changes and every Resync.
+func (c *Controller) Start(ctx context.Context, ready func()) error {
+	ready()
+	warned := false
+	err := wait.PollUntilContextCancel(ctx, discoveryInterval, true, func(context.Context) (bool, error) {
+		installed, err := c.crdInstalled()
+		if !installed && !warned {
+			log.Warnf("application groups are not reconciled until the ApplicationGroup CRD is installed, see deploy/applicationgroup-crd.yaml: %v", err)
+			warned = true
+		}
+		return installed, nil
+	})
+	if err != nil {
+		// cancelled before the CRD was installed
+		return nil
+	}
+
+	c.queue = workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "applicationgroups"})
+	defer c.queue.ShutDown()
+
+	select {
+	case c.cfg.Namespaces = <-c.namespaces:
+	default:
+	}
+	stopInformers, err := c.watchGroups(ctx, c.cfg.Namespaces)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// the health of a group follows the services in its namespace
+	go handlers.WatchServiceEvents(ctx.Done(), func(ev models.ServiceEvent, _ runtime.Object) {
+		c.enqueueNamespace(ev.Service.Namespace)
+	}, c.enqueueAll)
+
+	workers := max(c.cfg.Workers, 1)
+	log.Infof("reconciling application groups with %d workers", workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem(ctx) {
+			}
+		}()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			c.queue.ShutDown()
+			wg.Wait()
+			stopInformers()
+			return nil
+		case namespaces := <-c.namespaces:
+			log.Infof("watching application groups in namespaces %q", namespaces)
+			stopNext, err := c.watchGroups(ctx, namespaces)
+			if err != nil {
+				log.Errorf("failed to watch application groups in the changed namespaces, keeping the previous ones: %v", err)
+				continue
+			}
+			stopInformers()
+			stopInformers = stopNext
+			// kept for the next term of the leader
+			c.cfg.Namespaces = namespaces
+			c.enqueueAll()
+		}
+	}
+}
+
+// watchGroups starts the informers of the groups in the namespaces, every namespace when
+// empty, and replaces the watched informers with them once they have synced. The returned
+// function stops them. When ctx is cancelled before they synced they are stopped and
+// the previous informers are kept.
+func (c *Controller) watchGroups(ctx context.Context, namespaces []string) (func(), error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	informersCtx, cancel := context.WithCancel(ctx)
+	factories := make([]dynamicinformer.DynamicSharedInformerFactory, 0, len(namespaces))
+	stop := func() {
+		cancel()
+		for _, factory := range factories {
+			factory.Shutdown()
+		}
+	}
+	informers := make([]cache.SharedIndexInformer, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic(), c.cfg.Resync, namespace, nil)
+		informer := factory.ForResource(v1alpha1.ApplicationGroupResource).Informer()
+		if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.enqueue,
+			UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+			DeleteFunc: c.enqueue,
+		}); err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to watch application groups: %w", err)
+		}
+		factory.Start(informersCtx.Done())
+		factories = append(factories, factory)
+		informers = append(informers, informer)
+	}
+	for _, informer := range informers {
+		if !cache.WaitForCacheSync(informersCtx.Done(), informer.HasSynced) {
+			stop()
+			return func() {}, nil
+		}
+	}
+
+	c.informersMu.Lock()
+	c.informers = informers
+	c.informersMu.Unlock()
+	return stop, nil
+}
+
+// groupInformers returns the informers of the watched groups.
+func (c *Controller) groupInformers() []cache.SharedIndexInformer {
+	c.informersMu.RLock()
+	defer c.informersMu.RUnlock()
+	return c.informers
+}
+
+// crdInstalled reports whether the api server serves the ApplicationGroup resource,
+// along with the error of the discovery.
+func (c *Controller) crdInstalled() (bool, error) {
+	resources, err := c.client().Discovery().ServerResourcesForGroupVersion(v1alpha1.GroupVersion.String())
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == v1alpha1.ApplicationGroupResource.Resource {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("%s is not served", v1alpha1.ApplicationGroupResource)
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Errorf("failed to get key of application group: %v", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// enqueueNamespace queues every group in the namespace.
+func (c *Controller) enqueueNamespace(namespace string) {
+	for _, informer := range c.groupInformers() {
+		objs, err := informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			log.Errorf("failed to list application groups of namespace %s: %v", namespace, err)
+			continue
+		}
+		for _, obj := range objs {
+			c.enqueue(obj)
+		}
+	}
+}
+
+// enqueueAll queues every group, after service changes have been missed.
+func (c *Controller) enqueueAll() {
+	for _, informer := range c.groupInformers() {
+		for _, obj := range informer.GetIndexer().List() {
+			c.enqueue(obj)
+		}
+	}
+}
+
+// processNextItem reconciles the next group of the queue, it returns false once the queue is shut down.
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.reconcile(ctx, key); err != nil {
+		log.Warnf("failed to reconcile application group %s, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// reconcile computes the status of the group and writes it when it changed.
+func (c *Controller) reconcile(ctx context.Context, key string) error {
+	obj, found, err := c.get(key)
+	if err != nil || !found {
+		return err
+	}
+	var group v1alpha1.ApplicationGroup
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &group); err != nil {
+		return fmt.Errorf("failed to decode application group: %w", err)
+	}
+
+	status, err := groupStatus(group, c.listServices)
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(group.Status, status) {
+		return nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %w", err)
+	}
+	updated := obj.DeepCopy()
+	updated.Object["status"] = content
+	_, err = c.dynamic().Resource(v1alpha1.ApplicationGroupResource).Namespace(group.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	// the update of the group queues it again
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.recordTransition(updated, group, status)
+	return nil
+}
+
+// recordTransition reports the transition of the group when its Ready condition
+// changed, groups seen for the first time are not reported.
+func (c *Controller) recordTransition(obj runtime.Object, group v1alpha1.ApplicationGroup, current v1alpha1.ApplicationGroupStatus) {
+	was := meta.FindStatusCondition(group.Status.Conditions, v1alpha1.ConditionReady)
+	now := meta.FindStatusCondition(current.Conditions, v1alpha1.ConditionReady)
+	if c.cfg.Reporter == nil || was == nil || now == nil || was.Status == now.Status {
+		return
+	}
+	n := models.Notification{Cluster: handlers.LocalCluster(), Namespace: group.Namespace, Kind: "ApplicationGroup", Name: group.Name,
+		Message: now.Message, Reason: ReasonGroupDegraded, Severity: models.SeverityWarning,
+		Health: models.HealthDegraded, PreviousHealth: models.HealthHealthy}
+	if now.Status == metav1.ConditionTrue {
+		n.Reason, n.Severity = ReasonGroupRecovered, models.SeverityNormal
+		n.Health, n.PreviousHealth = models.HealthHealthy, models.HealthDegraded
+	}
+	c.cfg.Reporter.Report(obj, n)
+}
+
+// get returns the group of the key from the informers.
+func (c *Controller) get(key string) (*unstructured.Unstructured, bool, error) {
+	for _, informer := range c.groupInformers() {
+		obj, found, err := informer.GetIndexer().GetByKey(key)
+		if err != nil || !found {
+			continue
+		}
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected object %T", obj)
+		}
+		return u, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/api/v1alpha1"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "shop"
+
+// workload is a service of the fake workload cache along with the labels of its workload.
+type workload struct {
+	labels  labels.Set
+	service models.Service
+}
+
+var workloads = []workload{
+	{labels: labels.Set{"app": "checkout"}, service: models.Service{Namespace: testNamespace, Kind: "Deployment", Name: "checkout",
+		RunningPodsCount: 2, Replicas: models.ReplicaStatus{Desired: 3, Ready: 2}}},
+	{labels: labels.Set{"app": "payments"}, service: models.Service{Namespace: testNamespace, Kind: "Deployment", Name: "payments",
+		RunningPodsCount: 2, Replicas: models.ReplicaStatus{Desired: 2, Ready: 2}}},
+	{labels: labels.Set{"app": "payments"}, service: models.Service{Namespace: testNamespace, Kind: "StatefulSet", Name: "payments-db",
+		RunningPodsCount: 1, Replicas: models.ReplicaStatus{Desired: 1, Ready: 1}}},
+}
+
+// listWorkloads lists the services of workloads like the workload cache does.
+func listWorkloads(filter handlers.ServiceFilter) ([]models.Service, []string, error) {
+	services := make([]models.Service, 0)
+	for _, w := range workloads {
+		if w.service.Namespace != filter.Namespace || (filter.Kind != "" && !strings.EqualFold(filter.Kind, w.service.Kind)) {
+			continue
+		}
+		if filter.Selector != nil && !filter.Selector.Matches(w.labels) {
+			continue
+		}
+		services = append(services, w.service)
+	}
+	return services, nil, nil
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func newGroup(members ...v1alpha1.Member) v1alpha1.ApplicationGroup {
+	return v1alpha1.ApplicationGroup{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "ApplicationGroup"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "shop", Generation: 3},
+		Spec:       v1alpha1.ApplicationGroupSpec{Members: members},
+	}
+}
+
+func selectApp(app string) metav1.LabelSelector {
+	return metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+}
+
+func TestGroupStatus(t *testing.T) {
+	transitioned := metav1.NewTime(time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name             string
+		group            v1alpha1.ApplicationGroup
+		list             func(handlers.ServiceFilter) ([]models.Service, []string, error)
+		wantReadyMembers int32
+		wantReady        metav1.ConditionStatus
+		wantDegraded     string
+		wantMembers      []v1alpha1.MemberStatus
+	}{
+		{
+			name:             "members with their minimum of healthy pods",
+			group:            newGroup(v1alpha1.Member{Name: "checkout", Selector: selectApp("checkout"), MinHealthyPods: int32Ptr(2)}, v1alpha1.Member{Name: "payments", Selector: selectApp("payments")}),
+			wantReadyMembers: 2,
+			wantReady:        metav1.ConditionTrue,
+			wantDegraded:     reasonReplicasUnavailable,
+			wantMembers: []v1alpha1.MemberStatus{
+				{Name: "checkout", Services: []string{"Deployment/checkout"}, ReadyPods: 2, DesiredPods: 3, MinHealthyPods: 2, Healthy: true},
+				{Name: "payments", Services: []string{"Deployment/payments", "StatefulSet/payments-db"}, ReadyPods: 3, DesiredPods: 3, MinHealthyPods: 3, Healthy: true},
+			},
+		},
+		{
+			name:             "member without its desired pods",
+			group:            newGroup(v1alpha1.Member{Name: "checkout", Selector: selectApp("checkout")}, v1alpha1.Member{Name: "payments-db", Kind: "StatefulSet", Selector: selectApp("payments")}),
+			wantReadyMembers: 1,
+			wantReady:        metav1.ConditionFalse,
+			wantDegraded:     reasonMembersUnhealthy,
+			wantMembers: []v1alpha1.MemberStatus{
+				{Name: "checkout", Services: []string{"Deployment/checkout"}, ReadyPods: 2, DesiredPods: 3, MinHealthyPods: 3, Message: "2 of 3 required pods are ready"},
+				{Name: "payments-db", Services: []string{"StatefulSet/payments-db"}, ReadyPods: 1, DesiredPods: 1, MinHealthyPods: 1, Healthy: true},
+			},
+		},
+		{
+			name:             "member without workloads",
+			group:            newGroup(v1alpha1.Member{Name: "search", Selector: selectApp("search"), MinHealthyPods: int32Ptr(0)}),
+			wantReadyMembers: 0,
+			wantReady:        metav1.ConditionFalse,
+			wantDegraded:     reasonMembersUnhealthy,
+			wantMembers:      []v1alpha1.MemberStatus{{Name: "search", Message: "no workload matches the selector"}},
+		},
+		{
+			name: "member with an invalid selector",
+			group: newGroup(v1alpha1.Member{Name: "checkout", Selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: "Near", Values: []string{"checkout"}},
+			}}}),
+			wantReadyMembers: 0,
+			wantReady:        metav1.ConditionFalse,
+			wantDegraded:     reasonMembersUnhealthy,
+			wantMembers:      []v1alpha1.MemberStatus{{Name: "checkout", Message: `invalid selector: "Near" is not a valid label selector operator`}},
+		},
+		{
+			name:  "Failure workload cache not synced",
+			group: newGroup(v1alpha1.Member{Name: "checkout", Selector: selectApp("checkout")}),
+			list: func(handlers.ServiceFilter) ([]models.Service, []string, error) {
+				return nil, nil, errors.New("workload cache is not synced")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := tt.list
+			if list == nil {
+				list = listWorkloads
+			}
+			// the Ready condition was true before
+			tt.group.Status.Conditions = []metav1.Condition{{Type: v1alpha1.ConditionReady, Status: metav1.ConditionTrue,
+				Reason: reasonMembersHealthy, LastTransitionTime: transitioned}}
+
+			status, err := groupStatus(tt.group, list)
+			if strings.Contains(tt.name, "Failure") {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if status.ObservedGeneration != 3 || status.TotalMembers != int32(len(tt.group.Spec.Members)) || status.ReadyMembers != tt.wantReadyMembers {
+				t.Errorf("mismatched counts: generation=%d total=%d ready=%d", status.ObservedGeneration, status.TotalMembers, status.ReadyMembers)
+			}
+			if !reflect.DeepEqual(status.Members, tt.wantMembers) {
+				t.Errorf("mismatched members: want=%+v, got=%+v", tt.wantMembers, status.Members)
+			}
+			ready := meta.FindStatusCondition(status.Conditions, v1alpha1.ConditionReady)
+			if ready == nil || ready.Status != tt.wantReady || ready.ObservedGeneration != 3 {
+				t.Fatalf("mismatched Ready condition: %+v", ready)
+			}
+			if unchanged := ready.LastTransitionTime.Equal(&transitioned); unchanged != (tt.wantReady == metav1.ConditionTrue) {
+				t.Errorf("mismatched transition time of the Ready condition: %v", ready.LastTransitionTime)
+			}
+			if degraded := meta.FindStatusCondition(status.Conditions, v1alpha1.ConditionDegraded); degraded == nil || degraded.Reason != tt.wantDegraded {
+				t.Errorf("mismatched Degraded condition: %+v", degraded)
+			}
+		})
+	}
+}
+
+// newDiscoveryClient returns a fake clientset whose discovery serves the ApplicationGroup resource.
+func newDiscoveryClient() *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.Resources = []*metav1.APIResourceList{{
+		GroupVersion: v1alpha1.GroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "applicationgroups", Kind: "ApplicationGroup", Namespaced: true}},
+	}}
+	return client
+}
+
+func TestControllerWritesStatus(t *testing.T) {
+	group := newGroup(v1alpha1.Member{Name: "payments", Selector: selectApp("payments")})
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&group)
+	if err != nil {
+		t.Fatalf("failed to encode group %v", err)
+	}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.ApplicationGroupResource: "ApplicationGroupList"},
+		&unstructured.Unstructured{Object: content},
+	)
+	kubeClient := newDiscoveryClient()
+
+	// the group is reconciled once its namespace is watched
+	c := New(Config{Namespaces: []string{"team-b"}, Workers: 1},
+		func() kubernetes.Interface { return kubeClient }, func() dynamic.Interface { return dynClient })
+	c.listServices = listWorkloads
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Start(ctx, func() {}) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.groupInformers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the groups to be watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.SetNamespaces([]string{testNamespace})
+
+	var status v1alpha1.ApplicationGroupStatus
+	for status.ObservedGeneration == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the status")
+		}
+		time.Sleep(10 * time.Millisecond)
+		obj, err := dynClient.Resource(v1alpha1.ApplicationGroupResource).Namespace(testNamespace).Get(ctx, "shop", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get group %v", err)
+		}
+		var updated v1alpha1.ApplicationGroup
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &updated); err != nil {
+			t.Fatalf("failed to decode group %v", err)
+		}
+		status = updated.Status
+	}
+	if status.ObservedGeneration != 3 || status.ReadyMembers != 1 || !meta.IsStatusConditionTrue(status.Conditions, v1alpha1.ConditionReady) {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestControllerWithoutCRD(t *testing.T) {
+	c := New(Config{Workers: 1}, func() kubernetes.Interface { return fake.NewSimpleClientset() }, func() dynamic.Interface { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Start(ctx, func() {}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if c.informers != nil {
+		t.Errorf("application groups watched without the CRD")
+	}
+}
+
+// fakeReporter collects the reported transitions as "<severity> <reason> <message>".
+type fakeReporter struct {
+	reported []string
+}
+
+func (r *fakeReporter) Report(_ runtime.Object, n models.Notification) {
+	r.reported = append(r.reported, n.Severity+" "+n.Reason+" "+n.Message)
+}
+
+func TestRecordTransition(t *testing.T) {
+	condition := func(status metav1.ConditionStatus, message string) v1alpha1.ApplicationGroupStatus {
+		return v1alpha1.ApplicationGroupStatus{Conditions: []metav1.Condition{{Type: v1alpha1.ConditionReady, Status: status, Message: message}}}
+	}
+	tests := []struct {
+		name       string
+		previous   v1alpha1.ApplicationGroupStatus
+		current    v1alpha1.ApplicationGroupStatus
+		wantReport string
+	}{
+		{
+			name:       "group degrades",
+			previous:   condition(metav1.ConditionTrue, ""),
+			current:    condition(metav1.ConditionFalse, "members below their minimum of healthy pods: checkout"),
+			wantReport: "Warning GroupDegraded members below their minimum of healthy pods: checkout",
+		},
+		{
+			name:       "group recovers",
+			previous:   condition(metav1.ConditionFalse, ""),
+			current:    condition(metav1.ConditionTrue, "every member runs its minimum of healthy pods"),
+			wantReport: "Normal GroupRecovered every member runs its minimum of healthy pods",
+		},
+		{
+			name:     "unchanged group",
+			previous: condition(metav1.ConditionFalse, ""),
+			current:  condition(metav1.ConditionFalse, "members below their minimum of healthy pods: payments"),
+		},
+		{
+			name:    "new group",
+			current: condition(metav1.ConditionFalse, "members below their minimum of healthy pods: checkout"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &fakeReporter{}
+			c := New(Config{Reporter: reporter}, nil, nil)
+			group := newGroup()
+			group.Status = tt.previous
+			c.recordTransition(&unstructured.Unstructured{}, group, tt.current)
+			if got := strings.Join(reporter.reported, "\n"); got != tt.wantReport {
+				t.Errorf("mismatched report: want=%q, got=%q", tt.wantReport, got)
+			}
+		})
+	}
+}
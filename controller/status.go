@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shani1998/k8s-utility-controller/api/v1alpha1"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the reasons of the conditions of an ApplicationGroup
+const (
+	reasonMembersHealthy      = "MembersHealthy"
+	reasonMembersUnhealthy    = "MembersUnhealthy"
+	reasonReplicasUnavailable = "ReplicasUnavailable"
+)
+
+// groupStatus computes the status of the group from the services of its members.
+// It fails with the errors of list which are temporary, the others are reported
+// in the status of the member.
+func groupStatus(group v1alpha1.ApplicationGroup, list func(handlers.ServiceFilter) ([]models.Service, []string, error)) (v1alpha1.ApplicationGroupStatus, error) {
+	status := v1alpha1.ApplicationGroupStatus{
+		ObservedGeneration: group.Generation,
+		Members:            make([]v1alpha1.MemberStatus, 0, len(group.Spec.Members)),
+		TotalMembers:       int32(len(group.Spec.Members)),
+		// the transition times of unchanged conditions are kept
+		Conditions: append([]metav1.Condition(nil), group.Status.Conditions...),
+	}
+	var unhealthy, missingReplicas []string
+	for _, member := range group.Spec.Members {
+		memberStatus, err := memberStatus(group.Namespace, member, list)
+		if err != nil {
+			return v1alpha1.ApplicationGroupStatus{}, err
+		}
+		status.Members = append(status.Members, memberStatus)
+		switch {
+		case !memberStatus.Healthy:
+			unhealthy = append(unhealthy, member.Name)
+		case memberStatus.ReadyPods < memberStatus.DesiredPods:
+			status.ReadyMembers++
+			missingReplicas = append(missingReplicas, member.Name)
+		default:
+			status.ReadyMembers++
+		}
+	}
+
+	ready := metav1.Condition{Type: v1alpha1.ConditionReady, ObservedGeneration: group.Generation,
+		Status: metav1.ConditionTrue, Reason: reasonMembersHealthy, Message: "every member runs its minimum of healthy pods"}
+	degraded := metav1.Condition{Type: v1alpha1.ConditionDegraded, ObservedGeneration: group.Generation,
+		Status: metav1.ConditionFalse, Reason: reasonMembersHealthy, Message: "every member runs all desired pods"}
+	switch {
+	case len(unhealthy) > 0:
+		ready.Status, ready.Reason = metav1.ConditionFalse, reasonMembersUnhealthy
+		ready.Message = "members below their minimum of healthy pods: " + strings.Join(unhealthy, ", ")
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, ready.Reason, ready.Message
+	case len(missingReplicas) > 0:
+		degraded.Status, degraded.Reason = metav1.ConditionTrue, reasonReplicasUnavailable
+		degraded.Message = "members running fewer pods than desired: " + strings.Join(missingReplicas, ", ")
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+	meta.SetStatusCondition(&status.Conditions, degraded)
+	return status, nil
+}
+
+// memberStatus sums the pods of the services selected by the member in the namespace.
+func memberStatus(namespace string, member v1alpha1.Member, list func(handlers.ServiceFilter) ([]models.Service, []string, error)) (v1alpha1.MemberStatus, error) {
+	status := v1alpha1.MemberStatus{Name: member.Name}
+	selector, err := metav1.LabelSelectorAsSelector(&member.Selector)
+	if err != nil {
+		status.Message = fmt.Sprintf("invalid selector: %v", err)
+		return status, nil
+	}
+	cluster := member.Cluster
+	if cluster == "" {
+		cluster = handlers.LocalCluster()
+	}
+	services, _, err := list(handlers.ServiceFilter{Cluster: cluster, Namespace: namespace, Kind: member.Kind, Selector: selector})
+	if err != nil {
+		if handlers.IsRetryable(err) {
+			return status, err
+		}
+		status.Message = err.Error()
+		return status, nil
+	}
+
+	for _, svc := range services {
+		status.Services = append(status.Services, svc.Kind+"/"+svc.Name)
+		status.ReadyPods += int32(svc.RunningPodsCount)
+		status.DesiredPods += svc.Replicas.Desired
+	}
+	status.MinHealthyPods = status.DesiredPods
+	if member.MinHealthyPods != nil {
+		status.MinHealthyPods = *member.MinHealthyPods
+	}
+	switch {
+	case len(services) == 0:
+		status.Message = "no workload matches the selector"
+	case status.ReadyPods < status.MinHealthyPods:
+		status.Message = fmt.Sprintf("%d of %d required pods are ready", status.ReadyPods, status.MinHealthyPods)
+	default:
+		status.Healthy = true
+	}
+	return status, nil
+}
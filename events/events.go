@@ -0,0 +1,239 @@
+// Package events records Kubernetes Events on the workloads whose health changes,
+// so `kubectl describe` and event exporters tell a service went down without
+// anybody polling the api, and passes the transitions on to the notifier. Events
+// are recorded by the leader only.
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// the reasons of the events recorded on workloads
+const (
+	ReasonServiceDegraded  = "ServiceDegraded"
+	ReasonServiceDown      = "ServiceDown"
+	ReasonServiceRecovered = "ServiceRecovered"
+)
+
+// Config configures the recorded events.
+type Config struct {
+	// Record sends the transitions to the api server as Kubernetes Events.
+	Record bool
+	// Notify is passed every transition, e.g. to send it to webhooks, nil passes none.
+	Notify func(models.Notification)
+	// Component is reported as the source of the events.
+	Component string
+	// Burst is the number of events recorded per object before they are rate limited.
+	Burst int
+	// Interval is how often an event is recorded per object once the burst is used up.
+	Interval time.Duration
+}
+
+// healthState is the last known health of a workload.
+type healthState struct {
+	health string
+	// reported is set when an event was recorded for the unhealthy state, only
+	// recoveries of reported states are recorded
+	reported bool
+}
+
+// Recorder reports a transition whenever the health of a workload of the local cluster
+// changes between Healthy, Degraded and Down. Rollouts in progress are not reported.
+type Recorder struct {
+	cfg    Config
+	client func() kubernetes.Interface
+	// listServices returns the services matching a filter, handlers.ListServices
+	// outside of tests
+	listServices func(handlers.ServiceFilter) ([]models.Service, []string, error)
+
+	mu     sync.Mutex
+	health map[string]healthState
+
+	// broadcasterMu guards the broadcaster, which is replaced when the rate limit changes,
+	// and the sink it records to while the recorder runs
+	broadcasterMu sync.RWMutex
+	broadcaster   record.EventBroadcaster
+	recorder      record.EventRecorder
+	sink          watch.Interface
+}
+
+// New returns a recorder for cfg, client returns the kube client once it has been initialized.
+// Repeated events of an object are aggregated and rate limited by the broadcaster.
+func New(cfg Config, client func() kubernetes.Interface) *Recorder {
+	r := &Recorder{cfg: cfg, client: client, listServices: handlers.ListServices}
+	r.broadcaster, r.recorder = r.newBroadcaster(cfg.Burst, cfg.Interval)
+	return r
+}
+
+// newBroadcaster returns a broadcaster which records burst events per object before it
+// records at most one every interval, along with its recorder.
+func (r *Recorder) newBroadcaster(burst int, interval time.Duration) (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcaster(record.WithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: burst,
+		QPS:       float32(1 / interval.Seconds()),
+	}))
+	return broadcaster, broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: r.cfg.Component})
+}
+
+// startSink sends the events of the broadcaster to the api server.
+func (r *Recorder) startSink(broadcaster record.EventBroadcaster) watch.Interface {
+	return broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: r.client().CoreV1().Events("")})
+}
+
+// SetRateLimit changes the number of events recorded per object before they are rate
+// limited and how often one is recorded after, the aggregation of the events recorded
+// so far starts over.
+func (r *Recorder) SetRateLimit(burst int, interval time.Duration) {
+	broadcaster, recorder := r.newBroadcaster(burst, interval)
+	r.broadcasterMu.Lock()
+	defer r.broadcasterMu.Unlock()
+	previous := r.broadcaster
+	r.broadcaster, r.recorder = broadcaster, recorder
+	if r.sink != nil {
+		r.sink.Stop()
+		r.sink = r.startSink(broadcaster)
+	}
+	previous.Shutdown()
+	log.Infof("recording %d events per object before recording one every %v", burst, interval)
+}
+
+// Report records the event of the transition n of obj and passes n on to Notify,
+// for other leader runnables which report on their objects. Events are only sent
+// while the recorder runs.
+func (r *Recorder) Report(obj runtime.Object, n models.Notification) {
+	if n.ID == "" {
+		n.ID = uuid.NewString()
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	if r.cfg.Record {
+		r.broadcasterMu.RLock()
+		r.recorder.Event(obj, n.Severity, n.Reason, n.Message)
+		r.broadcasterMu.RUnlock()
+	}
+	if r.cfg.Notify != nil {
+		r.cfg.Notify(n)
+	}
+}
+
+func (r *Recorder) Name() string { return "event recorder" }
+
+// Start sends the events to the api server and reports the health transitions
+// until ctx is cancelled.
+func (r *Recorder) Start(ctx context.Context, ready func()) error {
+	if r.cfg.Record {
+		r.broadcasterMu.Lock()
+		r.sink = r.startSink(r.broadcaster)
+		r.broadcasterMu.Unlock()
+		defer func() {
+			r.broadcasterMu.Lock()
+			defer r.broadcasterMu.Unlock()
+			r.sink.Stop()
+			r.sink = nil
+		}()
+	}
+
+	r.resync()
+	ready()
+	handlers.WatchServiceEvents(ctx.Done(), r.observe, r.resync)
+	return nil
+}
+
+// resync takes the health of the services from the workload cache, the transitions
+// missed in between are not reported.
+func (r *Recorder) resync() {
+	services, _, err := r.listServices(handlers.ServiceFilter{Cluster: handlers.LocalCluster()})
+	if err != nil {
+		log.Warnf("failed to read the health of the services, transitions are reported once known: %v", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.health = make(map[string]healthState, len(services))
+	for _, svc := range services {
+		if svc.Health != models.HealthProgressing {
+			// an unhealthy state was reported before the recorder started, e.g. by the previous leader
+			r.health[serviceKey(svc)] = healthState{health: svc.Health, reported: svc.Health != models.HealthHealthy}
+		}
+	}
+}
+
+// observe reports the transition of obj when the change of the service is a health transition.
+func (r *Recorder) observe(ev models.ServiceEvent, obj runtime.Object) {
+	// the transition is reported without holding the lock, so a slow notifier does
+	// not hold up the events of other services
+	if n, ok := r.transition(ev, obj); ok {
+		r.Report(obj, n)
+	}
+}
+
+// transition updates the known health of the service and returns the notification
+// of the transition, false when there is nothing to report.
+func (r *Recorder) transition(ev models.ServiceEvent, obj runtime.Object) (models.Notification, bool) {
+	svc := ev.Service
+	if svc.Cluster != handlers.LocalCluster() {
+		return models.Notification{}, false
+	}
+	key := serviceKey(svc)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ev.Type == models.EventDeleted {
+		delete(r.health, key)
+		return models.Notification{}, false
+	}
+	if svc.Health == models.HealthProgressing {
+		return models.Notification{}, false
+	}
+
+	previous, known := r.health[key]
+	current := healthState{health: svc.Health}
+	defer func() { r.health[key] = current }()
+	// a workload seen for the first time, e.g. created while its pods start, is not reported
+	if !known || previous.health == svc.Health || obj == nil {
+		current.reported = previous.reported && previous.health == svc.Health
+		return models.Notification{}, false
+	}
+
+	n := models.Notification{Cluster: svc.Cluster, Namespace: svc.Namespace, Kind: svc.Kind, Name: svc.Name,
+		ApplicationGroup: svc.ApplicationGroup, Health: svc.Health, PreviousHealth: previous.health, Severity: models.SeverityWarning}
+	switch svc.Health {
+	case models.HealthDegraded:
+		n.Reason = ReasonServiceDegraded
+		n.Message = fmt.Sprintf("%s %s runs %d of %d desired pods", svc.Kind, svc.Name, svc.Replicas.Ready, svc.Replicas.Desired)
+		current.reported = true
+	case models.HealthDown:
+		n.Reason = ReasonServiceDown
+		n.Message = fmt.Sprintf("%s %s has no ready pods", svc.Kind, svc.Name)
+		current.reported = true
+	case models.HealthHealthy:
+		if !previous.reported {
+			return models.Notification{}, false
+		}
+		n.Reason, n.Severity = ReasonServiceRecovered, models.SeverityNormal
+		n.Message = fmt.Sprintf("%s %s recovered with %d ready pods", svc.Kind, svc.Name, svc.RunningPodsCount)
+	default:
+		return models.Notification{}, false
+	}
+	return n, true
+}
+
+// serviceKey identifies the workload of a service.
+func serviceKey(svc models.Service) string {
+	return fmt.Sprintf("%s/%s/%s/%s", svc.Cluster, svc.Namespace, svc.Kind, svc.Name)
+}
@@ -0,0 +1,164 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/handlers"
+	"github.com/shani1998/k8s-utility-controller/models"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var checkoutDeployment = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout"}}
+
+// checkout returns the checkout service with the given health and ready pods out of three.
+func checkout(health string, ready int32) models.Service {
+	return models.Service{Namespace: "shop", Kind: "Deployment", Name: "checkout", Health: health, RunningPodsCount: int(ready),
+		Replicas: models.ReplicaStatus{Desired: 3, Ready: ready}}
+}
+
+func TestRecorderObserve(t *testing.T) {
+	tests := []struct {
+		name       string
+		known      []models.Service
+		changes    []models.ServiceEvent
+		wantEvents []string
+	}{
+		{
+			name:  "service degrades, goes down and recovers",
+			known: []models.Service{checkout(models.HealthHealthy, 3)},
+			changes: []models.ServiceEvent{
+				{Type: models.EventModified, Service: checkout(models.HealthDegraded, 2)},
+				{Type: models.EventModified, Service: checkout(models.HealthDegraded, 1)},
+				{Type: models.EventModified, Service: checkout(models.HealthDown, 0)},
+				{Type: models.EventModified, Service: checkout(models.HealthHealthy, 3)},
+			},
+			wantEvents: []string{
+				"Warning ServiceDegraded Deployment checkout runs 2 of 3 desired pods",
+				"Warning ServiceDown Deployment checkout has no ready pods",
+				"Normal ServiceRecovered Deployment checkout recovered with 3 ready pods",
+			},
+		},
+		{
+			name:  "rollouts are not reported",
+			known: []models.Service{checkout(models.HealthHealthy, 3)},
+			changes: []models.ServiceEvent{
+				{Type: models.EventModified, Service: checkout(models.HealthProgressing, 2)},
+				{Type: models.EventModified, Service: checkout(models.HealthHealthy, 3)},
+			},
+		},
+		{
+			name: "new service starting its pods is not reported",
+			changes: []models.ServiceEvent{
+				{Type: models.EventAdded, Service: checkout(models.HealthProgressing, 0)},
+				{Type: models.EventModified, Service: checkout(models.HealthDown, 0)},
+				{Type: models.EventModified, Service: checkout(models.HealthHealthy, 3)},
+			},
+		},
+		{
+			name:  "recovery of a service down before the recorder started",
+			known: []models.Service{checkout(models.HealthDown, 0)},
+			changes: []models.ServiceEvent{
+				{Type: models.EventModified, Service: checkout(models.HealthHealthy, 3)},
+			},
+			wantEvents: []string{"Normal ServiceRecovered Deployment checkout recovered with 3 ready pods"},
+		},
+		{
+			name:  "deleted service is forgotten",
+			known: []models.Service{checkout(models.HealthHealthy, 3)},
+			changes: []models.ServiceEvent{
+				{Type: models.EventDeleted, Service: checkout(models.HealthHealthy, 3)},
+				{Type: models.EventAdded, Service: checkout(models.HealthDown, 0)},
+			},
+		},
+		{
+			name:  "services of other clusters are not reported",
+			known: []models.Service{checkout(models.HealthHealthy, 3)},
+			changes: []models.ServiceEvent{
+				{Type: models.EventModified, Service: models.Service{Cluster: "prod-2", Namespace: "shop", Kind: "Deployment", Name: "checkout", Health: models.HealthDown}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(Config{Record: true, Component: "k8s-utility-controller", Burst: 10, Interval: time.Minute}, func() kubernetes.Interface { return nil })
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			r.listServices = func(handlers.ServiceFilter) ([]models.Service, []string, error) { return tt.known, nil, nil }
+
+			r.resync()
+			for _, ev := range tt.changes {
+				r.observe(ev, checkoutDeployment)
+			}
+			close(recorder.Events)
+			var got []string
+			for event := range recorder.Events {
+				got = append(got, event)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.wantEvents, "\n") {
+				t.Errorf("mismatched events: want=%q, got=%q", tt.wantEvents, got)
+			}
+		})
+	}
+}
+
+func TestRecorderNotifiesWithoutLock(t *testing.T) {
+	var locked []bool
+	r := New(Config{Component: "k8s-utility-controller"}, func() kubernetes.Interface { return nil })
+	r.cfg.Notify = func(models.Notification) {
+		// a slow notifier must not hold up the transitions of other services
+		unlocked := r.mu.TryLock()
+		if unlocked {
+			r.mu.Unlock()
+		}
+		locked = append(locked, !unlocked)
+	}
+	r.listServices = func(handlers.ServiceFilter) ([]models.Service, []string, error) {
+		return []models.Service{checkout(models.HealthHealthy, 3)}, nil, nil
+	}
+
+	r.resync()
+	r.observe(models.ServiceEvent{Type: models.EventModified, Service: checkout(models.HealthDown, 0)}, checkoutDeployment)
+	if len(locked) != 1 || locked[0] {
+		t.Errorf("expected one notification sent without holding the lock, got locked=%v", locked)
+	}
+}
+
+func TestRecorderSetRateLimit(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r := New(Config{Record: true, Component: "k8s-utility-controller", Burst: 10, Interval: time.Minute}, func() kubernetes.Interface { return client })
+	r.sink = r.startSink(r.broadcaster)
+	r.SetRateLimit(1, time.Hour)
+	t.Cleanup(func() {
+		r.sink.Stop()
+		r.broadcaster.Shutdown()
+	})
+
+	for _, reason := range []string{ReasonServiceDegraded, ReasonServiceDown, ReasonServiceRecovered} {
+		r.Report(checkoutDeployment, models.Notification{Reason: reason, Severity: models.SeverityWarning, Message: reason})
+	}
+	recorded := func() int {
+		list, err := client.CoreV1().Events("shop").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list events: %v", err)
+		}
+		return len(list.Items)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for recorded() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no event recorded after the rate limit changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := recorded(); got != 1 {
+		t.Errorf("mismatched number of events with a burst of one: want=1, got=%d", got)
+	}
+}
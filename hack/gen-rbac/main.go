@@ -0,0 +1,191 @@
+// gen-rbac renders the RBAC manifests the controller needs. By default it
+// generates a Role and RoleBinding per watched namespace, with --all-namespaces
+// it generates a ClusterRole and ClusterRoleBinding instead.
+package main
+
+import (
+	"os"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+const name = "k8s-utility-controller"
+
+// rule is a single policy rule granted to the controller's service account.
+type rule struct {
+	APIGroups []string
+	Resources []string
+	Verbs     []string
+}
+
+// rules lists the permissions the controller needs in every watched namespace.
+var rules = []rule{
+	{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+	{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "daemonsets"}, Verbs: []string{"list", "watch"}},
+	{APIGroups: []string{"batch"}, Resources: []string{"jobs", "cronjobs"}, Verbs: []string{"list", "watch"}},
+}
+
+// eventRules lists the permissions needed to record events on the workloads in every watched namespace.
+var eventRules = []rule{
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+}
+
+// applicationGroupRules lists the permissions the ApplicationGroup controller needs in every watched namespace.
+var applicationGroupRules = []rule{
+	{APIGroups: []string{"utility.shani1998.io"}, Resources: []string{"applicationgroups"}, Verbs: []string{"list", "watch"}},
+	{APIGroups: []string{"utility.shani1998.io"}, Resources: []string{"applicationgroups/status"}, Verbs: []string{"update"}},
+}
+
+var rbacTemplate = template.Must(template.New("rbac").Funcs(template.FuncMap{"list": quoteList}).Parse(
+	`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .ServiceAccountNamespace }}
+{{- if .ClusterWide }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ .Name }}
+rules:
+{{- range .Rules }}
+  - apiGroups: [{{ list .APIGroups }}]
+    resources: [{{ list .Resources }}]
+    verbs: [{{ list .Verbs }}]
+{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ .Name }}
+subjects:
+  - kind: ServiceAccount
+    name: {{ .Name }}
+    namespace: {{ .ServiceAccountNamespace }}
+roleRef:
+  kind: ClusterRole
+  name: {{ .Name }}
+  apiGroup: rbac.authorization.k8s.io
+{{- else }}
+{{- range $ns := .Namespaces }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: {{ $.Name }}
+  namespace: {{ $ns }}
+rules:
+{{- range $.Rules }}
+  - apiGroups: [{{ list .APIGroups }}]
+    resources: [{{ list .Resources }}]
+    verbs: [{{ list .Verbs }}]
+{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: {{ $.Name }}
+  namespace: {{ $ns }}
+subjects:
+  - kind: ServiceAccount
+    name: {{ $.Name }}
+    namespace: {{ $.ServiceAccountNamespace }}
+roleRef:
+  kind: Role
+  name: {{ $.Name }}
+  apiGroup: rbac.authorization.k8s.io
+{{- end }}
+{{- end }}
+{{- if .LeaderElection }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: {{ .Name }}-leader-election
+  namespace: {{ .ServiceAccountNamespace }}
+rules:
+  - apiGroups: ["coordination.k8s.io"]
+    resources: ["leases"]
+    verbs: ["get", "create", "update"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: {{ .Name }}-leader-election
+  namespace: {{ .ServiceAccountNamespace }}
+subjects:
+  - kind: ServiceAccount
+    name: {{ .Name }}
+    namespace: {{ .ServiceAccountNamespace }}
+roleRef:
+  kind: Role
+  name: {{ .Name }}-leader-election
+  apiGroup: rbac.authorization.k8s.io
+{{- end }}
+{{- if .Auth }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ .Name }}-auth-delegator
+subjects:
+  - kind: ServiceAccount
+    name: {{ .Name }}
+    namespace: {{ .ServiceAccountNamespace }}
+roleRef:
+  kind: ClusterRole
+  name: system:auth-delegator
+  apiGroup: rbac.authorization.k8s.io
+{{- end }}
+`))
+
+func quoteList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, `"`+item+`"`)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func main() {
+	namespaces := pflag.StringSlice("namespaces", []string{"default"}, "namespaces the controller watches, a Role is generated for each of them")
+	allNamespaces := pflag.Bool("all-namespaces", false, "generate a ClusterRole for watching all namespaces")
+	saNamespace := pflag.String("service-account-namespace", "default", "namespace the controller is deployed in")
+	auth := pflag.Bool("auth", true, "bind the system:auth-delegator ClusterRole needed to review tokens and access of api callers")
+	leaderElection := pflag.Bool("leader-election", true, "grant access to the leader election Lease in the namespace the controller is deployed in")
+	events := pflag.Bool("events", true, "grant access to record events on the workloads in every watched namespace")
+	applicationGroups := pflag.Bool("application-groups", true, "grant access to the ApplicationGroups and their status in every watched namespace")
+	customResources := pflag.StringSlice("custom-resources", nil, "custom resources watched as workload sources, in <group>/<resource> form")
+	pflag.Parse()
+
+	if *events {
+		rules = append(rules, eventRules...)
+	}
+	if *applicationGroups {
+		rules = append(rules, applicationGroupRules...)
+	}
+	for _, cr := range *customResources {
+		group, resource, ok := strings.Cut(cr, "/")
+		if !ok {
+			log.Fatalf("invalid custom resource %q, expected <group>/<resource>", cr)
+		}
+		rules = append(rules, rule{APIGroups: []string{group}, Resources: []string{resource}, Verbs: []string{"list", "watch"}})
+	}
+
+	err := rbacTemplate.Execute(os.Stdout, map[string]interface{}{
+		"Name":                    name,
+		"ServiceAccountNamespace": *saNamespace,
+		"ClusterWide":             *allNamespaces,
+		"Namespaces":              *namespaces,
+		"Rules":                   rules,
+		"LeaderElection":          *leaderElection,
+		"Auth":                    *auth,
+	})
+	if err != nil {
+		log.Fatalf("failed to render rbac manifests: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clientIdentity maps the verified client certificate of the request to an identity,
+// the common name is the user and the organizations are the groups.
+func clientIdentity(r *http.Request) (user string, groups []string, ok bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", nil, false
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	return subject.CommonName, subject.Organization, true
+}
+
+// WithAuditLog logs every request along with the identity of its client certificate,
+// when the client authenticated with one.
+func WithAuditLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := log.Fields{
+			"requestId": requestID(r),
+			"method":    r.Method,
+			"path":      r.URL.Path,
+			"remote":    r.RemoteAddr,
+		}
+		if user, groups, ok := clientIdentity(r); ok {
+			fields["user"] = user
+			fields["groups"] = groups
+		}
+		log.WithFields(fields).Info("audit")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientIdentity(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      *tls.ConnectionState
+		wantUser   string
+		wantGroups []string
+		wantOk     bool
+	}{
+		{name: "plain http"},
+		{name: "tls without client certificate", state: &tls.ConnectionState{}},
+		{
+			name: "verified client certificate",
+			state: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "alice", Organization: []string{"team-a", "oncall"}}},
+			}}},
+			wantUser:   "alice",
+			wantGroups: []string{"team-a", "oncall"},
+			wantOk:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://test-service.com/services", nil)
+			req.TLS = tt.state
+			user, groups, ok := clientIdentity(req)
+			if user != tt.wantUser || !reflect.DeepEqual(groups, tt.wantGroups) || ok != tt.wantOk {
+				t.Errorf("mismatched identity: want=%v %v %v, got=%v %v %v", tt.wantUser, tt.wantGroups, tt.wantOk, user, groups, ok)
+			}
+		})
+	}
+}
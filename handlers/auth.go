@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// authCacheSize is the number of token reviews and access reviews kept in the decision caches
+const authCacheSize = 4096
+
+// the access callers need in a namespace to see its services
+const (
+	authorizedVerb     = "list"
+	authorizedGroup    = "apps"
+	authorizedResource = "deployments"
+)
+
+// authorizedPathVerb is the access callers need on the path of the endpoints
+// authorized with WithPathAuth, e.g. /metrics
+const authorizedPathVerb = "get"
+
+var (
+	errUnauthenticated = errors.New("request is not authenticated")
+	errNotAuthorized   = errors.New("request is not authorized")
+	// errReviewFailed wraps the errors of the review apis, e.g. when the controller
+	// lacks the RBAC to create reviews, they are not the fault of the caller
+	errReviewFailed = errors.New("access review failed")
+)
+
+// reviewWorkers is the number of access reviews of a request sent in parallel
+const reviewWorkers = 8
+
+// tokenReviews caches the users of the reviewed tokens, keyed on the token hash,
+// and accessReviews caches the access decisions, keyed on the cluster, user and namespace.
+var (
+	tokenReviews  = utilcache.NewLRUExpireCache(authCacheSize)
+	accessReviews = utilcache.NewLRUExpireCache(authCacheSize)
+)
+
+// AuthConfig configures the authentication and authorization of api requests.
+type AuthConfig struct {
+	// Enabled requires a bearer token on every request.
+	Enabled bool
+	// CacheTTL is how long token reviews and access reviews are cached.
+	CacheTTL time.Duration
+}
+
+// authConfig is the current AuthConfig, it can be replaced at runtime.
+var authConfig atomic.Pointer[AuthConfig]
+
+// SetAuthConfig replaces the auth config of WithAuth, the cached decisions are
+// dropped so they are reviewed again with the new settings.
+func SetAuthConfig(cfg AuthConfig) {
+	if old := authConfig.Swap(&cfg); old != nil && *old == cfg {
+		return
+	}
+	tokenReviews.RemoveAll(func(any) bool { return true })
+	accessReviews.RemoveAll(func(any) bool { return true })
+}
+
+type authorizedNamespacesKey struct{}
+
+// tokenReview is the cached outcome of a TokenReview.
+type tokenReview struct {
+	authenticated bool
+	user          authenticationv1.UserInfo
+}
+
+// WithAuth authenticates the bearer token of every request with the TokenReview api
+// and checks with the SubjectAccessReview api of every registered cluster in which of
+// its watched namespaces the caller can list deployments. The services of other
+// namespaces and clusters are hidden from the handler.
+// It follows the config set with SetAuthConfig, requests pass through while disabled.
+func WithAuth(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		cfg := authConfig.Load()
+		if cfg == nil || !cfg.Enabled {
+			handle(w, r, params)
+			return
+		}
+		ttl := cfg.CacheTTL
+		user, ok := authenticateRequest(w, r, ttl)
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		namespaces, err := authorize(r.Context(), user, query.Get(clusterParam), query.Get(namespaceParam), ttl)
+		if err != nil {
+			writeError(w, r, "failed to authorize request", err)
+			return
+		}
+		log.Debugf("request of user %s authorized for namespaces %v", user.Username, namespaces)
+		handle(w, r.WithContext(context.WithValue(r.Context(), authorizedNamespacesKey{}, namespaces)), params)
+	}
+}
+
+// WithPathAuth authenticates the bearer token of every request like WithAuth and only
+// passes the requests of callers which may get the path of the request as a non-resource
+// url, e.g. /metrics, checked with the SubjectAccessReview api of the local cluster.
+// It follows the config set with SetAuthConfig, requests pass through while disabled.
+func WithPathAuth(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		cfg := authConfig.Load()
+		if cfg == nil || !cfg.Enabled {
+			handle(w, r, params)
+			return
+		}
+		ttl := cfg.CacheTTL
+		user, ok := authenticateRequest(w, r, ttl)
+		if !ok {
+			return
+		}
+		allowed, err := reviewPathAccess(r.Context(), registeredClusters()[0], user, r.URL.Path, ttl)
+		if err == nil && !allowed {
+			err = fmt.Errorf("%w: user %s can not %s %s", errNotAuthorized, user.Username, authorizedPathVerb, r.URL.Path)
+		}
+		if err != nil {
+			writeError(w, r, "failed to authorize request", err)
+			return
+		}
+		handle(w, r, params)
+	}
+}
+
+// authenticateRequest authenticates the request and returns its user, on failure the
+// error is written and ok is false.
+func authenticateRequest(w http.ResponseWriter, r *http.Request, ttl time.Duration) (user authenticationv1.UserInfo, ok bool) {
+	user, err := authenticate(r, ttl)
+	if err != nil {
+		if errors.Is(err, errUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="k8s-utility-controller"`)
+		}
+		writeError(w, r, "failed to authenticate request", err)
+		return user, false
+	}
+	return user, true
+}
+
+// authorizedNamespaces returns the namespaces the caller may see per cluster, nil when
+// the request is not authorized per namespace.
+func authorizedNamespaces(r *http.Request) map[string]sets.Set[string] {
+	namespaces, _ := r.Context().Value(authorizedNamespacesKey{}).(map[string]sets.Set[string])
+	return namespaces
+}
+
+// authenticate reviews the bearer token of the request and returns its user.
+func authenticate(r *http.Request, ttl time.Duration) (authenticationv1.UserInfo, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return authenticationv1.UserInfo{}, fmt.Errorf("%w: missing bearer token", errUnauthenticated)
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(hash[:])
+	review, ok := tokenReviews.Get(key)
+	if !ok {
+		resp, err := kubeClient.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return authenticationv1.UserInfo{}, fmt.Errorf("%w: token review: %w", errReviewFailed, err)
+		}
+		if resp.Status.Error != "" {
+			log.Debugf("token review failed: %s", resp.Status.Error)
+		}
+		review = tokenReview{authenticated: resp.Status.Authenticated, user: resp.Status.User}
+		tokenReviews.Add(key, review, ttl)
+	}
+	if !review.(tokenReview).authenticated {
+		return authenticationv1.UserInfo{}, fmt.Errorf("%w: invalid bearer token", errUnauthenticated)
+	}
+	return review.(tokenReview).user, nil
+}
+
+// authorize returns the namespaces in which the user can list deployments per cluster,
+// the requested cluster and namespace only when set. The access is reviewed by the api
+// server of each cluster, a cluster maps to nil when the user can list them cluster wide
+// and the controller watches all its namespaces. Clusters which are unavailable or fail
+// the review are left out.
+func authorize(ctx context.Context, user authenticationv1.UserInfo, clusterName, namespace string, ttl time.Duration) (map[string]sets.Set[string], error) {
+	allowed := make(map[string]sets.Set[string])
+	found := false
+	for _, c := range registeredClusters() {
+		if clusterName != "" && c.name != clusterName {
+			continue
+		}
+		found = true
+		if err := c.unavailable(); err != nil {
+			if clusterName != "" {
+				return nil, fmt.Errorf("%w: %s: %v", errClusterUnavailable, c.name, err)
+			}
+			continue
+		}
+		namespaces, err := authorizeCluster(ctx, c, user, namespace, ttl)
+		if err != nil {
+			if c.local || clusterName != "" {
+				return nil, err
+			}
+			log.Warnf("failed to authorize user %s in cluster %s: %v", user.Username, c.name, err)
+			continue
+		}
+		if namespaces == nil || namespaces.Len() > 0 {
+			allowed[c.name] = namespaces
+		}
+	}
+	switch {
+	case !found:
+		return nil, fmt.Errorf("%w: %s", errUnknownCluster, clusterName)
+	case namespace != "" && len(allowed) == 0:
+		return nil, fmt.Errorf("%w: user %s can not %s %s in namespace %s", errNotAuthorized, user.Username, authorizedVerb, authorizedResource, namespace)
+	case len(allowed) == 0:
+		return nil, fmt.Errorf("%w: user %s can not %s %s in any watched namespace", errNotAuthorized, user.Username, authorizedVerb, authorizedResource)
+	}
+	return allowed, nil
+}
+
+// authorizeCluster returns the namespaces of the cluster in which the user can list
+// deployments, nil when the user can list them cluster wide and the controller watches
+// all namespaces of the cluster.
+func authorizeCluster(ctx context.Context, c *cluster, user authenticationv1.UserInfo, namespace string, ttl time.Duration) (sets.Set[string], error) {
+	candidates, allNamespaces := watchedNamespaces(c)
+	if namespace != "" {
+		candidates = []string{namespace}
+	} else if allNamespaces {
+		allowed, err := reviewAccess(ctx, c, user, metav1.NamespaceAll, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			return nil, nil
+		}
+	}
+
+	// in all-namespaces mode there is a review per namespace of the cached workloads
+	allowed := make([]bool, len(candidates))
+	errs := make([]error, len(candidates))
+	workqueue.ParallelizeUntil(ctx, reviewWorkers, len(candidates), func(i int) {
+		allowed[i], errs[i] = reviewAccess(ctx, c, user, candidates[i], ttl)
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	namespaces := sets.New[string]()
+	for i, ns := range candidates {
+		if allowed[i] {
+			namespaces.Insert(ns)
+		}
+	}
+	return namespaces, nil
+}
+
+// reviewAccess checks with the api server of the cluster whether the user can list
+// deployments in the namespace, all namespaces when empty.
+func reviewAccess(ctx context.Context, c *cluster, user authenticationv1.UserInfo, namespace string, ttl time.Duration) (bool, error) {
+	return subjectAccessReview(ctx, c, user, namespace, authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      authorizedVerb,
+			Group:     authorizedGroup,
+			Resource:  authorizedResource,
+		},
+	}, ttl)
+}
+
+// reviewPathAccess checks with the api server of the cluster whether the user can get
+// the non-resource url path.
+func reviewPathAccess(ctx context.Context, c *cluster, user authenticationv1.UserInfo, path string, ttl time.Duration) (bool, error) {
+	return subjectAccessReview(ctx, c, user, path, authorizationv1.SubjectAccessReviewSpec{
+		NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: path, Verb: authorizedPathVerb},
+	}, ttl)
+}
+
+// subjectAccessReview reviews the access of the user described by spec with the api
+// server of the cluster, the decision is cached for the user and target, a namespace or path.
+func subjectAccessReview(ctx context.Context, c *cluster, user authenticationv1.UserInfo, target string, spec authorizationv1.SubjectAccessReviewSpec, ttl time.Duration) (bool, error) {
+	groups := append([]string(nil), user.Groups...)
+	sort.Strings(groups)
+	key := strings.Join([]string{c.name, user.UID, user.Username, strings.Join(groups, ","), target}, "/")
+	if allowed, ok := accessReviews.Get(key); ok {
+		return allowed.(bool), nil
+	}
+
+	spec.User, spec.UID, spec.Groups = user.Username, user.UID, user.Groups
+	spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		spec.Extra[k] = authorizationv1.ExtraValue(v)
+	}
+	resp, err := c.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{Spec: spec}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("%w: subject access review in cluster %s: %w", errReviewFailed, c.name, err)
+	}
+	accessReviews.Add(key, resp.Status.Allowed, ttl)
+	return resp.Status.Allowed, nil
+}
+
+// watchedNamespaces returns the namespaces of the cluster watched by the cache. In
+// all-namespaces mode these are the namespaces of the cached workloads and allNamespaces is set.
+func watchedNamespaces(c *cluster) (namespaces []string, allNamespaces bool) {
+	watched := sets.New[string]()
+	_, informers := cachedInformers()
+	for _, wi := range informers {
+		if wi.cluster.name != c.name {
+			continue
+		}
+		if wi.namespace != metav1.NamespaceAll {
+			watched.Insert(wi.namespace)
+			continue
+		}
+		allNamespaces = true
+		watched.Insert(wi.informer.GetIndexer().ListIndexFuncValues(cache.NamespaceIndex)...)
+	}
+	return sets.List(watched), allNamespaces
+}
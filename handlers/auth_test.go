@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeAuth answers token reviews for the tokens of users and access reviews
+// for the namespaces the users may list deployments in, "" being cluster wide,
+// and for the non-resource paths they may get.
+type fakeAuth struct {
+	users         map[string]string
+	access        map[string][]string
+	paths         map[string][]string
+	tokenReviews  int
+	accessReviews int
+}
+
+func (f *fakeAuth) install(client *fake.Clientset) {
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		f.tokenReviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if user, ok := f.users[review.Spec.Token]; ok {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: user}}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		f.accessReviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		if attrs := review.Spec.NonResourceAttributes; attrs != nil {
+			review.Status.Allowed = attrs.Verb == authorizedPathVerb && slices.Contains(f.paths[review.Spec.User], attrs.Path)
+			return true, review, nil
+		}
+		attrs := review.Spec.ResourceAttributes
+		if attrs.Verb == authorizedVerb && attrs.Group == authorizedGroup && attrs.Resource == authorizedResource {
+			for _, ns := range f.access[review.Spec.User] {
+				if ns == attrs.Namespace || ns == metav1.NamespaceAll {
+					review.Status.Allowed = true
+				}
+			}
+		}
+		return true, review, nil
+	})
+}
+
+func newNamespacedDeployment(namespace, name string) runtime.Object {
+	return &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{appGroup: testAppGrp}},
+		Status:     appv1.DeploymentStatus{ReadyReplicas: 1},
+	}
+}
+
+// enableAuth enables WithAuth with empty decision caches until the test finishes.
+func enableAuth(t *testing.T, ttl time.Duration) {
+	SetAuthConfig(AuthConfig{Enabled: true, CacheTTL: ttl})
+	t.Cleanup(func() { SetAuthConfig(AuthConfig{}) })
+}
+
+func TestWithAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		token      string
+		query      string
+		wantCode   int
+		wantErr    string
+		wantNames  []string
+	}{
+		{
+			name:      "cluster wide access in all namespaces mode",
+			token:     "admin-token",
+			wantCode:  http.StatusOK,
+			wantNames: []string{"team-a/checkout", "team-b/payments"},
+		},
+		{
+			name:      "namespaced access in all namespaces mode",
+			token:     "alice-token",
+			wantCode:  http.StatusOK,
+			wantNames: []string{"team-a/checkout"},
+		},
+		{
+			name:       "namespaced access in watched namespaces",
+			namespaces: []string{"team-a", "team-b"},
+			token:      "alice-token",
+			wantCode:   http.StatusOK,
+			wantNames:  []string{"team-a/checkout"},
+		},
+		{
+			name:      "requested namespace allowed",
+			token:     "alice-token",
+			query:     "?namespace=team-a",
+			wantCode:  http.StatusOK,
+			wantNames: []string{"team-a/checkout"},
+		},
+		{
+			name:     "Failure missing token",
+			wantCode: http.StatusUnauthorized,
+			wantErr:  CodeUnauthenticated,
+		},
+		{
+			name:     "Failure invalid token",
+			token:    "stolen-token",
+			wantCode: http.StatusUnauthorized,
+			wantErr:  CodeUnauthenticated,
+		},
+		{
+			name:     "Failure requested namespace forbidden",
+			token:    "alice-token",
+			query:    "?namespace=team-b",
+			wantCode: http.StatusForbidden,
+			wantErr:  CodeForbidden,
+		},
+		{
+			name:     "Failure no namespace allowed",
+			token:    "bob-token",
+			wantCode: http.StatusForbidden,
+			wantErr:  CodeForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startFakeNamespacedCache(t, tt.namespaces,
+				newNamespacedDeployment("team-a", "checkout"),
+				newNamespacedDeployment("team-b", "payments"),
+			)
+			enableAuth(t, time.Minute)
+			auth := &fakeAuth{
+				users:  map[string]string{"admin-token": "admin", "alice-token": "alice", "bob-token": "bob"},
+				access: map[string][]string{"admin": {metav1.NamespaceAll}, "alice": {"team-a"}},
+			}
+			auth.install(kubeClient.(*fake.Clientset))
+
+			req := httptest.NewRequest("GET", "http://test-service.com/services"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			WithAuth(GetServices)(w, req, nil)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", tt.wantCode, w.Code, w.Body)
+			}
+			if strings.Contains(tt.name, "Failure") {
+				var resp models.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal error response %v", err)
+				}
+				if resp.Error.Code != tt.wantErr {
+					t.Errorf("mismatched error code: want=%v, got=%v", tt.wantErr, resp.Error.Code)
+				}
+				if tt.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+					t.Errorf("missing WWW-Authenticate header")
+				}
+				return
+			}
+
+			var services []models.ServiceV1
+			if err := json.Unmarshal(w.Body.Bytes(), &services); err != nil {
+				t.Fatalf("failed to unmarshal response %v", err)
+			}
+			names := make([]string, 0, len(services))
+			for _, svc := range services {
+				names = append(names, svc.Namespace+"/"+svc.Name)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("mismatched services: want=%v, got=%v", tt.wantNames, names)
+			}
+		})
+	}
+}
+
+func TestWithAuthCachesDecisions(t *testing.T) {
+	startFakeNamespacedCache(t, []string{"team-a", "team-b"},
+		newNamespacedDeployment("team-a", "checkout"),
+		newNamespacedDeployment("team-b", "payments"),
+	)
+	enableAuth(t, time.Minute)
+	auth := &fakeAuth{
+		users:  map[string]string{"alice-token": "alice"},
+		access: map[string][]string{"alice": {"team-a"}},
+	}
+	auth.install(kubeClient.(*fake.Clientset))
+
+	request := func() {
+		req := httptest.NewRequest("GET", "http://test-service.com/services", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		w := httptest.NewRecorder()
+		WithAuth(GetServices)(w, req, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", http.StatusOK, w.Code, w.Body)
+		}
+	}
+
+	request()
+	request()
+	if auth.tokenReviews != 1 || auth.accessReviews != 2 {
+		t.Errorf("decisions not cached: token reviews=%d, access reviews=%d", auth.tokenReviews, auth.accessReviews)
+	}
+
+	// a new config drops the cached decisions
+	SetAuthConfig(AuthConfig{Enabled: true, CacheTTL: time.Nanosecond})
+	request()
+	if auth.tokenReviews != 2 || auth.accessReviews != 4 {
+		t.Errorf("decisions kept across config changes: token reviews=%d, access reviews=%d", auth.tokenReviews, auth.accessReviews)
+	}
+
+	// expired decisions are reviewed again
+	time.Sleep(time.Millisecond)
+	request()
+	if auth.tokenReviews != 3 || auth.accessReviews != 6 {
+		t.Errorf("expired decisions reused: token reviews=%d, access reviews=%d", auth.tokenReviews, auth.accessReviews)
+	}
+
+	// disabled auth lets requests pass without reviews
+	SetAuthConfig(AuthConfig{})
+	w := httptest.NewRecorder()
+	WithAuth(GetServices)(w, httptest.NewRequest("GET", "http://test-service.com/services", nil), nil)
+	if w.Code != http.StatusOK || auth.tokenReviews != 3 {
+		t.Errorf("disabled auth reviewed the request: code=%d, token reviews=%d", w.Code, auth.tokenReviews)
+	}
+}
+
+func TestWithAuthAcrossClusters(t *testing.T) {
+	startFakeClusters(t, []string{"staging", "prod"}, map[string][]runtime.Object{
+		"staging": {newNamespacedDeployment("team-a", "checkout"), newNamespacedDeployment("team-b", "payments")},
+		"prod":    {newNamespacedDeployment("team-a", "checkout"), newNamespacedDeployment("team-b", "payments")},
+	})
+	enableAuth(t, time.Minute)
+	users := map[string]string{"alice-token": "alice"}
+	// alice may only list team-a of staging and team-b of prod
+	local := &fakeAuth{users: users, access: map[string][]string{"alice": {"team-a"}}}
+	remote := &fakeAuth{users: users, access: map[string][]string{"alice": {"team-b"}}}
+	for _, c := range registeredClusters() {
+		if c.local {
+			local.install(c.client.(*fake.Clientset))
+		} else {
+			remote.install(c.client.(*fake.Clientset))
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://test-service.com/v2/services", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	w := httptest.NewRecorder()
+	WithAuth(GetServices)(w, req, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", http.StatusOK, w.Code, w.Body)
+	}
+	var services []models.ServiceV1
+	if err := json.Unmarshal(w.Body.Bytes(), &services); err != nil {
+		t.Fatalf("failed to unmarshal response %v", err)
+	}
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Cluster+"/"+svc.Namespace+"/"+svc.Name)
+	}
+	sort.Strings(names)
+	if want := "prod/team-b/payments,staging/team-a/checkout"; strings.Join(names, ",") != want {
+		t.Errorf("mismatched services: want=%v, got=%v", want, names)
+	}
+	if remote.tokenReviews != 0 || remote.accessReviews == 0 {
+		t.Errorf("access not reviewed by the cluster: token reviews=%d, access reviews=%d", remote.tokenReviews, remote.accessReviews)
+	}
+
+	// the access of the other cluster does not grant the requested namespace
+	req = httptest.NewRequest("GET", "http://test-service.com/v2/services?cluster=prod&namespace=team-a", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	w = httptest.NewRecorder()
+	WithAuth(GetServices)(w, req, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("mismatched status code: want=%v, got=%v, body=%s", http.StatusForbidden, w.Code, w.Body)
+	}
+}
+
+func TestWithPathAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "allowed path", token: "prometheus-token", wantCode: http.StatusOK},
+		{name: "Failure missing token", wantCode: http.StatusUnauthorized, wantErr: CodeUnauthenticated},
+		{name: "Failure namespaced access does not grant the path", token: "alice-token", wantCode: http.StatusForbidden, wantErr: CodeForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startFakeCache(t)
+			enableAuth(t, time.Minute)
+			auth := &fakeAuth{
+				users:  map[string]string{"prometheus-token": "prometheus", "alice-token": "alice"},
+				access: map[string][]string{"alice": {metav1.NamespaceAll}},
+				paths:  map[string][]string{"prometheus": {"/metrics"}},
+			}
+			auth.install(kubeClient.(*fake.Clientset))
+
+			req := httptest.NewRequest("GET", "http://test-service.com/metrics", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			WithPathAuth(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+				w.WriteHeader(http.StatusOK)
+			})(w, req, nil)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", tt.wantCode, w.Code, w.Body)
+			}
+			if strings.Contains(tt.name, "Failure") {
+				var resp models.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal error response %v", err)
+				}
+				if resp.Error.Code != tt.wantErr {
+					t.Errorf("mismatched error code: want=%v, got=%v", tt.wantErr, resp.Error.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestWithAuthReviewFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+	}{
+		{name: "Failure token review forbidden", resource: "tokenreviews"},
+		{name: "Failure subject access review forbidden", resource: "subjectaccessreviews"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startFakeCache(t, newNamespacedDeployment("team-a", "checkout"))
+			enableAuth(t, time.Minute)
+			auth := &fakeAuth{users: map[string]string{"alice-token": "alice"}, access: map[string][]string{"alice": {"team-a"}}}
+			client := kubeClient.(*fake.Clientset)
+			auth.install(client)
+			// the controller lacks the RBAC to create reviews
+			denied := "user system:serviceaccount:default:k8s-utility-controller cannot create resource " + tt.resource
+			client.PrependReactor("create", tt.resource, func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: tt.resource}, "", errors.New(denied))
+			})
+
+			req := httptest.NewRequest("GET", "http://test-service.com/services", nil)
+			req.Header.Set("Authorization", "Bearer alice-token")
+			w := httptest.NewRecorder()
+			WithAuth(GetServices)(w, req, nil)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", http.StatusServiceUnavailable, w.Code, w.Body)
+			}
+			var resp models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal error response %v", err)
+			}
+			if resp.Error.Code != CodeUnavailable || strings.Contains(resp.Error.Message, "k8s-utility-controller") {
+				t.Errorf("mismatched error: %+v", resp.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// appGroupIndex is the name of the indexer which keys workloads on their applicationGroup label.
+const appGroupIndex = "byApplicationGroup"
+
+var (
+	errCacheNotSynced      = errors.New("workload cache has not synced yet")
+	errNamespaceNotWatched = errors.New("namespace is not watched by the controller")
+	errUnknownKind         = errors.New("unknown workload kind")
+	errServiceNotFound     = errors.New("service not found")
+	errServiceAmbiguous    = errors.New("service name matches several workloads, filter by namespace or kind")
+)
+
+// workloadInformer is the informer of one workload kind in one watched namespace of a cluster,
+// namespace is metav1.NamespaceAll in all-namespaces mode.
+type workloadInformer struct {
+	cluster   *cluster
+	kind      workloadKind
+	namespace string
+	informer  cache.SharedIndexInformer
+}
+
+// service converts a cached object of the informer into a service of its cluster.
+func (wi workloadInformer) service(obj interface{}) (models.Service, bool) {
+	svc, ok := wi.kind.Service(obj)
+	svc.Cluster = wi.cluster.name
+	return svc, ok
+}
+
+// WorkloadCache holds the informers of one CacheConfig. The handlers serve from the
+// cache installed last, a reinitialized cache is built and synced next to the installed
+// one before it replaces it.
+type WorkloadCache struct {
+	groupKeys []groupKey
+	factories []*namespaceFactories
+	informers []workloadInformer
+}
+
+// cacheMu guards the installed cache and group keys, which are replaced when the cache is reinitialized.
+var (
+	cacheMu   sync.RWMutex
+	installed *WorkloadCache
+)
+
+// cachedInformers returns the informer factories and informers of the installed cache.
+func cachedInformers() ([]*namespaceFactories, []workloadInformer) {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	if installed == nil {
+		return nil, nil
+	}
+	return installed.factories, installed.informers
+}
+
+// isInstalled reports whether the handlers serve from the cache.
+func (wc *WorkloadCache) isInstalled() bool {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return installed == wc
+}
+
+// CacheConfig configures which workloads are watched by the cache.
+type CacheConfig struct {
+	// Namespaces to watch, empty watches all namespaces.
+	Namespaces []string
+	// Kinds lists the built-in workload kinds to watch, empty watches every built-in kind.
+	Kinds []string
+	// Sources lists the custom resources to watch through the dynamic client.
+	Sources []WorkloadSource
+	// GroupKeys is the fallback chain of labels and annotations the application group
+	// is read from, e.g. label:app.kubernetes.io/part-of or annotation:team.
+	// Empty reads the applicationGroup label.
+	GroupKeys []string
+	// Resync is the resync period of the informers.
+	Resync time.Duration
+}
+
+// ServiceFilter selects the services returned by ListServices, empty fields match everything.
+type ServiceFilter struct {
+	Cluster          string
+	Namespace        string
+	ApplicationGroup string
+	Kind             string
+	// Selector matches the labels of the workloads, nil matches every workload.
+	Selector labels.Selector
+	// AllowedNamespaces limits the services to the namespaces the caller is authorized
+	// for per cluster, nil allows every cluster and a nil set every namespace of its cluster.
+	// Services of clusters missing from the map are hidden.
+	AllowedNamespaces map[string]sets.Set[string]
+}
+
+// InitWorkloadCache creates a cache with NewWorkloadCache and installs it.
+// It must be called after InitKubeClient.
+func InitWorkloadCache(cfg CacheConfig) error {
+	wc, err := NewWorkloadCache(cfg)
+	if err != nil {
+		return err
+	}
+	InstallWorkloadCache(wc)
+	return nil
+}
+
+// NewWorkloadCache creates the shared informers for the configured workload kinds
+// and sources in the configured namespaces of every registered cluster, and registers
+// the applicationGroup indexer on them. The cache is not served before it is installed.
+func NewWorkloadCache(cfg CacheConfig) (*WorkloadCache, error) {
+	keys, err := parseGroupKeys(cfg.GroupKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, kindNames := cfg.Namespaces, cfg.Kinds
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	if len(kindNames) == 0 {
+		kindNames = BuiltinKinds()
+	}
+	builtin := make([]workloadKind, 0, len(kindNames))
+	for _, name := range kindNames {
+		kind, err := lookupKind(name)
+		if err != nil {
+			return nil, err
+		}
+		builtin = append(builtin, kind)
+	}
+	log.Infof("initializing workload cache for kinds %q in namespaces %q, group keys %v, resync period %v", kindNames, namespaces, keys, cfg.Resync)
+
+	wc := &WorkloadCache{groupKeys: keys}
+	for _, c := range registeredClusters() {
+		kinds, err := clusterKinds(c, builtin, cfg.Sources)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(namespaces))
+		for _, ns := range namespaces {
+			if seen[ns] {
+				continue
+			}
+			seen[ns] = true
+			nsFactories := &namespaceFactories{
+				typed:   informers.NewSharedInformerFactoryWithOptions(c.client, cfg.Resync, informers.WithNamespace(ns)),
+				dynamic: dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic, cfg.Resync, ns, nil),
+			}
+			for _, kind := range kinds {
+				informer := kind.Informer(nsFactories)
+				if err := informer.AddIndexers(cache.Indexers{appGroupIndex: appGroupIndexFunc(kind, keys)}); err != nil {
+					log.Errorf("error adding %s indexer to %s informer: %v", appGroupIndex, kind.Kind(), err)
+					return nil, err
+				}
+				if _, err := informer.AddEventHandler(serviceEventHandler(wc, c, kind)); err != nil {
+					log.Errorf("error adding event handler to %s informer: %v", kind.Kind(), err)
+					return nil, err
+				}
+				wc.informers = append(wc.informers, workloadInformer{cluster: c, kind: kind, namespace: ns, informer: informer})
+			}
+			wc.factories = append(wc.factories, nsFactories)
+		}
+	}
+
+	return wc, nil
+}
+
+// InstallWorkloadCache makes the handlers serve from the cache. When it replaces
+// another cache the service watchers are dropped, so streams start over with a
+// snapshot and in-process subscribers resync, as the changes between the two caches
+// are not published as events.
+func InstallWorkloadCache(wc *WorkloadCache) {
+	cacheMu.Lock()
+	previous := installed
+	installed = wc
+	groupKeys = wc.groupKeys
+	cacheMu.Unlock()
+	if previous != nil && previous != wc {
+		serviceEvents.reset()
+	}
+}
+
+// clusterKinds returns the built-in kinds along with the kinds of the sources served
+// by the cluster. The sources of an aggregated cluster which can not be reached are
+// skipped, the cluster is reported as unavailable until it is reachable.
+func clusterKinds(c *cluster, builtin []workloadKind, sources []WorkloadSource) ([]workloadKind, error) {
+	if len(sources) == 0 {
+		return builtin, nil
+	}
+	mapper, err := newRESTMapper(c.client)
+	if err != nil {
+		if c.local {
+			return nil, err
+		}
+		log.Errorf("skipping workload sources of cluster %s: %v", c.name, err)
+		return builtin, nil
+	}
+	kinds := append([]workloadKind(nil), builtin...)
+	for _, source := range sources {
+		kind, err := newSourceKind(source, mapper)
+		if err != nil {
+			// a missing custom resource must not take down the whole api
+			log.Errorf("skipping workload source: %v", err)
+			continue
+		}
+		log.Infof("watching workload source %v as kind %s", kind.gvr, kind.Kind())
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// StartWorkloadCache starts the informers of the installed cache, see WorkloadCache.Start.
+func StartWorkloadCache(stopCh <-chan struct{}) bool {
+	factories, informers := cachedInformers()
+	return (&WorkloadCache{factories: factories, informers: informers}).Start(stopCh)
+}
+
+// StopWorkloadCache stops the informers of the installed cache, see WorkloadCache.Stop.
+func StopWorkloadCache() {
+	factories, informers := cachedInformers()
+	(&WorkloadCache{factories: factories, informers: informers}).Stop()
+}
+
+// Start starts the informers and blocks until the cache of the local cluster has
+// synced or stopCh is closed. It returns whether the cache has synced.
+// The aggregated clusters keep syncing in the background.
+func (wc *WorkloadCache) Start(stopCh <-chan struct{}) bool {
+	for _, nsFactories := range wc.factories {
+		nsFactories.typed.Start(stopCh)
+		nsFactories.dynamic.Start(stopCh)
+	}
+	synced := make([]cache.InformerSynced, 0, len(wc.informers))
+	for _, wi := range wc.informers {
+		if wi.cluster.local {
+			synced = append(synced, wi.informer.HasSynced)
+		}
+	}
+	return cache.WaitForNamedCacheSync("workloads", stopCh, synced...)
+}
+
+// Stop stops the informers started by Start, the stop channel passed to it has
+// to be closed first. It waits for the informers to return.
+func (wc *WorkloadCache) Stop() {
+	for _, nsFactories := range wc.factories {
+		nsFactories.typed.Shutdown()
+		nsFactories.dynamic.Shutdown()
+	}
+}
+
+// CacheSynced reports whether the workload cache of the local cluster has completed its initial sync.
+func CacheSynced() bool {
+	_, informers := cachedInformers()
+	if len(informers) == 0 {
+		return false
+	}
+	for _, wi := range informers {
+		if wi.cluster.local && !wi.informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// appGroupIndexFunc returns the index function which keys workloads of the given
+// kind on their application group read from the keys, workloads without a group are
+// not indexed. The keys are bound to the index, so a cache built for other keys
+// indexes its workloads correctly while it syncs next to the installed one.
+func appGroupIndexFunc(kind workloadKind, keys []groupKey) cache.IndexFunc {
+	source, _ := kind.(*sourceKind)
+	return func(obj interface{}) ([]string, error) {
+		svc, ok := kind.Service(obj)
+		if !ok {
+			return nil, nil
+		}
+		group := svc.ApplicationGroup
+		if source == nil || source.groupPath == nil {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return nil, nil
+			}
+			group = groupOf(accessor, keys)
+		}
+		if group == "" {
+			return nil, nil
+		}
+		return []string{group}, nil
+	}
+}
+
+// ListServices returns the services of the cached workloads which match the filter.
+// The clusters which are unavailable are left out and reported in the warnings,
+// it fails when no selected cluster is available.
+func ListServices(filter ServiceFilter) ([]models.Service, []string, error) {
+	if !CacheSynced() {
+		return nil, nil, errCacheNotSynced
+	}
+
+	selected, err := informersFor(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	selected, warnings, err := availableInformers(selected)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	services := make([]models.Service, 0)
+	for _, wi := range selected {
+		var objs []interface{}
+		indexer := wi.informer.GetIndexer()
+		switch {
+		case filter.ApplicationGroup != "":
+			objs, err = indexer.ByIndex(appGroupIndex, filter.ApplicationGroup)
+		case filter.Namespace != "":
+			objs, err = indexer.ByIndex(cache.NamespaceIndex, filter.Namespace)
+		default:
+			objs = indexer.List()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, obj := range objs {
+			svc, ok := wi.service(obj)
+			if !ok || !filter.matches(svc, objectLabels(obj)) {
+				continue
+			}
+			services = append(services, svc)
+		}
+	}
+	log.Debugf("found %d services in cache for filter %+v", len(services), filter)
+
+	// cache iteration order is random, keep the response stable
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Cluster != services[j].Cluster {
+			return services[i].Cluster < services[j].Cluster
+		}
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		if services[i].Kind != services[j].Kind {
+			return services[i].Kind < services[j].Kind
+		}
+		return services[i].Name < services[j].Name
+	})
+	return services, warnings, nil
+}
+
+// getWorkload returns the cached object of the service with the given name
+// which matches the filter, along with the informer it was found in. The
+// service is not looked up in the clusters which are unavailable.
+func getWorkload(filter ServiceFilter, name string) (workloadInformer, interface{}, error) {
+	if !CacheSynced() {
+		return workloadInformer{}, nil, errCacheNotSynced
+	}
+
+	selected, err := informersFor(filter)
+	if err != nil {
+		return workloadInformer{}, nil, err
+	}
+	selected, warnings, err := availableInformers(selected)
+	if err != nil {
+		return workloadInformer{}, nil, err
+	}
+
+	var (
+		found    interface{}
+		foundIn  workloadInformer
+		matching int
+	)
+	for _, wi := range selected {
+		objs, err := wi.informer.GetIndexer().ByIndex(appGroupIndex, filter.ApplicationGroup)
+		if err != nil {
+			return workloadInformer{}, nil, err
+		}
+		for _, obj := range objs {
+			svc, ok := wi.service(obj)
+			if !ok || svc.Name != name || !filter.matches(svc, objectLabels(obj)) {
+				continue
+			}
+			found, foundIn = obj, wi
+			matching++
+		}
+	}
+	switch {
+	case matching == 0 && len(warnings) > 0:
+		// the service may run in one of the unavailable clusters
+		return workloadInformer{}, nil, fmt.Errorf("%w: %s", errClusterUnavailable, strings.Join(warnings, "; "))
+	case matching == 0:
+		return workloadInformer{}, nil, errServiceNotFound
+	case matching == 1:
+		return foundIn, found, nil
+	default:
+		return workloadInformer{}, nil, errServiceAmbiguous
+	}
+}
+
+// informersFor returns the informers holding the workloads selected by the
+// cluster, namespace and kind of the filter.
+func informersFor(filter ServiceFilter) ([]workloadInformer, error) {
+	_, informers := cachedInformers()
+	if filter.Cluster != "" {
+		clusterInformers := make([]workloadInformer, 0, len(informers))
+		for _, wi := range informers {
+			if wi.cluster.name == filter.Cluster {
+				clusterInformers = append(clusterInformers, wi)
+			}
+		}
+		if len(clusterInformers) == 0 {
+			return nil, fmt.Errorf("%w: %s", errUnknownCluster, filter.Cluster)
+		}
+		informers = clusterInformers
+	}
+	var namespaceWatched, kindKnown bool
+	if _, err := lookupKind(filter.Kind); err == nil || filter.Kind == "" {
+		kindKnown = true
+	}
+	selected := make([]workloadInformer, 0, len(informers))
+	for _, wi := range informers {
+		if filter.Namespace != "" && wi.namespace != metav1.NamespaceAll && wi.namespace != filter.Namespace {
+			continue
+		}
+		namespaceWatched = true
+		if filter.Kind != "" && !strings.EqualFold(wi.kind.Kind(), filter.Kind) {
+			continue
+		}
+		kindKnown = true
+		selected = append(selected, wi)
+	}
+	if !namespaceWatched {
+		return nil, errNamespaceNotWatched
+	}
+	if !kindKnown {
+		return nil, fmt.Errorf("%w: %s", errUnknownKind, filter.Kind)
+	}
+	return selected, nil
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+func TestListServices(t *testing.T) {
+	otherGroupDeployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "another-service", Namespace: testNamespace, Labels: map[string]string{appGroup: "beta"}},
+	}
+	otherNamespaceDeployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: testServiceName, Namespace: "team-b", Labels: map[string]string{appGroup: testAppGrp}},
+	}
+	statefulSet := &appv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: testNamespace, Labels: map[string]string{appGroup: testAppGrp}},
+		Status:     appv1.StatefulSetStatus{ReadyReplicas: 3},
+	}
+
+	// the fake workloads were never observed by their controllers, so they are progressing
+	fakeService := models.Service{Namespace: testNamespace, Kind: "Deployment", Name: testServiceName, ApplicationGroup: testAppGrp, RunningPodsCount: 1,
+		Replicas: models.ReplicaStatus{Desired: 1, Ready: 1}, Health: models.HealthProgressing}
+	otherGroupService := models.Service{Namespace: testNamespace, Kind: "Deployment", Name: "another-service", ApplicationGroup: "beta",
+		Replicas: models.ReplicaStatus{Desired: 1}, Health: models.HealthProgressing}
+	otherNamespaceService := models.Service{Namespace: "team-b", Kind: "Deployment", Name: testServiceName, ApplicationGroup: testAppGrp,
+		Replicas: models.ReplicaStatus{Desired: 1}, Health: models.HealthProgressing}
+	statefulSetService := models.Service{Namespace: testNamespace, Kind: "StatefulSet", Name: "database", ApplicationGroup: testAppGrp, RunningPodsCount: 3,
+		Replicas: models.ReplicaStatus{Desired: 1, Ready: 3, Unavailable: 1}, Health: models.HealthProgressing}
+
+	tests := []struct {
+		name       string
+		namespaces []string
+		filter     ServiceFilter
+		want       []models.Service
+		wantErr    error
+	}{
+		{
+			name:    "failure, cache not synced",
+			want:    nil,
+			wantErr: errCacheNotSynced,
+		},
+		{
+			name:    "success, get all services",
+			want:    []models.Service{otherGroupService, fakeService, statefulSetService, otherNamespaceService},
+			wantErr: nil,
+		},
+		{
+			name:    "success, get services of one application group",
+			filter:  ServiceFilter{ApplicationGroup: testAppGrp},
+			want:    []models.Service{fakeService, statefulSetService, otherNamespaceService},
+			wantErr: nil,
+		},
+		{
+			name:    "success, get services of unknown application group",
+			filter:  ServiceFilter{ApplicationGroup: "unknown"},
+			want:    []models.Service{},
+			wantErr: nil,
+		},
+		{
+			name:    "success, get services of one namespace",
+			filter:  ServiceFilter{Namespace: "team-b"},
+			want:    []models.Service{otherNamespaceService},
+			wantErr: nil,
+		},
+		{
+			name:    "success, get services of one application group in one namespace",
+			filter:  ServiceFilter{Namespace: testNamespace, ApplicationGroup: testAppGrp},
+			want:    []models.Service{fakeService, statefulSetService},
+			wantErr: nil,
+		},
+		{
+			name:    "success, get services of one kind",
+			filter:  ServiceFilter{Kind: "statefulset"},
+			want:    []models.Service{statefulSetService},
+			wantErr: nil,
+		},
+		{
+			name:    "success, get services matching a set-based selector",
+			filter:  ServiceFilter{Selector: labels.NewSelector().Add(mustRequirement(t, appGroup, selection.NotIn, "beta"))},
+			want:    []models.Service{fakeService, statefulSetService, otherNamespaceService},
+			wantErr: nil,
+		},
+		{
+			name:    "failure, unknown kind",
+			filter:  ServiceFilter{Kind: "ReplicationController"},
+			want:    nil,
+			wantErr: errUnknownKind,
+		},
+		{
+			name:       "success, get services of watched namespaces only",
+			namespaces: []string{testNamespace},
+			want:       []models.Service{otherGroupService, fakeService, statefulSetService},
+			wantErr:    nil,
+		},
+		{
+			name:       "failure, namespace not watched",
+			namespaces: []string{testNamespace},
+			filter:     ServiceFilter{Namespace: "team-b"},
+			want:       nil,
+			wantErr:    errNamespaceNotWatched,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// prepare test scenario
+			switch {
+			case strings.Contains(tt.name, "cache not synced"):
+				resetCache()
+			default:
+				startFakeNamespacedCache(t, tt.namespaces, fakeDeploymentSpec, otherGroupDeployment, otherNamespaceDeployment, statefulSet)
+			}
+
+			got, _, err := ListServices(tt.filter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ListServices() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf(" ListServices() \n got = %v,\n want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustRequirement(t *testing.T, key string, op selection.Operator, values ...string) labels.Requirement {
+	t.Helper()
+	req, err := labels.NewRequirement(key, op, values)
+	if err != nil {
+		t.Fatalf("invalid requirement: %v", err)
+	}
+	return *req
+}
+
+func TestAppGroupIndexFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{name: "labelled deployment", obj: fakeDeploymentSpec, want: []string{testAppGrp}},
+		{name: "unlabelled deployment", obj: &appv1.Deployment{}, want: nil},
+		{name: "unexpected object", obj: &appv1.StatefulSet{}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appGroupIndexFunc(deploymentKind{}, groupKeys)(tt.obj)
+			if err != nil {
+				t.Errorf("appGroupIndexFunc() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appGroupIndexFunc() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	errUnknownCluster     = errors.New("unknown cluster")
+	errClusterUnavailable = errors.New("cluster is unavailable")
+)
+
+// ClustersConfig configures the clusters whose services are aggregated next to
+// the cluster of the kube client.
+type ClustersConfig struct {
+	// Name of the cluster of the kube client, reported in the cluster field of its services.
+	Name string
+	// Contexts of the kubeconfig which are aggregated as further clusters, named after the context.
+	Contexts []string
+	// KubeconfigDir holds a kubeconfig file per further cluster, e.g. mounted secrets,
+	// the clusters are named after the files without extension.
+	KubeconfigDir string
+	// ProbeInterval is how often the api servers of the clusters are probed.
+	ProbeInterval time.Duration
+}
+
+// cluster holds the clients of a cluster whose workloads are cached.
+type cluster struct {
+	name    string
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	// rateLimiter limits the requests of both clients, it follows SetClientRateLimits
+	rateLimiter clientRateLimiter
+	// local is set for the cluster of the kube client, the controller is not
+	// ready before its workloads have synced
+	local bool
+	// probeErr holds the error of the last probe of the api server, nil while it is reachable
+	probeErr atomic.Pointer[error]
+}
+
+// unavailable returns why the cluster is unavailable, nil when it is available.
+func (c *cluster) unavailable() error {
+	if err := c.probeErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// clustersMu guards the registered clusters.
+var (
+	clustersMu sync.RWMutex
+	clusters   []*cluster
+)
+
+// registeredClusters returns the clusters registered by InitClusters, the local
+// cluster is first. Without registered clusters the kube client is the only cluster.
+func registeredClusters() []*cluster {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+	if len(clusters) > 0 {
+		return clusters
+	}
+	return []*cluster{{client: kubeClient, dynamic: dynamicClient, rateLimiter: kubeRateLimiter, local: true}}
+}
+
+// LocalCluster returns the name of the cluster of the kube client, empty unless
+// configured with ClustersConfig.Name.
+func LocalCluster() string {
+	return registeredClusters()[0].name
+}
+
+// InitClusters registers the cluster of the kube client along with the clusters
+// of the kubeconfig contexts and the kubeconfig directory of cfg, which are connected
+// to with the rate limits, timeout and user agent of client. It must be called after
+// InitKubeClient and before InitWorkloadCache.
+func InitClusters(client ClientConfig, cfg ClustersConfig) error {
+	registered := []*cluster{{name: cfg.Name, client: kubeClient, dynamic: dynamicClient, rateLimiter: kubeRateLimiter, local: true}}
+	seen := map[string]bool{cfg.Name: true}
+	add := func(name string, remote ClientConfig) error {
+		if seen[name] {
+			return fmt.Errorf("cluster %s is configured twice", name)
+		}
+		seen[name] = true
+		conf, source, err := restConfig(remote)
+		if err != nil {
+			return fmt.Errorf("failed to load config of cluster %s: %w", name, err)
+		}
+		limiter := newClientRateLimiter(conf.QPS, conf.Burst)
+		clientset, dynClient, err := newClients(conf, limiter)
+		if err != nil {
+			return fmt.Errorf("failed to create clients of cluster %s: %w", name, err)
+		}
+		log.WithField("host", conf.Host).Infof("aggregating cluster %s using config from %s", name, source)
+		registered = append(registered, &cluster{name: name, client: clientset, dynamic: dynClient, rateLimiter: limiter})
+		return nil
+	}
+
+	for _, contextName := range cfg.Contexts {
+		remote := client
+		remote.Context = contextName
+		if err := add(contextName, remote); err != nil {
+			return err
+		}
+	}
+	if cfg.KubeconfigDir != "" {
+		entries, err := os.ReadDir(cfg.KubeconfigDir)
+		if err != nil {
+			return fmt.Errorf("failed to read kubeconfig directory: %w", err)
+		}
+		for _, entry := range entries {
+			// secret volumes keep their data in hidden directories next to the key files
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			remote := client
+			remote.Kubeconfig = filepath.Join(cfg.KubeconfigDir, entry.Name())
+			remote.Context, remote.Master = "", ""
+			if err := add(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())), remote); err != nil {
+				return err
+			}
+		}
+	}
+
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	clusters = registered
+	return nil
+}
+
+// RunClusterProbes probes the api servers of the registered clusters every interval
+// until stopCh is closed, the services of unreachable clusters are left out of the
+// responses. A single cluster is not probed, its services are always served.
+func RunClusterProbes(stopCh <-chan struct{}, interval time.Duration) {
+	registered := registeredClusters()
+	if len(registered) < 2 {
+		<-stopCh
+		return
+	}
+	wait.Until(func() {
+		var wg sync.WaitGroup
+		for _, c := range registered {
+			wg.Add(1)
+			go func(c *cluster) {
+				defer wg.Done()
+				probeCluster(c, interval)
+			}(c)
+		}
+		wg.Wait()
+	}, interval, stopCh)
+}
+
+// probeCluster records whether the api server of the cluster answers within the timeout.
+func probeCluster(c *cluster, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := serverVersion(ctx, c.client)
+	previous := c.unavailable()
+	switch {
+	case err != nil && previous == nil:
+		log.Warnf("cluster %s is unavailable: %v", c.name, err)
+	case err == nil && previous != nil:
+		log.Infof("cluster %s is available again", c.name)
+	}
+	if err != nil {
+		c.probeErr.Store(&err)
+	} else {
+		c.probeErr.Store(nil)
+	}
+}
+
+// serverVersion asks the api server for its version, the request is cancelled
+// when ctx is done so a hung api server does not keep it open.
+func serverVersion(ctx context.Context, client kubernetes.Interface) error {
+	return client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// availableInformers drops the informers of the clusters which are unreachable or
+// have not synced yet and returns a warning per dropped cluster. It fails when
+// every cluster of the informers is unavailable.
+func availableInformers(informers []workloadInformer) ([]workloadInformer, []string, error) {
+	unavailable := make(map[*cluster]error)
+	for _, wi := range informers {
+		if _, ok := unavailable[wi.cluster]; ok {
+			continue
+		}
+		err := wi.cluster.unavailable()
+		if err == nil && !wi.informer.HasSynced() {
+			err = errCacheNotSynced
+		}
+		if err != nil {
+			unavailable[wi.cluster] = err
+		}
+	}
+	if len(unavailable) == 0 {
+		return informers, nil, nil
+	}
+
+	available := make([]workloadInformer, 0, len(informers))
+	for _, wi := range informers {
+		if _, ok := unavailable[wi.cluster]; !ok {
+			available = append(available, wi)
+		}
+	}
+	warnings := make([]string, 0, len(unavailable))
+	for c, err := range unavailable {
+		warnings = append(warnings, fmt.Sprintf("cluster %s is unavailable: %v", c.name, err))
+	}
+	sort.Strings(warnings)
+	if len(available) == 0 {
+		return nil, warnings, fmt.Errorf("%w: %s", errClusterUnavailable, strings.Join(warnings, "; "))
+	}
+	return available, warnings, nil
+}
+
+// writeWarnings reports problems which did not fail the request in Warning headers,
+// like the kubernetes api server does.
+func writeWarnings(w http.ResponseWriter, warnings []string) {
+	for _, warning := range warnings {
+		log.Warn(warning)
+		w.Header().Add("Warning", "299 - "+strconv.Quote(warning))
+	}
+}
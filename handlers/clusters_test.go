@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// startFakeClusters registers a fake cluster per name, the first one being the local
+// cluster, and runs the workload cache for all of them until the test finishes.
+func startFakeClusters(t *testing.T, names []string, objects map[string][]runtime.Object) {
+	t.Helper()
+	registered := make([]*cluster, 0, len(names))
+	for i, name := range names {
+		client := fake.NewSimpleClientset(objects[name]...)
+		registered = append(registered, &cluster{name: name, client: client, local: i == 0})
+	}
+	kubeClient = registered[0].client
+	clustersMu.Lock()
+	clusters = registered
+	clustersMu.Unlock()
+	t.Cleanup(func() {
+		clustersMu.Lock()
+		clusters = nil
+		clustersMu.Unlock()
+	})
+
+	if err := InitWorkloadCache(CacheConfig{Kinds: []string{"Deployment"}}); err != nil {
+		t.Fatalf("failed to initialize workload cache: %v", err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	if !StartWorkloadCache(stopCh) {
+		t.Fatalf("workload cache did not sync")
+	}
+	// the aggregated clusters sync in the background
+	_, informers := cachedInformers()
+	for _, wi := range informers {
+		if !cache.WaitForCacheSync(stopCh, wi.informer.HasSynced) {
+			t.Fatalf("cluster %s did not sync", wi.cluster.name)
+		}
+	}
+}
+
+func TestListServicesAcrossClusters(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		down         []string
+		wantCode     int
+		wantErr      string
+		wantNames    []string
+		wantWarnings int
+	}{
+		{
+			name:      "services of every cluster",
+			wantCode:  http.StatusOK,
+			wantNames: []string{"prod-1/default/checkout", "prod-2/default/payments", "staging/default/checkout"},
+		},
+		{
+			name:      "services of one cluster",
+			query:     "?cluster=prod-2",
+			wantCode:  http.StatusOK,
+			wantNames: []string{"prod-2/default/payments"},
+		},
+		{
+			name:         "partial results without a cluster which is down",
+			down:         []string{"prod-2"},
+			wantCode:     http.StatusOK,
+			wantNames:    []string{"prod-1/default/checkout", "staging/default/checkout"},
+			wantWarnings: 1,
+		},
+		{
+			name:     "Failure unknown cluster",
+			query:    "?cluster=prod-3",
+			wantCode: http.StatusBadRequest,
+			wantErr:  CodeUnknownCluster,
+		},
+		{
+			name:         "Failure requested cluster is down",
+			query:        "?cluster=prod-2",
+			down:         []string{"prod-2"},
+			wantCode:     http.StatusServiceUnavailable,
+			wantErr:      CodeClusterUnavailable,
+			wantWarnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startFakeClusters(t, []string{"staging", "prod-1", "prod-2"}, map[string][]runtime.Object{
+				"staging": {newNamespacedDeployment("default", "checkout")},
+				"prod-1":  {newNamespacedDeployment("default", "checkout")},
+				"prod-2":  {newNamespacedDeployment("default", "payments")},
+			})
+			for _, c := range registeredClusters() {
+				for _, name := range tt.down {
+					if c.name == name {
+						err := errors.New("connection refused")
+						c.probeErr.Store(&err)
+					}
+				}
+			}
+
+			req := httptest.NewRequest("GET", "http://test-service.com/v2/services"+tt.query, nil)
+			w := httptest.NewRecorder()
+			GetServices(w, req, nil)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", tt.wantCode, w.Code, w.Body)
+			}
+			if got := len(w.Header().Values("Warning")); got != tt.wantWarnings {
+				t.Errorf("mismatched number of warnings: want=%v, got=%v", tt.wantWarnings, w.Header().Values("Warning"))
+			}
+			if strings.Contains(tt.name, "Failure") {
+				var resp models.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal error response %v", err)
+				}
+				if resp.Error.Code != tt.wantErr {
+					t.Errorf("mismatched error code: want=%v, got=%v", tt.wantErr, resp.Error.Code)
+				}
+				return
+			}
+
+			var services []models.ServiceV1
+			if err := json.Unmarshal(w.Body.Bytes(), &services); err != nil {
+				t.Fatalf("failed to unmarshal response %v", err)
+			}
+			names := make([]string, 0, len(services))
+			for _, svc := range services {
+				names = append(names, svc.Cluster+"/"+svc.Namespace+"/"+svc.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("mismatched services: want=%v, got=%v", tt.wantNames, names)
+			}
+		})
+	}
+}
+
+func TestProbeCluster(t *testing.T) {
+	c := &cluster{name: "prod-1", client: newVersionClient(t, serveVersion)}
+	probeCluster(c, time.Second)
+	if err := c.unavailable(); err != nil {
+		t.Fatalf("reachable cluster reported unavailable: %v", err)
+	}
+
+	c.client = newVersionClient(t, failVersion)
+	probeCluster(c, time.Second)
+	if err := c.unavailable(); err == nil || !strings.Contains(err.Error(), "etcd connection refused") {
+		t.Fatalf("unreachable cluster not reported unavailable: %v", err)
+	}
+
+	// the request to a hung api server is cancelled along with the probe
+	cancelled := make(chan struct{})
+	c.client = newVersionClient(t, func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	probeCluster(c, 50*time.Millisecond)
+	if err := c.unavailable(); err == nil {
+		t.Fatalf("hung cluster not reported unavailable")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request to the hung api server was not cancelled")
+	}
+}
+
+func TestInitClusters(t *testing.T) {
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, "prod")+string(filepath.ListSeparator)+writeKubeconfig(t, "staging"))
+	dir := t.TempDir()
+	for _, name := range []string{"edge-1", "edge-2"} {
+		data, err := os.ReadFile(writeKubeconfig(t, name))
+		if err != nil {
+			t.Fatalf("failed to read kubeconfig %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".yaml"), data, 0o600); err != nil {
+			t.Fatalf("failed to write kubeconfig %v", err)
+		}
+	}
+	// secret volumes keep the data of the keys in hidden directories
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0o700); err != nil {
+		t.Fatalf("failed to create directory %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		cfg       ClustersConfig
+		wantNames []string
+	}{
+		{
+			name:      "kubeconfig contexts",
+			cfg:       ClustersConfig{Name: "local", Contexts: []string{"prod-ctx", "staging-ctx"}},
+			wantNames: []string{"local", "prod-ctx", "staging-ctx"},
+		},
+		{
+			name:      "kubeconfig directory",
+			cfg:       ClustersConfig{Name: "local", KubeconfigDir: dir},
+			wantNames: []string{"edge-1", "edge-2", "local"},
+		},
+		{
+			name: "Failure unknown context",
+			cfg:  ClustersConfig{Name: "local", Contexts: []string{"dev-ctx"}},
+		},
+		{
+			name: "Failure cluster configured twice",
+			cfg:  ClustersConfig{Name: "prod-ctx", Contexts: []string{"prod-ctx"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeClient = fake.NewSimpleClientset()
+			t.Cleanup(func() {
+				clustersMu.Lock()
+				clusters = nil
+				clustersMu.Unlock()
+			})
+
+			err := InitClusters(ClientConfig{QPS: 20, Burst: 30}, tt.cfg)
+			if strings.Contains(tt.name, "Failure") {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			registered := registeredClusters()
+			names := make([]string, 0, len(registered))
+			for _, c := range registered {
+				names = append(names, c.name)
+			}
+			if !registered[0].local || registered[0].client != kubeClient {
+				t.Errorf("first cluster is not the cluster of the kube client")
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("mismatched clusters: want=%v, got=%v", tt.wantNames, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// configVersion is the version of the configuration the controller currently runs with.
+var configVersion atomic.Pointer[models.ConfigVersion]
+
+// SetConfigVersion replaces the config version reported by GetConfigVersion.
+func SetConfigVersion(version models.ConfigVersion) {
+	if version.PendingRestart == nil {
+		version.PendingRestart = []string{}
+	}
+	configVersion.Store(&version)
+}
+
+// GetConfigVersion handler returns the version of the applied configuration
+// and the changed settings which wait for a restart.
+func GetConfigVersion(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log.Infof("Incomming request %s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+
+	version := configVersion.Load()
+	if version == nil {
+		version = &models.ConfigVersion{PendingRestart: []string{}}
+	}
+	respBytes, err := json.Marshal(version)
+	if err != nil {
+		writeEncodeError(w, r, err)
+		return
+	}
+	responseWriter(w, r, respBytes, http.StatusOK)
+}
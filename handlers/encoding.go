@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/munnerz/goautoneg"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	yaml "sigs.k8s.io/yaml/goyaml.v2"
+)
+
+// outputParam is the query parameter used to pick the response format, it takes precedence over the Accept header.
+const outputParam = "output"
+
+// media types of the supported response formats
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeYAML   = "application/yaml"
+	mediaTypeCSV    = "text/csv"
+	mediaTypeNDJSON = "application/x-ndjson"
+	mediaTypeTable  = "text/plain"
+)
+
+// formatWide picks the wide table, it is served as text/plain like the table so it
+// can only be requested with the output parameter.
+const formatWide = "wide"
+
+// outputFormats maps the values of the output query parameter to their media type.
+var outputFormats = map[string]string{
+	"json":   mediaTypeJSON,
+	"yaml":   mediaTypeYAML,
+	"csv":    mediaTypeCSV,
+	"ndjson": mediaTypeNDJSON,
+	"table":  mediaTypeTable,
+	"wide":   formatWide,
+}
+
+// supportedMediaTypes are offered to the Accept header in order of preference.
+var supportedMediaTypes = []string{mediaTypeJSON, mediaTypeYAML, mediaTypeCSV, mediaTypeNDJSON, mediaTypeTable}
+
+// encoders convert the JSON encoding of a response into the other formats, the
+// content type is the media type of the format.
+var encoders = map[string]struct {
+	contentType string
+	encode      func(data []byte) ([]byte, error)
+}{
+	mediaTypeJSON:   {contentType: "application/json", encode: func(data []byte) ([]byte, error) { return data, nil }},
+	mediaTypeYAML:   {contentType: "application/yaml", encode: encodeYAML},
+	mediaTypeCSV:    {contentType: "text/csv; charset=utf-8", encode: encodeCSV},
+	mediaTypeNDJSON: {contentType: "application/x-ndjson", encode: encodeNDJSON},
+	mediaTypeTable:  {contentType: "text/plain; charset=utf-8", encode: encodeTable},
+	formatWide:      {contentType: "text/plain; charset=utf-8", encode: encodeWideTable},
+}
+
+var errNotAcceptable = errors.New("none of the accepted media types is supported, use one of " + strings.Join(supportedMediaTypes, ", "))
+
+// negotiateMediaType returns the media type of the response format requested by the
+// output query parameter or the Accept header, JSON when neither asks for a format.
+func negotiateMediaType(r *http.Request) (string, error) {
+	if output := r.URL.Query().Get(outputParam); output != "" {
+		mediaType, ok := outputFormats[strings.ToLower(output)]
+		if !ok {
+			return "", fmt.Errorf("%w: %s must be one of json, yaml, csv, ndjson, table or wide", errInvalidParameter, outputParam)
+		}
+		return mediaType, nil
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return mediaTypeJSON, nil
+	}
+	mediaType := goautoneg.Negotiate(accept, supportedMediaTypes)
+	if mediaType == "" {
+		return "", errNotAcceptable
+	}
+	return mediaType, nil
+}
+
+// encodeResponse converts the JSON encoded response into the negotiated format, it
+// returns the content type along with the encoded response.
+func encodeResponse(mediaType string, respBytes []byte) (string, []byte, error) {
+	encoder := encoders[mediaType]
+	encoded, err := encoder.encode(respBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	return encoder.contentType, encoded, nil
+}
+
+// encodeYAML converts JSON to YAML, the keys keep their order like in the JSON
+// encoding, e.g. the order of the requested fields.
+func encodeYAML(data []byte) ([]byte, error) {
+	value, err := decodeOrdered(data)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(yamlValue(value))
+}
+
+// yamlValue converts a value returned by decodeOrdered into one encoded by yaml.Marshal.
+func yamlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case orderedObject:
+		mapSlice := make(yaml.MapSlice, 0, len(v))
+		for _, field := range v {
+			mapSlice = append(mapSlice, yaml.MapItem{Key: field.key, Value: yamlValue(field.value)})
+		}
+		return mapSlice
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, yamlValue(item))
+		}
+		return list
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// encodeNDJSON writes every item of a list on its own line, any other value on a single line.
+func encodeNDJSON(data []byte) ([]byte, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = []json.RawMessage{data}
+	}
+	var buf bytes.Buffer
+	for _, item := range items {
+		if err := json.Compact(&buf, item); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeCSV writes a header and a row per item, nested objects are flattened
+// into dotted columns and lists are written as JSON.
+func encodeCSV(data []byte) ([]byte, error) {
+	columns, rows, err := tabulate(data, func(list []interface{}) string {
+		encoded, _ := json.Marshal(list)
+		return string(encoded)
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write(columns)
+	_ = writer.WriteAll(rows)
+	return buf.Bytes(), writer.Error()
+}
+
+// encodeTable writes an aligned table of the top level fields like kubectl get.
+// When every field is nested, e.g. only replicas are selected, the wide table is
+// written instead.
+func encodeTable(data []byte) ([]byte, error) {
+	columns, rows, err := tabulate(data, summarizeList, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 && len(rows) > 0 {
+		return encodeWideTable(data)
+	}
+	return writeTable(columns, rows)
+}
+
+// encodeWideTable writes an aligned table like kubectl get -o wide, which adds the
+// fields of nested objects and lists. Lists are written as the names of their items
+// or as the number of items.
+func encodeWideTable(data []byte) ([]byte, error) {
+	columns, rows, err := tabulate(data, summarizeList, true)
+	if err != nil {
+		return nil, err
+	}
+	return writeTable(columns, rows)
+}
+
+// writeTable aligns the columns of the rows below their kubectl style headers.
+func writeTable(columns []string, rows [][]string) ([]byte, error) {
+	if len(rows) == 0 {
+		return []byte("No resources found.\n"), nil
+	}
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 8, 3, ' ', 0)
+	headers := make([]string, 0, len(columns))
+	for _, column := range columns {
+		headers = append(headers, tableHeader(column))
+	}
+	fmt.Fprintln(writer, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		for i, cell := range row {
+			if cell == "" {
+				row[i] = "<none>"
+			}
+		}
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tabulate turns a JSON list of objects, or a single object, into rows. The columns
+// are the flattened keys in the order they are first seen, formatList renders lists.
+// Without nested the fields of nested objects and lists are left out.
+func tabulate(data []byte, formatList func([]interface{}) string, nested bool) ([]string, [][]string, error) {
+	value, err := decodeOrdered(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	flattened := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(orderedObject); ok && !nested {
+			item = object.scalars()
+		}
+		cells := make(map[string]string)
+		var keys []string
+		flatten("", item, cells, &keys, formatList)
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+		flattened = append(flattened, cells)
+	}
+
+	rows := make([][]string, 0, len(flattened))
+	for _, cells := range flattened {
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			row = append(row, cells[column])
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows, nil
+}
+
+// flatten writes the cells of a value, nested object keys are joined with dots.
+func flatten(prefix string, value interface{}, cells map[string]string, keys *[]string, formatList func([]interface{}) string) {
+	set := func(cell string) {
+		key := prefix
+		if key == "" {
+			key = "value"
+		}
+		cells[key] = cell
+		*keys = append(*keys, key)
+	}
+	switch v := value.(type) {
+	case orderedObject:
+		for _, field := range v {
+			key := field.key
+			if prefix != "" {
+				key = prefix + "." + field.key
+			}
+			flatten(key, field.value, cells, keys, formatList)
+		}
+	case []interface{}:
+		set(formatList(v))
+	case nil:
+		set("")
+	default:
+		set(fmt.Sprint(v))
+	}
+}
+
+// summarizeList renders a list in a table cell, lists of named objects such
+// as containers as the comma separated names.
+func summarizeList(list []interface{}) string {
+	parts := make([]string, 0, len(list))
+	for _, item := range list {
+		switch v := item.(type) {
+		case orderedObject:
+			name, ok := v.get("name")
+			if !ok {
+				return fmt.Sprintf("%d items", len(list))
+			}
+			parts = append(parts, fmt.Sprint(name))
+		case []interface{}:
+			return fmt.Sprintf("%d items", len(list))
+		default:
+			parts = append(parts, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// tableHeader turns a column key such as replicas.desired or runningPodsCount into
+// a kubectl style header, e.g. REPLICAS.DESIRED or RUNNING-PODS-COUNT.
+func tableHeader(column string) string {
+	var header strings.Builder
+	for i, r := range column {
+		if i > 0 && unicode.IsUpper(r) {
+			header.WriteByte('-')
+		}
+		header.WriteRune(unicode.ToUpper(r))
+	}
+	return header.String()
+}
+
+// orderedObject is a decoded JSON object which keeps the order of its keys.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON encodes the object with its keys in order.
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o orderedObject) get(key string) (interface{}, bool) {
+	for _, field := range o {
+		if field.key == key {
+			return field.value, true
+		}
+	}
+	return nil, false
+}
+
+// scalars returns the fields of the object which are neither objects nor lists.
+func (o orderedObject) scalars() orderedObject {
+	scalars := make(orderedObject, 0, len(o))
+	for _, field := range o {
+		switch field.value.(type) {
+		case orderedObject, []interface{}:
+		default:
+			scalars = append(scalars, field)
+		}
+	}
+	return scalars
+}
+
+// decodeOrdered decodes JSON into orderedObject, []interface{}, json.Number, string, bool or nil.
+func decodeOrdered(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrderedValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return value, nil
+}
+
+func decodeOrderedValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+	switch delim {
+	case '{':
+		object := orderedObject{}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, orderedField{key: keyToken.(string), value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	case '[':
+		list := make([]interface{}, 0)
+		for decoder.More() {
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = decoder.Token()
+		return list, err
+	default:
+		return nil, fmt.Errorf("unexpected JSON delimiter %v", delim)
+	}
+}
+
+// responseWriter writes the JSON encoded response in the format negotiated by the output query
+// parameter or the Accept header. A format which can not be negotiated is reported as an error,
+// errors themselves are written as JSON then.
+func responseWriter(w http.ResponseWriter, r *http.Request, respBytes []byte, code int) {
+	mediaType, err := negotiateMediaType(r)
+	if err != nil {
+		if code < http.StatusBadRequest {
+			status, errCode := http.StatusNotAcceptable, CodeNotAcceptable
+			if errors.Is(err, errInvalidParameter) {
+				status, errCode = http.StatusBadRequest, CodeInvalidParameter
+			}
+			writeErrorResponse(w, r, status, models.Error{Code: errCode, Message: err.Error()})
+			return
+		}
+		mediaType = mediaTypeJSON
+	}
+	contentType, encoded, err := encodeResponse(mediaType, respBytes)
+	if err != nil {
+		log.Errorf("failed to encode response as %s, falling back to JSON: %v", mediaType, err)
+		contentType, encoded = encoders[mediaTypeJSON].contentType, respBytes
+	}
+	w.Header().Set("content-type", contentType)
+	w.Header().Add("vary", "Accept")
+	w.WriteHeader(code)
+	if _, err := w.Write(encoded); err != nil {
+		log.Errorf("failed to write response %v", err)
+	}
+}
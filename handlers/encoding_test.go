@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+)
+
+func TestGetServicesContentNegotiation(t *testing.T) {
+	startFakeCache(t,
+		newListDeployment("checkout", "alpha", 2),
+		newListDeployment("payments", "beta", 5),
+	)
+
+	tests := []struct {
+		name            string
+		target          string
+		accept          string
+		wantCode        int
+		wantContentType string
+		wantBody        []string
+	}{
+		{
+			name:            "default json",
+			target:          "/services",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        []string{`"name":"checkout"`},
+		},
+		{
+			name:            "yaml accept header",
+			target:          "/services",
+			accept:          "application/yaml",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/yaml",
+			wantBody:        []string{"- namespace: default\n", "  applicationGroup: alpha\n", "  name: payments\n"},
+		},
+		{
+			name:            "json keeps the order of the fields",
+			target:          "/services?fields=runningPodsCount,name,applicationGroup",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        []string{`{"runningPodsCount":2,"name":"checkout","applicationGroup":"alpha"}`},
+		},
+		{
+			name:            "yaml keeps the order of the fields",
+			target:          "/services?output=yaml&fields=runningPodsCount,name,applicationGroup",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/yaml",
+			wantBody:        []string{"- runningPodsCount: 2\n  name: checkout\n  applicationGroup: alpha\n"},
+		},
+		{
+			name:            "csv keeps the order of the fields",
+			target:          "/services?output=csv&fields=runningPodsCount,name,applicationGroup",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody:        []string{"runningPodsCount,name,applicationGroup\n2,checkout,alpha\n"},
+		},
+		{
+			name:            "table keeps the order of the fields",
+			target:          "/services?output=table&fields=runningPodsCount,name,applicationGroup",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        []string{"RUNNING-PODS-COUNT   NAME       APPLICATION-GROUP\n2                    checkout   alpha\n"},
+		},
+		{
+			name:            "csv output parameter",
+			target:          "/services?output=csv&fields=name,runningPodsCount",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody:        []string{"name,runningPodsCount\ncheckout,2\npayments,5\n"},
+		},
+		{
+			name:            "ndjson prefers the highest quality",
+			target:          "/services?fields=name",
+			accept:          "application/json;q=0.5, application/x-ndjson",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        []string{"{\"name\":\"checkout\"}\n{\"name\":\"payments\"}\n"},
+		},
+		{
+			name:            "table",
+			target:          "/v2/services?output=table",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        []string{"NAMESPACE", "RUNNING-PODS-COUNT", "checkout"},
+		},
+		{
+			name:            "wide table",
+			target:          "/v2/services?output=wide",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        []string{"NAMESPACE", "RUNNING-PODS-COUNT", "REPLICAS.DESIRED", "checkout"},
+		},
+		{
+			name:            "output parameter overrides accept header",
+			target:          "/services?output=json",
+			accept:          "text/csv",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "Failure unknown output",
+			target:          "/services?output=xml",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/json",
+			wantBody:        []string{CodeInvalidParameter},
+		},
+		{
+			name:            "Failure not acceptable",
+			target:          "/services",
+			accept:          "application/xml",
+			wantCode:        http.StatusNotAcceptable,
+			wantContentType: "application/json",
+			wantBody:        []string{CodeNotAcceptable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://test-service.com"+tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			handle := GetServices
+			if strings.HasPrefix(tt.target, "/v2") {
+				handle = WithAPIVersion(APIVersionV2, GetServices)
+			}
+			w := httptest.NewRecorder()
+			handle(w, req, nil)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", tt.wantCode, w.Code, w.Body)
+			}
+			if got := w.Header().Get("content-type"); got != tt.wantContentType {
+				t.Errorf("mismatched content type: want=%v, got=%v", tt.wantContentType, got)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("response does not contain %q: %s", want, w.Body)
+				}
+			}
+			if strings.Contains(tt.name, "Failure") {
+				var resp models.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Errorf("failed to unmarshal error response %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeTable(t *testing.T) {
+	tests := []struct {
+		name string
+		wide bool
+		data string
+		want string
+	}{
+		{
+			name: "list of objects",
+			data: `[{"name":"checkout","replicas":{"desired":2},"containers":[{"name":"app"},{"name":"proxy"}]},{"name":"search","extra":null}]`,
+			want: "NAME       EXTRA\n" +
+				"checkout   <none>\n" +
+				"search     <none>\n",
+		},
+		{
+			name: "wide list of objects",
+			wide: true,
+			data: `[{"name":"checkout","replicas":{"desired":2},"containers":[{"name":"app"},{"name":"proxy"}]},{"name":"search","extra":null}]`,
+			want: "NAME       REPLICAS.DESIRED   CONTAINERS   EXTRA\n" +
+				"checkout   2                  app,proxy    <none>\n" +
+				"search     <none>             <none>       <none>\n",
+		},
+		{
+			name: "only nested fields",
+			data: `[{"replicas":{"desired":2,"ready":1}}]`,
+			want: "REPLICAS.DESIRED   REPLICAS.READY\n2                  1\n",
+		},
+		{
+			name: "single object",
+			data: `{"applicationGroup":"alpha","servicesCount":3}`,
+			want: "APPLICATION-GROUP   SERVICES-COUNT\nalpha               3\n",
+		},
+		{
+			name: "empty list",
+			data: `[]`,
+			want: "No resources found.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encode := encodeTable
+			if tt.wide {
+				encode = encodeWideTable
+			}
+			got, err := encode([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mismatched table:\nwant=%q\ngot=%q", tt.want, got)
+			}
+		})
+	}
+}
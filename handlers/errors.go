@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// machine-readable codes of the error responses
+const (
+	CodeNamespaceNotWatched = "NamespaceNotWatched"
+	CodeUnknownKind         = "UnknownKind"
+	CodeUnknownCluster      = "UnknownCluster"
+	CodeInvalidSelector     = "InvalidSelector"
+	CodeInvalidParameter    = "InvalidParameter"
+	CodeServiceNotFound     = "ServiceNotFound"
+	CodeGroupNotFound       = "GroupNotFound"
+	CodeServiceAmbiguous    = "ServiceAmbiguous"
+	CodeNoPodSelector       = "NoPodSelector"
+	CodeCacheNotSynced      = "CacheNotSynced"
+	CodeClusterUnavailable  = "ClusterUnavailable"
+	CodeNotAcceptable       = "NotAcceptable"
+	CodeUnauthenticated     = "Unauthenticated"
+	CodeUnauthorized        = "Unauthorized"
+	CodeForbidden           = "Forbidden"
+	CodeNotFound            = "NotFound"
+	CodeTooManyRequests     = "TooManyRequests"
+	CodeUnavailable         = "Unavailable"
+	CodeTimeout             = "Timeout"
+	CodeInternal            = "Internal"
+)
+
+// requestIDHeader carries the id of a request, it is taken from the
+// client when present and generated otherwise.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID assigns every request an id, which is echoed in the
+// X-Request-ID response header and reported in error responses.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the id of the request, a new one when WithRequestID did not assign one.
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// apiError describes how an error is reported to the client.
+type apiError struct {
+	status    int
+	code      string
+	retryable bool
+	// detailed is set when the error message is safe and useful to show as is
+	detailed bool
+}
+
+// classifyError maps errors of the controller and of the kubernetes api
+// server to the status code and error code reported to the client.
+func classifyError(err error) apiError {
+	switch {
+	case errors.Is(err, errNamespaceNotWatched):
+		return apiError{status: http.StatusBadRequest, code: CodeNamespaceNotWatched, detailed: true}
+	case errors.Is(err, errUnknownKind):
+		return apiError{status: http.StatusBadRequest, code: CodeUnknownKind, detailed: true}
+	case errors.Is(err, errUnknownCluster):
+		return apiError{status: http.StatusBadRequest, code: CodeUnknownCluster, detailed: true}
+	case errors.Is(err, errInvalidSelector):
+		return apiError{status: http.StatusBadRequest, code: CodeInvalidSelector, detailed: true}
+	case errors.Is(err, errInvalidParameter):
+		return apiError{status: http.StatusBadRequest, code: CodeInvalidParameter, detailed: true}
+	case errors.Is(err, errServiceNotFound):
+		return apiError{status: http.StatusNotFound, code: CodeServiceNotFound, detailed: true}
+	case errors.Is(err, errGroupNotFound):
+		return apiError{status: http.StatusNotFound, code: CodeGroupNotFound, detailed: true}
+	case errors.Is(err, errServiceAmbiguous):
+		return apiError{status: http.StatusConflict, code: CodeServiceAmbiguous, detailed: true}
+	case errors.Is(err, errNoPodSelector):
+		return apiError{status: http.StatusUnprocessableEntity, code: CodeNoPodSelector, detailed: true}
+	case errors.Is(err, errUnauthenticated):
+		return apiError{status: http.StatusUnauthorized, code: CodeUnauthenticated, detailed: true}
+	case errors.Is(err, errNotAuthorized):
+		return apiError{status: http.StatusForbidden, code: CodeForbidden, detailed: true}
+	case errors.Is(err, errReviewFailed):
+		// the message of the api server is about the controller, not the caller
+		return apiError{status: http.StatusServiceUnavailable, code: CodeUnavailable, retryable: true}
+	case errors.Is(err, errCacheNotSynced):
+		return apiError{status: http.StatusServiceUnavailable, code: CodeCacheNotSynced, retryable: true, detailed: true}
+	case errors.Is(err, errClusterUnavailable):
+		return apiError{status: http.StatusServiceUnavailable, code: CodeClusterUnavailable, retryable: true, detailed: true}
+	case apierrors.IsUnauthorized(err):
+		return apiError{status: http.StatusServiceUnavailable, code: CodeUnauthorized, retryable: true}
+	case apierrors.IsForbidden(err):
+		return apiError{status: http.StatusForbidden, code: CodeForbidden, detailed: true}
+	case apierrors.IsNotFound(err):
+		return apiError{status: http.StatusNotFound, code: CodeNotFound, detailed: true}
+	case apierrors.IsTooManyRequests(err):
+		return apiError{status: http.StatusTooManyRequests, code: CodeTooManyRequests, retryable: true}
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return apiError{status: http.StatusGatewayTimeout, code: CodeTimeout, retryable: true}
+	case apierrors.IsServiceUnavailable(err), apierrors.IsInternalError(err), apierrors.IsUnexpectedServerError(err):
+		return apiError{status: http.StatusServiceUnavailable, code: CodeUnavailable, retryable: true}
+	default:
+		return apiError{status: http.StatusServiceUnavailable, code: CodeUnavailable, retryable: true}
+	}
+}
+
+// IsRetryable reports whether err is temporary, e.g. the cache has not synced or a
+// cluster is unavailable, and the operation should be retried later.
+func IsRetryable(err error) bool {
+	return classifyError(err).retryable
+}
+
+// writeError writes the error envelope for err, message describes the failed operation.
+func writeError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	apiErr := classifyError(err)
+	if apiErr.detailed {
+		message = message + ": " + err.Error()
+	} else {
+		// the cause is kept from the client but not from the operator
+		log.Errorf("request %s: %s: %v", requestID(r), message, err)
+	}
+	// pass on the back-off the api server asked for
+	if delay, ok := apierrors.SuggestsClientDelay(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(delay))
+	}
+	writeErrorResponse(w, r, apiErr.status, models.Error{Code: apiErr.code, Message: message, Retryable: apiErr.retryable})
+}
+
+// writeEncodeError writes the error envelope for a response which could not be encoded.
+func writeEncodeError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Errorf("error marshaling response %v", err)
+	writeErrorResponse(w, r, http.StatusInternalServerError, models.Error{Code: CodeInternal, Message: "failed to encode response"})
+}
+
+// writeErrorResponse fills in the request id and writes the error envelope with the given status code.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, apiErr models.Error) {
+	apiErr.RequestID = requestID(r)
+	log.Errorf("request %s failed with %s: %s", apiErr.RequestID, apiErr.Code, apiErr.Message)
+
+	w.Header().Set(requestIDHeader, apiErr.RequestID)
+	respBytes, err := json.Marshal(models.ErrorResponse{Error: apiErr})
+	if err != nil {
+		log.Errorf("error marshaling error response %v", err)
+		respBytes = []byte(`{"error":{"code":"` + CodeInternal + `"}}`)
+	}
+	responseWriter(w, r, respBytes, status)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name          string
+		err           error
+		wantStatus    int
+		wantCode      string
+		wantRetryable bool
+	}{
+		{name: "namespace not watched", err: errNamespaceNotWatched, wantStatus: http.StatusBadRequest, wantCode: CodeNamespaceNotWatched},
+		{name: "unknown kind", err: fmt.Errorf("%w: Foo", errUnknownKind), wantStatus: http.StatusBadRequest, wantCode: CodeUnknownKind},
+		{name: "invalid selector", err: fmt.Errorf("%w: bad", errInvalidSelector), wantStatus: http.StatusBadRequest, wantCode: CodeInvalidSelector},
+		{name: "invalid parameter", err: fmt.Errorf("%w: limit", errInvalidParameter), wantStatus: http.StatusBadRequest, wantCode: CodeInvalidParameter},
+		{name: "service not found", err: errServiceNotFound, wantStatus: http.StatusNotFound, wantCode: CodeServiceNotFound},
+		{name: "group not found", err: errGroupNotFound, wantStatus: http.StatusNotFound, wantCode: CodeGroupNotFound},
+		{name: "service ambiguous", err: errServiceAmbiguous, wantStatus: http.StatusConflict, wantCode: CodeServiceAmbiguous},
+		{name: "no pod selector", err: errNoPodSelector, wantStatus: http.StatusUnprocessableEntity, wantCode: CodeNoPodSelector},
+		{name: "cache not synced", err: errCacheNotSynced, wantStatus: http.StatusServiceUnavailable, wantCode: CodeCacheNotSynced, wantRetryable: true},
+		{name: "forbidden", err: apierrors.NewForbidden(pods, "", errors.New("rbac")), wantStatus: http.StatusForbidden, wantCode: CodeForbidden},
+		{name: "not found", err: apierrors.NewNotFound(pods, "checkout"), wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("slow down", 3), wantStatus: http.StatusTooManyRequests, wantCode: CodeTooManyRequests, wantRetryable: true},
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("etcd"), wantStatus: http.StatusServiceUnavailable, wantCode: CodeUnavailable, wantRetryable: true},
+		{name: "server timeout", err: apierrors.NewServerTimeout(pods, "list", 2), wantStatus: http.StatusGatewayTimeout, wantCode: CodeTimeout, wantRetryable: true},
+		{name: "timeout", err: apierrors.NewTimeoutError("list", 2), wantStatus: http.StatusGatewayTimeout, wantCode: CodeTimeout, wantRetryable: true},
+		{name: "context deadline", err: fmt.Errorf("list pods: %w", context.DeadlineExceeded), wantStatus: http.StatusGatewayTimeout, wantCode: CodeTimeout, wantRetryable: true},
+		{name: "connection refused", err: errors.New("dial tcp 0.0.0.0:6443: connect: connection refused"), wantStatus: http.StatusServiceUnavailable, wantCode: CodeUnavailable, wantRetryable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+			if got.status != tt.wantStatus || got.code != tt.wantCode || got.retryable != tt.wantRetryable {
+				t.Errorf("classifyError() = %+v, want status=%v code=%v retryable=%v", got, tt.wantStatus, tt.wantCode, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	startFakeCache(t, selectorDeployment)
+
+	tests := []struct {
+		name           string
+		reaction       k8stesting.ReactionFunc
+		requestID      string
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter string
+	}{
+		{
+			name: "forbidden by api server",
+			reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("rbac"))
+			},
+			requestID:  "test-request-id",
+			wantStatus: http.StatusForbidden,
+			wantCode:   CodeForbidden,
+		},
+		{
+			name: "throttled by api server",
+			reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewTooManyRequests("slow down", 3)
+			},
+			wantStatus:     http.StatusTooManyRequests,
+			wantCode:       CodeTooManyRequests,
+			wantRetryAfter: "3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeClient.(*fake.Clientset).Fake.PrependReactor("list", "pods", tt.reaction)
+
+			router := httprouter.New()
+			router.GET("/services/:applicationGroup/:name/pods", GetServicePods)
+			req := httptest.NewRequest("GET", "http://test-service.com/services/alpha/checkout/pods", nil)
+			if tt.requestID != "" {
+				req.Header.Set(requestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			WithRequestID(router).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("mismatched status code: want=%v, got=%v", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("mismatched Retry-After: want=%v, got=%v", tt.wantRetryAfter, got)
+			}
+			var resp models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response %v", err)
+			}
+			if resp.Error.Code != tt.wantCode {
+				t.Errorf("mismatched error code: want=%v, got=%v", tt.wantCode, resp.Error.Code)
+			}
+			if resp.Error.RequestID == "" || resp.Error.RequestID != w.Header().Get(requestIDHeader) {
+				t.Errorf("request id %q does not match header %q", resp.Error.RequestID, w.Header().Get(requestIDHeader))
+			}
+			if tt.requestID != "" && resp.Error.RequestID != tt.requestID {
+				t.Errorf("mismatched request id: want=%v, got=%v", tt.requestID, resp.Error.RequestID)
+			}
+		})
+	}
+}
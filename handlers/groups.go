@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	labelKeyPrefix      = "label:"
+	annotationKeyPrefix = "annotation:"
+)
+
+var errInvalidSelector = errors.New("invalid label selector")
+
+// groupKey names a label or annotation holding the application group of a workload.
+type groupKey struct {
+	annotation bool
+	name       string
+}
+
+func (k groupKey) String() string {
+	if k.annotation {
+		return annotationKeyPrefix + k.name
+	}
+	return labelKeyPrefix + k.name
+}
+
+// groupKeys is the fallback chain the application group of a workload is read
+// from, the first key set on the workload wins.
+var groupKeys = []groupKey{{name: appGroup}}
+
+// ValidateGroupKeys reports whether the group keys of a CacheConfig are valid.
+func ValidateGroupKeys(keys []string) error {
+	_, err := parseGroupKeys(keys)
+	return err
+}
+
+// parseGroupKeys parses group keys of the form label:<key>, annotation:<key>
+// or <key>, which is a label key.
+func parseGroupKeys(keys []string) ([]groupKey, error) {
+	if len(keys) == 0 {
+		return []groupKey{{name: appGroup}}, nil
+	}
+	parsed := make([]groupKey, 0, len(keys))
+	for _, key := range keys {
+		var k groupKey
+		switch {
+		case strings.HasPrefix(key, annotationKeyPrefix):
+			k = groupKey{annotation: true, name: strings.TrimPrefix(key, annotationKeyPrefix)}
+		default:
+			k = groupKey{name: strings.TrimPrefix(key, labelKeyPrefix)}
+		}
+		if errs := validation.IsQualifiedName(k.name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid group key %q: %s", key, strings.Join(errs, "; "))
+		}
+		parsed = append(parsed, k)
+	}
+	return parsed, nil
+}
+
+// applicationGroupOf returns the application group of the workload from the first
+// key of the fallback chain which is set, empty when none of them is.
+func applicationGroupOf(obj metav1.Object) string {
+	cacheMu.RLock()
+	keys := groupKeys
+	cacheMu.RUnlock()
+	return groupOf(obj, keys)
+}
+
+// groupOf returns the application group of the workload from the first of the keys
+// which is set, empty when none of them is.
+func groupOf(obj metav1.Object, keys []groupKey) string {
+	for _, key := range keys {
+		values := obj.GetLabels()
+		if key.annotation {
+			values = obj.GetAnnotations()
+		}
+		if group := values[key.name]; group != "" {
+			return group
+		}
+	}
+	return ""
+}
+
+// objectLabels returns the labels of a cached workload, nil for unexpected objects.
+func objectLabels(obj interface{}) labels.Set {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	return accessor.GetLabels()
+}
+
+// parseSelector parses the label selector query parameter, an empty
+// selector selects everything and is returned as nil.
+func parseSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSelector, err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseGroupKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		want    []groupKey
+		wantErr bool
+	}{
+		{
+			name: "defaults to the applicationGroup label",
+			want: []groupKey{{name: appGroup}},
+		},
+		{
+			name: "labels and annotations",
+			keys: []string{"app.kubernetes.io/part-of", "label:team", "annotation:example.com/group"},
+			want: []groupKey{{name: "app.kubernetes.io/part-of"}, {name: "team"}, {annotation: true, name: "example.com/group"}},
+		},
+		{
+			name:    "invalid key Failure",
+			keys:    []string{"label:not a key"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGroupKeys(tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGroupKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseGroupKeys() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseGroupKeys()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplicationGroupOf(t *testing.T) {
+	keys, err := parseGroupKeys([]string{"app.kubernetes.io/part-of", "annotation:team"})
+	if err != nil {
+		t.Fatalf("failed to parse group keys: %v", err)
+	}
+	groupKeys = keys
+	t.Cleanup(func() { groupKeys = []groupKey{{name: appGroup}} })
+
+	tests := []struct {
+		name string
+		obj  metav1.Object
+		want string
+	}{
+		{
+			name: "first key wins",
+			obj: &metav1.ObjectMeta{
+				Labels:      map[string]string{"app.kubernetes.io/part-of": "shop"},
+				Annotations: map[string]string{"team": "payments"},
+			},
+			want: "shop",
+		},
+		{
+			name: "falls back to the annotation",
+			obj:  &metav1.ObjectMeta{Annotations: map[string]string{"team": "payments"}},
+			want: "payments",
+		},
+		{
+			name: "default label is not part of the chain",
+			obj:  &metav1.ObjectMeta{Labels: map[string]string{appGroup: testAppGrp}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applicationGroupOf(tt.obj); got != tt.want {
+				t.Errorf("applicationGroupOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		wantErr  bool
+	}{
+		{selector: ""},
+		{selector: "tier=web"},
+		{selector: "tier in (web,api),!canary"},
+		{selector: "env notin (dev)"},
+		{selector: "tier in (web", wantErr: true},
+		{selector: "=web", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			_, err := parseSelector(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errInvalidSelector) {
+				t.Errorf("parseSelector() error = %v, want %v", err, errInvalidSelector)
+			}
+		})
+	}
+}
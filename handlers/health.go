@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errKubeClientNotInitialized = errors.New("kube client is not initialized")
+
+// CheckAPIServer is a readiness check which fails when the api server can not be reached.
+func CheckAPIServer(r *http.Request) error {
+	client := kubeClient
+	if client == nil {
+		return errKubeClientNotInitialized
+	}
+	// do not hold up the probe past its deadline
+	return serverVersion(r.Context(), client)
+}
+
+// CheckCacheSynced is a readiness check which fails until the workload cache has synced.
+func CheckCacheSynced(_ *http.Request) error {
+	if !CacheSynced() {
+		return errCacheNotSynced
+	}
+	return nil
+}
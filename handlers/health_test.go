@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckAPIServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T)
+		wantErr bool
+	}{
+		{
+			name:    "client not initialized Failure",
+			setup:   func(*testing.T) { kubeClient = nil },
+			wantErr: true,
+		},
+		{
+			name:  "reachable",
+			setup: func(t *testing.T) { kubeClient = newVersionClient(t, serveVersion) },
+		},
+		{
+			name:    "unreachable Failure",
+			setup:   func(t *testing.T) { kubeClient = newVersionClient(t, failVersion) },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			err := CheckAPIServer(httptest.NewRequest("GET", "/readyz", nil))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckAPIServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCacheSynced(t *testing.T) {
+	resetCache()
+	if err := CheckCacheSynced(nil); !errors.Is(err, errCacheNotSynced) {
+		t.Errorf("expected %v before sync, got %v", errCacheNotSynced, err)
+	}
+	startFakeCache(t, fakeDeploymentSpec)
+	if err := CheckCacheSynced(nil); err != nil {
+		t.Errorf("expected synced cache, got %v", err)
+	}
+}
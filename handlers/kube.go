@@ -1,66 +1,182 @@
 package handlers
 
 import (
-	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/pflag"
-	appv1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
-var kubeClient kubernetes.Interface
+var (
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	// kubeRateLimiter limits the requests of both clients
+	kubeRateLimiter clientRateLimiter
+)
+
+var errNoClientConfig = errors.New("no in-cluster config and no kubeconfig file found, set --kubeconfig, KUBECONFIG or --master")
+
+// ClientConfig configures how the controller connects to the api server.
+type ClientConfig struct {
+	// Kubeconfig is the path to a kubeconfig file, empty follows the KUBECONFIG
+	// environment variable, the in-cluster config and ~/.kube/config in that order.
+	Kubeconfig string
+	// Context is the kubeconfig context to use, empty uses the current context.
+	Context string
+	// Master overrides the address of the api server.
+	Master string
+	// QPS and Burst limit the requests sent to the api server.
+	QPS   float32
+	Burst int
+	// Timeout of a single request, 0 does not time out.
+	Timeout time.Duration
+	// UserAgent is sent with every request, empty sends the client-go default.
+	UserAgent string
+}
 
-// InitKubeClient reads the current cluster config and initializes api client
+// InitKubeClient reads the cluster config selected by cfg and initializes api client
 // and returns errors if unable to retrieve the token.
-func InitKubeClient() error {
+func InitKubeClient(cfg ClientConfig) error {
 	log.Infof("intializing kube client")
 
-	// read config based on the service account token
-	conf, err := rest.InClusterConfig()
+	conf, source, err := restConfig(cfg)
 	if err != nil {
-		log.Errorf("error getting in cluster config: %v", err)
-		// If no in-cluster config, try the default location in the user's home directory
-		conf, err = getOutClusterConfig()
-		if err != nil {
-			log.Errorf("error getting OutClusterConfigs %v", err)
-			return err
-		}
+		log.Errorf("error getting cluster config: %v", err)
+		return err
 	}
+	log.WithField("host", conf.Host).Infof("using cluster config from %s", source)
 
-	clientset, err := kubernetes.NewForConfig(conf)
+	limiter := newClientRateLimiter(conf.QPS, conf.Burst)
+	clientset, dynClient, err := newClients(conf, limiter)
 	if err != nil {
 		log.Errorf("error getting kube clinet: %v", err)
 		return err
 	}
 	kubeClient = clientset
+	dynamicClient = dynClient
+	kubeRateLimiter = limiter
 	log.Infof("successfully initialized kube client")
 
 	return nil
 }
 
-// getOutClusterConfig
-func getOutClusterConfig() (*rest.Config, error) {
-	var kubeconfig *string
+// KubeClient returns the kube client initialized by InitKubeClient, nil before.
+func KubeClient() kubernetes.Interface {
+	return kubeClient
+}
+
+// DynamicClient returns the dynamic client initialized by InitKubeClient, nil before.
+func DynamicClient() dynamic.Interface {
+	return dynamicClient
+}
 
-	log.Infof("fetching out cluster config")
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = pflag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "absolute path to the kubeconfig file")
+// newClients creates the typed and the dynamic client of the rest config, which share
+// the rate limiter so the QPS and Burst limit all requests sent to the cluster.
+func newClients(conf *rest.Config, limiter clientRateLimiter) (kubernetes.Interface, dynamic.Interface, error) {
+	typedConf, dynConf := rest.CopyConfig(conf), rest.CopyConfig(conf)
+	typedConf.RateLimiter = limiter
+	dynConf.RateLimiter = limiter
+	clientset, err := kubernetes.NewForConfig(typedConf)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynClient, err := dynamic.NewForConfig(dynConf)
+	if err != nil {
+		return nil, nil, err
 	}
+	return clientset, dynClient, nil
+}
 
-	return clientcmd.BuildConfigFromFlags("", *kubeconfig)
+// restConfig returns the rest config selected by cfg and a description of its source.
+// The in-cluster config is used unless a kubeconfig is given through --kubeconfig
+// or KUBECONFIG, or --master or --context is set.
+func restConfig(cfg ClientConfig) (*rest.Config, string, error) {
+	var conf *rest.Config
+	var source string
+	if cfg.Kubeconfig == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" && cfg.Master == "" && cfg.Context == "" {
+		inCluster, err := rest.InClusterConfig()
+		if err == nil {
+			conf, source = inCluster, "in-cluster service account"
+		} else {
+			log.Debugf("no in-cluster config, falling back to kubeconfig: %v", err)
+		}
+	}
+	if conf == nil {
+		var err error
+		if conf, source, err = kubeconfigRestConfig(cfg); err != nil {
+			return nil, "", err
+		}
+	}
+
+	conf.QPS = cfg.QPS
+	conf.Burst = cfg.Burst
+	conf.Timeout = cfg.Timeout
+	if cfg.UserAgent != "" {
+		conf.UserAgent = cfg.UserAgent
+	}
+	return conf, source, nil
 }
 
-// ListDeployments makes kube client call to fetch the deployments based on given opts
-func ListDeployments(ctx context.Context, opts metav1.ListOptions) (*appv1.DeploymentList, error) {
-	log.Infof("fetching list of deployments with label %s", opts.LabelSelector)
-	listDeployCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	return kubeClient.AppsV1().Deployments(defaultNS).List(listDeployCtx, opts)
+// kubeconfigRestConfig loads the rest config with the standard clientcmd loading rules,
+// the files listed in KUBECONFIG are merged.
+func kubeconfigRestConfig(cfg ClientConfig) (*rest.Config, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cfg.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.Context}
+	overrides.ClusterInfo.Server = cfg.Master
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	var files []string
+	switch {
+	case cfg.Kubeconfig != "":
+		files = []string{cfg.Kubeconfig}
+	default:
+		// missing files of the loading precedence are skipped
+		for _, path := range rules.GetLoadingPrecedence() {
+			if _, err := os.Stat(path); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+	if len(files) == 0 && cfg.Master == "" {
+		return nil, "", errNoClientConfig
+	}
+
+	conf, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	var source string
+	switch {
+	case cfg.Kubeconfig != "":
+		source = "kubeconfig " + cfg.Kubeconfig
+	case len(files) > 0 && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) != "":
+		source = "KUBECONFIG " + strings.Join(files, string(filepath.ListSeparator))
+	case len(files) > 0:
+		source = "kubeconfig " + files[0]
+	default:
+		source = "--master"
+	}
+	if len(files) > 0 {
+		contextName := cfg.Context
+		if contextName == "" {
+			if raw, err := clientConfig.RawConfig(); err == nil {
+				contextName = raw.CurrentContext
+			}
+		}
+		source += fmt.Sprintf(" (context %q)", contextName)
+	}
+	if cfg.Master != "" && len(files) > 0 {
+		source += " with --master"
+	}
+	return conf, source, nil
 }
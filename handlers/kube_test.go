@@ -1,93 +1,189 @@
 package handlers
 
 import (
-	"context"
-	"errors"
-	"reflect"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	appv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
-	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/rest"
 )
 
-var fakeError = errors.New("failed to connect with api server 0.0.0.0:6443")
-
 var fakeDeploymentSpec = &appv1.Deployment{
-	ObjectMeta: metav1.ObjectMeta{Name: testServiceName, Namespace: defaultNS, Labels: map[string]string{appGroup: testAppGrp}},
+	ObjectMeta: metav1.ObjectMeta{Name: testServiceName, Namespace: testNamespace, Labels: map[string]string{appGroup: testAppGrp}},
 	Spec:       appv1.DeploymentSpec{},
 	Status:     appv1.DeploymentStatus{ReadyReplicas: 1},
 }
 
-func createFakeDeployment() {
-	_, _ = kubeClient.AppsV1().Deployments(defaultNS).Create(context.TODO(), fakeDeploymentSpec, metav1.CreateOptions{})
+// startFakeCache replaces the kube client with a fake clientset holding the given
+// objects and runs the workload cache for all namespaces on top of it until the test finishes.
+func startFakeCache(t *testing.T, objects ...runtime.Object) {
+	t.Helper()
+	startFakeNamespacedCache(t, nil, objects...)
 }
 
-func deleteFakeDeployment() {
-	_ = kubeClient.AppsV1().Deployments(defaultNS).Delete(context.TODO(), testServiceName, metav1.DeleteOptions{})
+// startFakeNamespacedCache is like startFakeCache but only watches the given namespaces.
+func startFakeNamespacedCache(t *testing.T, namespaces []string, objects ...runtime.Object) {
+	t.Helper()
+	kubeClient = fake.NewSimpleClientset(objects...)
+	if err := InitWorkloadCache(CacheConfig{Namespaces: namespaces}); err != nil {
+		t.Fatalf("failed to initialize workload cache: %v", err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	if !StartWorkloadCache(stopCh) {
+		t.Fatalf("workload cache did not sync")
+	}
 }
 
-var errorReaction = func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
-	return true, &appv1.DeploymentList{}, fakeError
+// resetCache drops the workload cache, so handlers behave as if it never synced.
+func resetCache() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	installed = nil
 }
 
-func TestListDeployments(t *testing.T) {
-	// create the fake client.
-	kubeClient = fake.NewSimpleClientset()
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: %[1]s-ctx
+clusters:
+- name: %[1]s
+  cluster:
+    server: https://%[1]s.example.com:6443
+contexts:
+- name: %[1]s-ctx
+  context:
+    cluster: %[1]s
+    user: %[1]s
+users:
+- name: %[1]s
+  user:
+    token: %[1]s-token
+`
+
+// writeKubeconfig writes a kubeconfig with a cluster, context and user of the given name.
+func writeKubeconfig(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(testKubeconfig, name)), 0o600); err != nil {
+		t.Fatalf("failed to write kubeconfig %v", err)
+	}
+	return path
+}
 
+func TestRestConfig(t *testing.T) {
+	prod, staging := writeKubeconfig(t, "prod"), writeKubeconfig(t, "staging")
 	tests := []struct {
-		name    string
-		ctx     context.Context
-		opts    metav1.ListOptions
-		want    *appv1.DeploymentList
-		wantErr error
+		name       string
+		cfg        ClientConfig
+		kubeconfig string
+		wantHost   string
+		wantSource string
 	}{
 		{
-			name:    "failure, api service unreachable",
-			ctx:     context.TODO(),
-			opts:    metav1.ListOptions{},
-			want:    &appv1.DeploymentList{},
-			wantErr: fakeError,
+			name:       "kubeconfig flag",
+			cfg:        ClientConfig{Kubeconfig: staging},
+			kubeconfig: prod,
+			wantHost:   "https://staging.example.com:6443",
+			wantSource: `kubeconfig ` + staging + ` (context "staging-ctx")`,
+		},
+		{
+			name:       "KUBECONFIG with several paths",
+			kubeconfig: prod + string(filepath.ListSeparator) + staging,
+			wantHost:   "https://prod.example.com:6443",
+			wantSource: `KUBECONFIG ` + prod + string(filepath.ListSeparator) + staging + ` (context "prod-ctx")`,
+		},
+		{
+			name:       "context of a merged kubeconfig",
+			cfg:        ClientConfig{Context: "staging-ctx"},
+			kubeconfig: prod + string(filepath.ListSeparator) + staging,
+			wantHost:   "https://staging.example.com:6443",
+			wantSource: `KUBECONFIG ` + prod + string(filepath.ListSeparator) + staging + ` (context "staging-ctx")`,
+		},
+		{
+			name:       "master overrides the kubeconfig server",
+			cfg:        ClientConfig{Kubeconfig: prod, Master: "https://10.0.0.1:6443"},
+			wantHost:   "https://10.0.0.1:6443",
+			wantSource: `kubeconfig ` + prod + ` (context "prod-ctx") with --master`,
+		},
+		{
+			name:       "master without kubeconfig",
+			cfg:        ClientConfig{Master: "https://10.0.0.1:6443"},
+			wantHost:   "https://10.0.0.1:6443",
+			wantSource: "--master",
+		},
+		{
+			name: "Failure unknown context",
+			cfg:  ClientConfig{Kubeconfig: prod, Context: "dev-ctx"},
+		},
+		{
+			name: "Failure missing kubeconfig",
+			cfg:  ClientConfig{Kubeconfig: filepath.Join(t.TempDir(), "missing.yaml")},
 		},
 		{
-			name: "success, get one deployment",
-			ctx:  context.Background(),
-			opts: metav1.ListOptions{},
-			want: &appv1.DeploymentList{
-				Items: []appv1.Deployment{*fakeDeploymentSpec},
-			},
-			wantErr: nil,
+			name: "Failure no config found",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// prepare test scenario
-			if strings.Contains(tt.name, "failure") {
-				// return a fake error getting the deployment  list
-				kubeClient.(*fake.Clientset).Fake.PrependReactor("list", "deployments", errorReaction)
-				defer func() {
-					// reset client set from error state
-					kubeClient = fake.NewSimpleClientset()
-				}()
-			}
-			if strings.Contains(tt.name, "success") {
-				// deploy one fake service
-				createFakeDeployment()
-				// clean fake deployment
-				defer deleteFakeDeployment()
-			}
+			// neither the in-cluster config nor ~/.kube/config of the machine are found
+			t.Setenv("KUBERNETES_SERVICE_HOST", "")
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("KUBECONFIG", tt.kubeconfig)
+			tt.cfg.QPS, tt.cfg.Burst, tt.cfg.Timeout, tt.cfg.UserAgent = 20, 30, time.Minute, "test-agent"
 
-			got, err := ListDeployments(tt.ctx, tt.opts)
-			if err != tt.wantErr {
-				t.Errorf("ListDeployments() error = %v, wantErr %v", err, tt.wantErr)
+			conf, source, err := restConfig(tt.cfg)
+			if strings.Contains(tt.name, "Failure") {
+				if err == nil {
+					t.Fatalf("expected error, got config from %s", source)
+				}
 				return
 			}
-			if !reflect.DeepEqual(got.Items, tt.want.Items) {
-				t.Errorf(" ListDeployments() \n got = %v,\n want %v", got, tt.want)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if conf.Host != tt.wantHost {
+				t.Errorf("mismatched host: want=%v, got=%v", tt.wantHost, conf.Host)
+			}
+			if source != tt.wantSource {
+				t.Errorf("mismatched source: want=%v, got=%v", tt.wantSource, source)
+			}
+			if conf.QPS != 20 || conf.Burst != 30 || conf.Timeout != time.Minute || conf.UserAgent != "test-agent" {
+				t.Errorf("client settings not applied: qps=%v, burst=%v, timeout=%v, user agent=%v", conf.QPS, conf.Burst, conf.Timeout, conf.UserAgent)
 			}
 		})
 	}
 }
+
+// newVersionClient returns a clientset of an api server which answers /version
+// with the given handler, the fake clientset does not serve raw requests.
+func newVersionClient(t *testing.T, handler http.HandlerFunc) kubernetes.Interface {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+// serveVersion answers /version like a reachable api server.
+func serveVersion(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"major":"1","minor":"32","gitVersion":"v1.32.1"}`)
+}
+
+// failVersion answers like an api server which is not ready.
+func failVersion(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, "etcd connection refused", http.StatusInternalServerError)
+}
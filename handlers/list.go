@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+)
+
+const (
+	// limitParam is the query parameter used to limit the number of services in a page
+	limitParam = "limit"
+	// continueParam is the query parameter used to request the page after a continue token
+	continueParam = "continue"
+	// sortParam is the query parameter used to sort services, a leading - sorts in descending order
+	sortParam = "sort"
+	// fieldsParam is the query parameter used to project services to a comma separated list of fields
+	fieldsParam = "fields"
+	// continueHeader carries the continue token of the next page, it is not set on the last page
+	continueHeader = "X-Continue"
+)
+
+var errInvalidParameter = errors.New("invalid query parameter")
+
+// sortFields maps the values of the sort query parameter to the service field they compare.
+var sortFields = map[string]func(a, b models.Service) int{
+	"name":  func(a, b models.Service) int { return strings.Compare(a.Name, b.Name) },
+	"group": func(a, b models.Service) int { return strings.Compare(a.ApplicationGroup, b.ApplicationGroup) },
+	"pods":  func(a, b models.Service) int { return a.RunningPodsCount - b.RunningPodsCount },
+}
+
+// listOptions holds the pagination, sorting and projection requested for a list of services.
+type listOptions struct {
+	limit int
+	// sort is the value of the sort query parameter, e.g. -pods
+	sort string
+	// filter identifies the filter of the listed services, see filterKey
+	filter string
+	// after is the last service of the previous page, nil on the first page
+	after  *continueToken
+	fields []string
+}
+
+// continueToken identifies the last service of a page, the next page starts
+// after it in the same order so changes of the cache do not shift pages. It is
+// only valid for the filter and sort order it was issued for.
+type continueToken struct {
+	Sort      string `json:"sort"`
+	Filter    string `json:"filter"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Group     string `json:"group"`
+	Pods      int    `json:"pods"`
+}
+
+func (t continueToken) service() models.Service {
+	return models.Service{Cluster: t.Cluster, Namespace: t.Namespace, Kind: t.Kind, Name: t.Name, ApplicationGroup: t.Group, RunningPodsCount: t.Pods}
+}
+
+func encodeContinueToken(sortBy, filter string, svc models.Service) string {
+	data, _ := json.Marshal(continueToken{
+		Sort:      sortBy,
+		Filter:    filter,
+		Cluster:   svc.Cluster,
+		Namespace: svc.Namespace,
+		Kind:      svc.Kind,
+		Name:      svc.Name,
+		Group:     svc.ApplicationGroup,
+		Pods:      svc.RunningPodsCount,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeContinueToken(token string) (*continueToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var t continueToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// filterKey returns the cluster, namespace, group, kind and selector of the filter in
+// a canonical form, the namespaces the caller is authorized for are left out.
+func filterKey(filter ServiceFilter) string {
+	key := url.Values{}
+	for name, value := range map[string]string{
+		clusterParam:   filter.Cluster,
+		namespaceParam: filter.Namespace,
+		appGroup:       filter.ApplicationGroup,
+		kindParam:      strings.ToLower(filter.Kind),
+	} {
+		if value != "" {
+			key.Set(name, value)
+		}
+	}
+	if filter.Selector != nil {
+		key.Set(selectorParam, filter.Selector.String())
+	}
+	return key.Encode()
+}
+
+// parseListOptions reads the limit, continue, sort and fields query parameters of the
+// request which lists the services of the filter.
+func parseListOptions(r *http.Request, filter ServiceFilter) (listOptions, error) {
+	query := r.URL.Query()
+	opts := listOptions{filter: filterKey(filter)}
+
+	if limit := query.Get(limitParam); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return listOptions{}, fmt.Errorf("%w: %s must be a positive number", errInvalidParameter, limitParam)
+		}
+		opts.limit = n
+	}
+
+	opts.sort = query.Get(sortParam)
+	if opts.sort != "" {
+		if _, ok := sortFields[strings.TrimPrefix(opts.sort, "-")]; !ok {
+			return listOptions{}, fmt.Errorf("%w: %s must be one of name, group or pods, optionally prefixed with -", errInvalidParameter, sortParam)
+		}
+	}
+
+	if token := query.Get(continueParam); token != "" {
+		after, err := decodeContinueToken(token)
+		if err != nil || after.Sort != opts.sort || after.Filter != opts.filter {
+			return listOptions{}, fmt.Errorf("%w: %s token is malformed or was issued for another filter or sort order", errInvalidParameter, continueParam)
+		}
+		opts.after = after
+	}
+
+	if fields := query.Get(fieldsParam); fields != "" {
+		known := jsonFieldNames(reflect.TypeOf(versionedServices(r, nil)).Elem())
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if !known[field] {
+				return listOptions{}, fmt.Errorf("%w: unknown field %q in %s", errInvalidParameter, field, fieldsParam)
+			}
+			if !slices.Contains(opts.fields, field) {
+				opts.fields = append(opts.fields, field)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// compare orders services by the sort field, ties and unsorted lists are
+// ordered by cluster, namespace, kind and name like ListServices returns them.
+func (o listOptions) compare(a, b models.Service) int {
+	if o.sort != "" {
+		cmp := sortFields[strings.TrimPrefix(o.sort, "-")](a, b)
+		if strings.HasPrefix(o.sort, "-") {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	if cmp := strings.Compare(a.Cluster, b.Cluster); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(a.Namespace, b.Namespace); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(a.Kind, b.Kind); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// page sorts the services and returns the requested page along with the
+// continue token of the next page, which is empty on the last page.
+func (o listOptions) page(services []models.Service) ([]models.Service, string) {
+	sort.SliceStable(services, func(i, j int) bool { return o.compare(services[i], services[j]) < 0 })
+	if o.after != nil {
+		after := o.after.service()
+		start := sort.Search(len(services), func(i int) bool { return o.compare(services[i], after) > 0 })
+		services = services[start:]
+	}
+	if o.limit == 0 || len(services) <= o.limit {
+		return services, ""
+	}
+	services = services[:o.limit]
+	return services, encodeContinueToken(o.sort, o.filter, services[len(services)-1])
+}
+
+// projectFields keeps only the given fields of every encoded item, in the given order.
+func projectFields(items interface{}, fields []string) (interface{}, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+	projected := make([]orderedObject, 0, len(objects))
+	for _, object := range objects {
+		p := make(orderedObject, 0, len(fields))
+		for _, field := range fields {
+			if value, ok := object[field]; ok {
+				p = append(p, orderedField{key: field, value: value})
+			}
+		}
+		projected = append(projected, p)
+	}
+	return projected, nil
+}
+
+// jsonFieldNames returns the names of the JSON encoded fields of a struct type.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newListDeployment(name, group string, ready int32) runtime.Object {
+	return &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: map[string]string{appGroup: group}},
+		Status:     appv1.DeploymentStatus{ReadyReplicas: ready},
+	}
+}
+
+// listPages requests every page of the services and returns the decoded items.
+func listPages(t *testing.T, handle httprouter.Handle, query url.Values) []map[string]interface{} {
+	t.Helper()
+	var items []map[string]interface{}
+	for page := 0; page < 10; page++ {
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest("GET", "http://test-service.com/services?"+query.Encode(), nil), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("mismatched status code: want=%v, got=%v, body=%s", http.StatusOK, w.Code, w.Body)
+		}
+		var pageItems []map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &pageItems); err != nil {
+			t.Fatalf("failed to unmarshal response %v", err)
+		}
+		items = append(items, pageItems...)
+		next := w.Header().Get(continueHeader)
+		if next == "" {
+			return items
+		}
+		query.Set(continueParam, next)
+	}
+	t.Fatalf("pagination did not end")
+	return nil
+}
+
+func TestGetServicesPagination(t *testing.T) {
+	startFakeCache(t,
+		newListDeployment("checkout", "alpha", 2),
+		newListDeployment("payments", "beta", 5),
+		newListDeployment("search", "alpha", 0),
+		newListDeployment("reports", "gamma", 1),
+		newListDeployment("auth", "beta", 3),
+	)
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantNames []string
+		wantKeys  []string
+	}{
+		{
+			name:      "unsorted pages keep the cache order",
+			query:     url.Values{limitParam: {"2"}},
+			wantNames: []string{"auth", "checkout", "payments", "reports", "search"},
+		},
+		{
+			name:      "sort by pods descending",
+			query:     url.Values{limitParam: {"2"}, sortParam: {"-pods"}},
+			wantNames: []string{"payments", "auth", "checkout", "reports", "search"},
+		},
+		{
+			name:      "sort by group",
+			query:     url.Values{limitParam: {"3"}, sortParam: {"group"}},
+			wantNames: []string{"checkout", "search", "auth", "payments", "reports"},
+		},
+		{
+			name:      "filtered pages",
+			query:     url.Values{limitParam: {"2"}, namespaceParam: {testNamespace}, kindParam: {"deployment"}, selectorParam: {appGroup + " in (alpha,beta)"}},
+			wantNames: []string{"auth", "checkout", "payments", "search"},
+		},
+		{
+			name:      "fields projection",
+			query:     url.Values{sortParam: {"name"}, fieldsParam: {"name,runningPodsCount"}},
+			wantNames: []string{"auth", "checkout", "payments", "reports", "search"},
+			wantKeys:  []string{"name", "runningPodsCount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := listPages(t, GetServices, tt.query)
+			var names []string
+			for _, item := range items {
+				names = append(names, item["name"].(string))
+				if tt.wantKeys == nil {
+					continue
+				}
+				var keys []string
+				for key := range item {
+					keys = append(keys, key)
+				}
+				if len(keys) != len(tt.wantKeys) {
+					t.Errorf("projected item %v, want fields %v", item, tt.wantKeys)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("mismatched services: want=%v, got=%v", tt.wantNames, names)
+			}
+		})
+	}
+}
+
+func TestGetServicesInvalidListOptions(t *testing.T) {
+	startFakeCache(t, fakeDeploymentSpec)
+	sortedToken := encodeContinueToken("name", "", models.Service{Namespace: testNamespace, Kind: "Deployment", Name: testServiceName})
+	filteredToken := encodeContinueToken("", filterKey(ServiceFilter{Namespace: testNamespace}), models.Service{Namespace: testNamespace, Kind: "Deployment", Name: testServiceName})
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "Failure, zero limit", query: url.Values{limitParam: {"0"}}},
+		{name: "Failure, invalid limit", query: url.Values{limitParam: {"ten"}}},
+		{name: "Failure, unknown sort field", query: url.Values{sortParam: {"age"}}},
+		{name: "Failure, malformed continue token", query: url.Values{continueParam: {"not-a-token"}}},
+		{name: "Failure, continue token of another sort order", query: url.Values{continueParam: {sortedToken}, sortParam: {"pods"}}},
+		{name: "Failure, continue token of another filter", query: url.Values{continueParam: {filteredToken}, namespaceParam: {"kube-system"}}},
+		{name: "Failure, continue token of an unfiltered list", query: url.Values{continueParam: {sortedToken}, sortParam: {"name"}, kindParam: {"Deployment"}}},
+		{name: "Failure, unknown field", query: url.Values{fieldsParam: {"name,replicas"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetServices(w, httptest.NewRequest("GET", "http://test-service.com/services?"+tt.query.Encode(), nil), nil)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("mismatched status code: want=%v, got=%v", http.StatusBadRequest, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), CodeInvalidParameter) {
+				t.Errorf("mismatched error: want=%v, got=%v", CodeInvalidParameter, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	cacheObjectsDesc = prometheus.NewDesc("workload_cache_objects",
+		"Number of workload objects held by the informer cache.",
+		[]string{"cluster", "namespace", "kind"}, nil)
+	readyPodsDesc = prometheus.NewDesc("app_group_ready_pods",
+		"Number of healthy pods of a service.",
+		[]string{"cluster", "namespace", "group", "service", "kind"}, nil)
+	desiredReplicasDesc = prometheus.NewDesc("app_group_desired_replicas",
+		"Number of replicas a service is expected to run.",
+		[]string{"cluster", "namespace", "group", "service", "kind"}, nil)
+)
+
+// cacheCollector reports the workload cache and the services it holds, the
+// values are read from the cache on every scrape so deleted services vanish.
+type cacheCollector struct{}
+
+// NewCacheCollector returns the Prometheus collector of the workload cache.
+func NewCacheCollector() prometheus.Collector {
+	return cacheCollector{}
+}
+
+func (cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheObjectsDesc
+	ch <- readyPodsDesc
+	ch <- desiredReplicasDesc
+}
+
+// Collect reports every service once, objects which map to the labels of a service
+// already reported, e.g. two sources with the same kind or a custom name path, are
+// only counted as cache objects as a duplicate series would fail the whole scrape.
+func (cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	type cacheKey struct{ cluster, namespace, kind string }
+	type serviceKey struct{ cluster, namespace, group, name, kind string }
+	objects := make(map[cacheKey]int)
+	services := make(map[serviceKey]bool)
+	_, informers := cachedInformers()
+	for _, wi := range informers {
+		for _, obj := range wi.informer.GetIndexer().List() {
+			svc, ok := wi.service(obj)
+			if !ok {
+				continue
+			}
+			objects[cacheKey{svc.Cluster, svc.Namespace, svc.Kind}]++
+			key := serviceKey{svc.Cluster, svc.Namespace, svc.ApplicationGroup, svc.Name, svc.Kind}
+			if services[key] {
+				log.Debugf("skipping metrics of duplicate %s %s/%s in cluster %q", svc.Kind, svc.Namespace, svc.Name, svc.Cluster)
+				continue
+			}
+			services[key] = true
+			ch <- prometheus.MustNewConstMetric(readyPodsDesc, prometheus.GaugeValue,
+				float64(svc.RunningPodsCount), svc.Cluster, svc.Namespace, svc.ApplicationGroup, svc.Name, svc.Kind)
+			ch <- prometheus.MustNewConstMetric(desiredReplicasDesc, prometheus.GaugeValue,
+				float64(svc.Replicas.Desired), svc.Cluster, svc.Namespace, svc.ApplicationGroup, svc.Name, svc.Kind)
+		}
+	}
+	for key, count := range objects {
+		ch <- prometheus.MustNewConstMetric(cacheObjectsDesc, prometheus.GaugeValue, float64(count), key.cluster, key.namespace, key.kind)
+	}
+}
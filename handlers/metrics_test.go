@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestCacheCollector(t *testing.T) {
+	startFakeCache(t,
+		&appv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "shop", Labels: map[string]string{appGroup: testAppGrp}},
+			Spec:       appv1.DeploymentSpec{Replicas: ptr.To[int32](3)},
+			Status:     appv1.DeploymentStatus{ReadyReplicas: 2},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "shop", Labels: map[string]string{appGroup: testAppGrp}},
+		},
+	)
+
+	want := `
+# HELP app_group_desired_replicas Number of replicas a service is expected to run.
+# TYPE app_group_desired_replicas gauge
+app_group_desired_replicas{cluster="",group="alpha",kind="CronJob",namespace="shop",service="report"} 1
+app_group_desired_replicas{cluster="",group="alpha",kind="Deployment",namespace="shop",service="checkout"} 3
+# HELP app_group_ready_pods Number of healthy pods of a service.
+# TYPE app_group_ready_pods gauge
+app_group_ready_pods{cluster="",group="alpha",kind="CronJob",namespace="shop",service="report"} 0
+app_group_ready_pods{cluster="",group="alpha",kind="Deployment",namespace="shop",service="checkout"} 2
+# HELP workload_cache_objects Number of workload objects held by the informer cache.
+# TYPE workload_cache_objects gauge
+workload_cache_objects{cluster="",kind="CronJob",namespace="shop"} 1
+workload_cache_objects{cluster="",kind="Deployment",namespace="shop"} 1
+`
+	if err := testutil.CollectAndCompare(NewCacheCollector(), strings.NewReader(want)); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
+
+func TestCacheCollectorDuplicateServices(t *testing.T) {
+	kubeClient = newFakeDiscoveryClient()
+	// both rollouts are named after their app label, so they map to the same service
+	source := rolloutSource
+	source.NamePath = "{.metadata.labels.app}"
+	dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{rolloutGVR: "RolloutList"},
+		newRollout("checkout-blue", map[string]interface{}{appGroup: testAppGrp, "app": "checkout"}, map[string]interface{}{"readyReplicas": int64(2)}),
+		newRollout("checkout-green", map[string]interface{}{appGroup: testAppGrp, "app": "checkout"}, map[string]interface{}{"readyReplicas": int64(2)}),
+	)
+	if err := InitWorkloadCache(CacheConfig{Kinds: []string{"Deployment"}, Sources: []WorkloadSource{source}}); err != nil {
+		t.Fatalf("failed to initialize workload cache: %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if !StartWorkloadCache(stopCh) {
+		t.Fatalf("workload cache did not sync")
+	}
+
+	want := `
+# HELP app_group_ready_pods Number of healthy pods of a service.
+# TYPE app_group_ready_pods gauge
+app_group_ready_pods{cluster="",group="alpha",kind="Rollout",namespace="default",service="checkout"} 2
+# HELP workload_cache_objects Number of workload objects held by the informer cache.
+# TYPE workload_cache_objects gauge
+workload_cache_objects{cluster="",kind="Rollout",namespace="default"} 2
+`
+	if err := testutil.CollectAndCompare(NewCacheCollector(), strings.NewReader(want), "app_group_ready_pods", "workload_cache_objects"); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
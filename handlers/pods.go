@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// serviceNameParam is the path parameter holding the name of a service
+const serviceNameParam = "name"
+
+var errNoPodSelector = errors.New("service has no pod selector")
+
+// ListServicePods resolves the pod selector of the service with the given name
+// which matches the filter, and returns the pods currently selected by it.
+func ListServicePods(ctx context.Context, filter ServiceFilter, name string) ([]models.Pod, error) {
+	wi, obj, err := getWorkload(filter, name)
+	if err != nil {
+		return nil, err
+	}
+	selectorKind, ok := wi.kind.(podSelectorKind)
+	if !ok {
+		return nil, errNoPodSelector
+	}
+	labelSelector, ok := selectorKind.PodSelector(obj)
+	if !ok {
+		return nil, errNoPodSelector
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	// an empty selector would select every pod in the namespace
+	if selector.Empty() {
+		return nil, errNoPodSelector
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("cluster", wi.cluster.name).Infof("fetching pods of %s %s/%s with selector %s", wi.kind.Kind(), objMeta.GetNamespace(), name, selector)
+	listPodsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	podList, err := wi.cluster.client.CoreV1().Pods(objMeta.GetNamespace()).List(listPodsCtx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	pods := make([]models.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, newPod(&podList.Items[i], now))
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods, nil
+}
+
+// newPod converts a pod into the pod model exposed by the api.
+func newPod(pod *corev1.Pod, now time.Time) models.Pod {
+	p := models.Pod{
+		Name:       pod.GetName(),
+		Phase:      string(pod.Status.Phase),
+		Node:       pod.Spec.NodeName,
+		PodIP:      pod.Status.PodIP,
+		Containers: make([]models.Container, 0, len(pod.Status.ContainerStatuses)),
+		CreatedAt:  pod.GetCreationTimestamp().Time,
+		Age:        duration.HumanDuration(now.Sub(pod.GetCreationTimestamp().Time)),
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			p.Ready = cond.Status == corev1.ConditionTrue
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		container := models.Container{
+			Name:         status.Name,
+			Ready:        status.Ready,
+			RestartCount: int(status.RestartCount),
+		}
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			container.LastTerminationReason = terminated.Reason
+		}
+		p.Containers = append(p.Containers, container)
+	}
+	return p
+}
+
+// GetServicePods handler fetches the pods of a single service of the given
+// application group and writes their state back to the client. The optional
+// namespace, kind and selector query parameters pick the service when its name is not unique.
+func GetServicePods(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	log.Infof("Incomming request %s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+
+	filter, err := serviceFilter(r, params.ByName(appGroup))
+	if err != nil {
+		writeError(w, r, "failed to list pods", err)
+		return
+	}
+	pods, err := ListServicePods(r.Context(), filter, params.ByName(serviceNameParam))
+	if err != nil {
+		writeError(w, r, "failed to list pods", err)
+		return
+	}
+
+	respBytes, err := json.Marshal(pods)
+	if err != nil {
+		writeEncodeError(w, r, err)
+		return
+	}
+
+	responseWriter(w, r, respBytes, http.StatusOK)
+	log.Infof("successfully written response")
+}
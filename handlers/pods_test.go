@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	podCreatedAt = metav1.NewTime(time.Now().Add(-72*time.Hour - 10*time.Minute).Truncate(time.Second))
+
+	selectorDeployment = &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: testNamespace, Labels: map[string]string{appGroup: testAppGrp}},
+		Spec:       appv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout"}}},
+	}
+	selectorStatefulSet = &appv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: testNamespace, Labels: map[string]string{appGroup: testAppGrp}},
+		Spec:       appv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout-db"}}},
+	}
+	backupCronJob = &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: testNamespace, Labels: map[string]string{appGroup: testAppGrp}},
+	}
+
+	checkoutPod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout-7d9f-abcde", Namespace: testNamespace, Labels: map[string]string{"app": "checkout"}, CreationTimestamp: podCreatedAt},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "10.0.0.12",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "app",
+				RestartCount:         4,
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled"}},
+			}},
+		},
+	}
+	unrelatedPod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments-5c8b-fghij", Namespace: testNamespace, Labels: map[string]string{"app": "payments"}},
+	}
+)
+
+var checkoutPodModel = models.Pod{
+	Name:       "checkout-7d9f-abcde",
+	Phase:      "Running",
+	Ready:      false,
+	Node:       "node-1",
+	PodIP:      "10.0.0.12",
+	Containers: []models.Container{{Name: "app", RestartCount: 4, LastTerminationReason: "OOMKilled"}},
+	CreatedAt:  podCreatedAt.Time,
+	Age:        "3d",
+}
+
+func TestListServicePods(t *testing.T) {
+	startFakeCache(t, selectorDeployment, selectorStatefulSet, backupCronJob, checkoutPod, unrelatedPod)
+
+	tests := []struct {
+		name    string
+		filter  ServiceFilter
+		svcName string
+		want    []models.Pod
+		wantErr error
+	}{
+		{
+			name:    "success, pods of deployment",
+			filter:  ServiceFilter{ApplicationGroup: testAppGrp, Kind: "Deployment"},
+			svcName: "checkout",
+			want:    []models.Pod{checkoutPodModel},
+		},
+		{
+			name:    "success, no pods selected",
+			filter:  ServiceFilter{ApplicationGroup: testAppGrp, Kind: "StatefulSet"},
+			svcName: "checkout",
+			want:    []models.Pod{},
+		},
+		{
+			name:    "failure, name matches several workloads",
+			filter:  ServiceFilter{ApplicationGroup: testAppGrp},
+			svcName: "checkout",
+			wantErr: errServiceAmbiguous,
+		},
+		{
+			name:    "failure, service of other group",
+			filter:  ServiceFilter{ApplicationGroup: "beta", Kind: "Deployment"},
+			svcName: "checkout",
+			wantErr: errServiceNotFound,
+		},
+		{
+			name:    "failure, kind without pod selector",
+			filter:  ServiceFilter{ApplicationGroup: testAppGrp},
+			svcName: "backup",
+			wantErr: errNoPodSelector,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ListServicePods(context.TODO(), tt.filter, tt.svcName)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ListServicePods() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListServicePods() \n got = %v,\n want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServicePods(t *testing.T) {
+	startFakeCache(t, selectorDeployment, checkoutPod)
+
+	tests := []struct {
+		name     string
+		params   httprouter.Params
+		want     []models.Pod
+		wantCode int
+	}{
+		{
+			name:     "Success, pods of service",
+			params:   httprouter.Params{{Key: appGroup, Value: testAppGrp}, {Key: serviceNameParam, Value: "checkout"}},
+			want:     []models.Pod{checkoutPodModel},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Failure, unknown service",
+			params:   httprouter.Params{{Key: appGroup, Value: testAppGrp}, {Key: serviceNameParam, Value: "unknown"}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetServicePods(w, httptest.NewRequest("GET", "http://test-service.com/services/alpha/checkout/pods", nil), tt.params)
+
+			if tt.wantCode != w.Code {
+				t.Errorf("mismatched status code: want=%v, got=%v", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var gotResp []models.Pod
+			if err := json.Unmarshal(w.Body.Bytes(), &gotResp); err != nil {
+				t.Errorf("failed to unmarshal response %v", err)
+			}
+			if len(gotResp) != len(tt.want) || gotResp[0].Name != tt.want[0].Name || !gotResp[0].CreatedAt.Equal(tt.want[0].CreatedAt) {
+				t.Errorf("want %v, got %v", tt.want, gotResp)
+			}
+		})
+	}
+}
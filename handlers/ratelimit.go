@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// clientRateLimiter limits the requests of an api client to a token bucket whose
+// rate and burst can be changed while the client is in use.
+type clientRateLimiter struct {
+	limiter *rate.Limiter
+}
+
+var _ flowcontrol.RateLimiter = clientRateLimiter{}
+
+// newClientRateLimiter returns a rate limiter allowing qps requests per second with
+// bursts of burst requests.
+func newClientRateLimiter(qps float32, burst int) clientRateLimiter {
+	return clientRateLimiter{limiter: rate.NewLimiter(rate.Limit(qps), burst)}
+}
+
+// SetClientRateLimits changes the rate limits of the api clients of every registered
+// cluster, requests waiting for a token wait for the new rate. The limiters of the
+// clusters which were replaced are left alone, they go away with their clients.
+func SetClientRateLimits(qps float32, burst int) {
+	for _, c := range registeredClusters() {
+		if c.rateLimiter.limiter == nil {
+			continue
+		}
+		c.rateLimiter.limiter.SetLimit(rate.Limit(qps))
+		c.rateLimiter.limiter.SetBurst(burst)
+	}
+}
+
+func (r clientRateLimiter) TryAccept() bool { return r.limiter.Allow() }
+
+func (r clientRateLimiter) Accept() { _ = r.limiter.Wait(context.Background()) }
+
+func (r clientRateLimiter) Wait(ctx context.Context) error { return r.limiter.Wait(ctx) }
+
+func (r clientRateLimiter) QPS() float32 { return float32(r.limiter.Limit()) }
+
+func (r clientRateLimiter) Stop() {}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestSetClientRateLimits(t *testing.T) {
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, "prod")+string(filepath.ListSeparator)+writeKubeconfig(t, "staging"))
+	client := ClientConfig{QPS: 5, Burst: 10}
+	if err := InitKubeClient(client); err != nil {
+		t.Fatalf("failed to initialize kube client: %v", err)
+	}
+	if err := InitClusters(client, ClustersConfig{Name: "prod", Contexts: []string{"staging-ctx"}}); err != nil {
+		t.Fatalf("failed to initialize clusters: %v", err)
+	}
+	t.Cleanup(func() {
+		clustersMu.Lock()
+		clusters = nil
+		clustersMu.Unlock()
+		kubeClient, dynamicClient, kubeRateLimiter = nil, nil, clientRateLimiter{}
+	})
+	limiterOf := func(c *cluster) flowcontrol.RateLimiter { return c.client.Discovery().RESTClient().GetRateLimiter() }
+	local, staging := limiterOf(registeredClusters()[0]), limiterOf(registeredClusters()[1])
+	if got := local.QPS(); got != 5 {
+		t.Errorf("mismatched initial qps: want=5, got=%v", got)
+	}
+
+	SetClientRateLimits(50, 100)
+	for name, limiter := range map[string]flowcontrol.RateLimiter{"local": local, "staging": staging} {
+		if got := limiter.QPS(); got != 50 {
+			t.Errorf("mismatched qps of %s after change: want=50, got=%v", name, got)
+		}
+		if got := limiter.(clientRateLimiter).limiter.Burst(); got != 100 {
+			t.Errorf("mismatched burst of %s after change: want=100, got=%v", name, got)
+		}
+	}
+
+	// the limiter of a cluster which is no longer registered is dropped along with it
+	if err := InitClusters(client, ClustersConfig{Name: "prod"}); err != nil {
+		t.Fatalf("failed to initialize clusters: %v", err)
+	}
+	SetClientRateLimits(7, 8)
+	if got := local.QPS(); got != 7 {
+		t.Errorf("mismatched qps of the local cluster: want=7, got=%v", got)
+	}
+	if got := staging.QPS(); got != 50 {
+		t.Errorf("limiter of the removed cluster was changed: want=50, got=%v", got)
+	}
+}
@@ -2,104 +2,114 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/shani1998/k8s-utility-controller/models"
 	log "github.com/sirupsen/logrus"
-	appv1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
-	defaultNS = "default"
-	appGroup  = "applicationGroup"
+	appGroup = "applicationGroup"
+	// clusterParam is the query parameter used to filter services by cluster
+	clusterParam = "cluster"
+	// namespaceParam is the query parameter used to filter services by namespace
+	namespaceParam = "namespace"
+	// kindParam is the query parameter used to filter services by workload kind
+	kindParam = "kind"
+	// selectorParam is the query parameter used to filter services by a label selector on the workloads
+	selectorParam = "selector"
 )
 
-var HealthChan = make(chan error)
-
-func responseWriter(w http.ResponseWriter, respBytes []byte, code int) {
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(code)
-	_, err := w.Write(respBytes)
+// serviceFilter builds the filter of the cluster, namespace, kind and selector query
+// parameters of the request for the given application group, limited to the
+// namespaces the caller is authorized for.
+func serviceFilter(r *http.Request, group string) (ServiceFilter, error) {
+	selector, err := parseSelector(r.URL.Query().Get(selectorParam))
 	if err != nil {
-		log.Errorf("failed to write response %v", err)
-	}
-	if code == http.StatusOK {
-		//clear the error from the healthCheck variable
-		HealthChan <- nil
-	} else {
-		// update health if response is not success
-		HealthChan <- fmt.Errorf("%s", respBytes)
+		return ServiceFilter{}, err
 	}
+	return ServiceFilter{
+		Cluster:           r.URL.Query().Get(clusterParam),
+		Namespace:         r.URL.Query().Get(namespaceParam),
+		ApplicationGroup:  group,
+		Kind:              r.URL.Query().Get(kindParam),
+		Selector:          selector,
+		AllowedNamespaces: authorizedNamespaces(r),
+	}, nil
 }
 
-func getResponseBytes(deployments *appv1.DeploymentList) ([]byte, error) {
-	response := make([]models.Service, 0)
-
-	// traverse through all deployments
-	for _, deploy := range deployments.Items {
-		svc := models.Service{
-			Name:             deploy.GetName(),
-			ApplicationGroup: deploy.GetLabels()[appGroup],
-			RunningPodsCount: int(deploy.Status.ReadyReplicas),
+func getResponseBytes(r *http.Request, services []models.Service, fields []string) ([]byte, error) {
+	// encode response to byte object in the requested api version
+	items := versionedServices(r, services)
+	if len(fields) > 0 {
+		var err error
+		if items, err = projectFields(items, fields); err != nil {
+			return nil, err
 		}
-		response = append(response, svc)
 	}
-
-	// encode response to byte object
-	return json.Marshal(response)
-
+	return json.Marshal(items)
 }
 
-// GetServices handler accepts incoming requests for list services, and it fetches
-// the service information from the cluster and writes response back to the client.
-func GetServices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	log.Infof("Incomming request %s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
-
-	// list deployments for given namespace with context
-	deployments, err := ListDeployments(r.Context(), metav1.ListOptions{})
+// writeServices lists the services of the given application group, all groups when empty,
+// which match the query parameters of the request and writes the requested page of them.
+func writeServices(w http.ResponseWriter, r *http.Request, group string) {
+	filter, err := serviceFilter(r, group)
+	if err != nil {
+		writeError(w, r, "failed to list services", err)
+		return
+	}
+	opts, err := parseListOptions(r, filter)
+	if err != nil {
+		writeError(w, r, "failed to list services", err)
+		return
+	}
+	services, warnings, err := ListServices(filter)
+	writeWarnings(w, warnings)
 	if err != nil {
-		log.Errorf("error listing deployments %v", err)
-		responseWriter(w, []byte("failed to list services"), http.StatusServiceUnavailable)
+		writeError(w, r, "failed to list services", err)
 		return
 	}
+	services, next := opts.page(services)
 
 	// prepare response with fetched services
-	respBytes, err := getResponseBytes(deployments)
+	respBytes, err := getResponseBytes(r, services, opts.fields)
 	if err != nil {
-		log.Errorf("error marshaling response %v", err)
-		responseWriter(w, []byte("failed to list services"), http.StatusServiceUnavailable)
+		writeEncodeError(w, r, err)
 		return
 	}
 
-	responseWriter(w, respBytes, http.StatusOK)
+	if next != "" {
+		w.Header().Set(continueHeader, next)
+	}
+	responseWriter(w, r, respBytes, http.StatusOK)
 	log.Infof("successfully written response")
 }
 
-// GetServicesByAppLabel handler fetches list of deployments with given app group in default ns
-// and write response back to the client
-func GetServicesByAppLabel(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	log.Infof("Incomming request %s %s %s %s", r.Method, r.RequestURI, r.RemoteAddr, params.ByName(appGroup))
+// GetServices handler accepts incoming requests for list services, and it fetches
+// the service information from the cluster and writes response back to the client.
+// The optional cluster, namespace and kind query parameters limit the services to one
+// cluster, one namespace and one workload kind, the selector query parameter to the workloads
+// matching a label selector. The limit, continue, sort and fields query parameters
+// page, sort and project the services.
+func GetServices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log.Infof("Incomming request %s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
 
-	// get all deployments for given app label
-	listOptions := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", appGroup, params.ByName(appGroup))}
-	deployments, err := ListDeployments(r.Context(), listOptions)
-	if err != nil {
-		log.Errorf("error listing deployments %v", err)
-		responseWriter(w, []byte("failed to list services"), http.StatusServiceUnavailable)
-		return
-	}
+	// list workloads of all watched namespaces, or the requested one, from the cache
+	writeServices(w, r, "")
+}
 
-	// prepare response with fetched services
-	respBytes, err := getResponseBytes(deployments)
-	if err != nil {
-		log.Errorf("error marshaling response %v", err)
-		responseWriter(w, []byte("failed to list services"), http.StatusServiceUnavailable)
+// GetServicesByAppLabel handler fetches list of workloads with given app group in the
+// watched namespaces and write response back to the client, it accepts the same
+// query parameters as GetServices.
+func GetServicesByAppLabel(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// httprouter can not register /services/watch next to /services/:applicationGroup
+	if params.ByName(appGroup) == watchPath {
+		WatchServices(w, r, params)
 		return
 	}
+	log.Infof("Incomming request %s %s %s %s", r.Method, r.RequestURI, r.RemoteAddr, params.ByName(appGroup))
 
-	responseWriter(w, respBytes, http.StatusOK)
-	log.Infof("successfully written response")
+	// get all workloads for given app label from the cache
+	writeServices(w, r, params.ByName(appGroup))
 }
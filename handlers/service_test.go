@@ -11,24 +11,18 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/shani1998/k8s-utility-controller/models"
-	"k8s.io/client-go/kubernetes/fake"
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	testServiceName = "fake-test-service"
 	testAppGrp      = "alpha"
+	testNamespace   = "default"
 )
 
 func TestGetServices(t *testing.T) {
-	// create the fake client.
-	kubeClient = fake.NewSimpleClientset()
 	req := httptest.NewRequest("GET", "http://test-service.com/services", nil)
-	go func() {
-		for {
-			// consume test errors
-			<-HealthChan
-		}
-	}()
 
 	tests := []struct {
 		name     string
@@ -58,6 +52,8 @@ func TestGetServices(t *testing.T) {
 			rq:   req,
 			want: []models.Service{
 				{
+					Namespace:        testNamespace,
+					Kind:             "Deployment",
 					Name:             "fake-test-service",
 					ApplicationGroup: "alpha",
 					RunningPodsCount: 1,
@@ -69,18 +65,15 @@ func TestGetServices(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// prepare test scenario
-			if strings.Contains(tt.name, "Failure") {
-				// return a fake error getting the deployment  list
-				kubeClient.(*fake.Clientset).Fake.PrependReactor("list", "deployments", errorReaction)
-				defer func() {
-					// reset client set from error state
-					kubeClient = fake.NewSimpleClientset()
-				}()
-			}
-			if strings.Contains(tt.name, "one service deployed") {
+			switch {
+			case strings.Contains(tt.name, "Failure"):
+				// deployment cache never synced
+				resetCache()
+			case strings.Contains(tt.name, "one service deployed"):
 				// deploy one fake service
-				createFakeDeployment()
-				defer deleteFakeDeployment()
+				startFakeCache(t, fakeDeploymentSpec)
+			default:
+				startFakeCache(t)
 			}
 
 			w := httptest.NewRecorder()
@@ -117,16 +110,8 @@ func TestGetServices(t *testing.T) {
 }
 
 func TestGetServicesByAppLabel(t *testing.T) {
-	// create the fake client.
-	kubeClient = fake.NewSimpleClientset()
 	req := httptest.NewRequest("GET", "http://test.service.com/services/invalid<label>", nil)
 	testParams := httprouter.Params{httprouter.Param{Key: appGroup, Value: "alpha"}}
-	go func() {
-		for {
-			// consume test errors
-			<-HealthChan
-		}
-	}()
 
 	tests := []struct {
 		name     string
@@ -145,6 +130,14 @@ func TestGetServicesByAppLabel(t *testing.T) {
 			wantErr:  errors.New("failed to list services"),
 			wantCode: http.StatusServiceUnavailable,
 		},
+		{
+			name:     "Failure, invalid selector",
+			rq:       httptest.NewRequest("GET", "http://test.service.com/services/alpha?selector=tier+in+(web", nil),
+			params:   testParams,
+			want:     []models.Service{},
+			wantErr:  errors.New("invalid label selector"),
+			wantCode: http.StatusBadRequest,
+		},
 		{
 			name:     "Success, no services deployed",
 			rq:       req,
@@ -167,6 +160,8 @@ func TestGetServicesByAppLabel(t *testing.T) {
 			params: testParams,
 			want: []models.Service{
 				{
+					Namespace:        testNamespace,
+					Kind:             "Deployment",
 					Name:             "fake-test-service",
 					ApplicationGroup: "alpha",
 					RunningPodsCount: 1,
@@ -177,21 +172,18 @@ func TestGetServicesByAppLabel(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			//prepare test scenario
-			if strings.Contains(tt.name, "Failure") {
-				// return a fake error getting the deployment  list
-				kubeClient.(*fake.Clientset).Fake.PrependReactor("list", "deployments", errorReaction)
-				defer func() {
-					// reset client set from error state
-					kubeClient = fake.NewSimpleClientset()
-				}()
-			}
-			if strings.Contains(tt.name, "one service deployed") {
+			// prepare test scenario
+			switch {
+			case strings.Contains(tt.name, "Failure"):
+				// deployment cache never synced
+				resetCache()
+			case strings.Contains(tt.name, "one service deployed"):
 				// deploy one fake service
-				createFakeDeployment()
+				startFakeCache(t, fakeDeploymentSpec)
 				// filter by label alpha
 				tt.rq = httptest.NewRequest("GET", "http://test-service.com/services/alpha", nil)
-				defer deleteFakeDeployment()
+			default:
+				startFakeCache(t)
 			}
 
 			w := httptest.NewRecorder()
@@ -226,3 +218,102 @@ func TestGetServicesByAppLabel(t *testing.T) {
 		})
 	}
 }
+
+func TestGetServicesNamespaceFilter(t *testing.T) {
+	startFakeNamespacedCache(t, []string{testNamespace}, fakeDeploymentSpec)
+
+	tests := []struct {
+		name     string
+		url      string
+		want     []models.Service
+		wantCode int
+	}{
+		{
+			name: "Success, services of watched namespace",
+			url:  "http://test-service.com/services?namespace=" + testNamespace,
+			want: []models.Service{
+				{
+					Namespace:        testNamespace,
+					Kind:             "Deployment",
+					Name:             testServiceName,
+					ApplicationGroup: testAppGrp,
+					RunningPodsCount: 1,
+				}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Failure, namespace not watched",
+			url:      "http://test-service.com/services?namespace=kube-system",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetServices(w, httptest.NewRequest("GET", tt.url, nil), nil)
+
+			if tt.wantCode != w.Code {
+				t.Errorf("mismatched status code: want=%v, got=%v", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var gotResp []models.Service
+			if err := json.Unmarshal(w.Body.Bytes(), &gotResp); err != nil {
+				t.Errorf("failed to unmarshal response %v", err)
+			}
+			if !reflect.DeepEqual(tt.want, gotResp) {
+				t.Errorf("want %v, got %v", tt.want, gotResp)
+			}
+		})
+	}
+}
+
+func TestGetServicesAPIVersions(t *testing.T) {
+	scaledDown := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: testNamespace, Labels: map[string]string{appGroup: testAppGrp}},
+		Status:     appv1.DeploymentStatus{ObservedGeneration: 1, UnavailableReplicas: 1},
+	}
+	startFakeCache(t, scaledDown)
+
+	tests := []struct {
+		name    string
+		handle  httprouter.Handle
+		want    string
+		notWant string
+	}{
+		{
+			name:    "unversioned requests are served v1",
+			handle:  GetServices,
+			want:    `"runningPodsCount":0`,
+			notWant: `"replicas"`,
+		},
+		{
+			name:    "v1 keeps zero counts",
+			handle:  WithAPIVersion(APIVersionV1, GetServices),
+			want:    `[{"namespace":"default","kind":"Deployment","name":"checkout","applicationGroup":"alpha","runningPodsCount":0}]`,
+			notWant: `"health"`,
+		},
+		{
+			name:   "v2 reports replica status and health",
+			handle: WithAPIVersion(APIVersionV2, GetServices),
+			want: `[{"namespace":"default","kind":"Deployment","name":"checkout","applicationGroup":"alpha","runningPodsCount":0,` +
+				`"replicas":{"desired":1,"ready":0,"updated":0,"available":0,"unavailable":1},"observedGeneration":1,"health":"Down"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handle(w, httptest.NewRequest("GET", "http://test-service.com/services", nil), nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("mismatched status code: want=%v, got=%v", http.StatusOK, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("response %s does not contain %s", w.Body, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(w.Body.String(), tt.notWant) {
+				t.Errorf("response %s contains %s", w.Body, tt.notWant)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultNamePath = "{.metadata.name}"
+
+// WorkloadSource describes a custom resource which is watched through the
+// dynamic client and reported as a service, e.g. an Argo Rollout.
+type WorkloadSource struct {
+	// Group, Version and Resource name the watched resource.
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	// Kind reported for the resource, defaults to the kind served by the api server.
+	Kind string `json:"kind,omitempty"`
+	// NamePath is the JSONPath of the service name, defaults to {.metadata.name}.
+	NamePath string `json:"namePath,omitempty"`
+	// GroupPath is the JSONPath of the application group, defaults to the configured group keys.
+	GroupPath string `json:"groupPath,omitempty"`
+	// ReadyPath is the JSONPath of the number of healthy pods, a boolean
+	// result such as a Ready condition status counts as one or zero pods.
+	ReadyPath string `json:"readyPath"`
+	// DesiredPath is the JSONPath of the number of desired pods, e.g. {.spec.replicas}.
+	// Without it a source is healthy while any pod is ready and down otherwise.
+	DesiredPath string `json:"desiredPath,omitempty"`
+}
+
+// GroupVersionResource returns the resource watched by the source.
+func (s WorkloadSource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: s.Group, Version: s.Version, Resource: s.Resource}
+}
+
+// LoadWorkloadSources reads the list of workload sources from the given YAML or JSON file.
+func LoadWorkloadSources(path string) ([]WorkloadSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read workload sources file %s: %v", path, err)
+	}
+	var sources []WorkloadSource
+	if err := yaml.UnmarshalStrict(data, &sources); err != nil {
+		return nil, fmt.Errorf("unable to parse workload sources file %s: %v", path, err)
+	}
+	return sources, nil
+}
+
+// newRESTMapper builds a RESTMapper from the api groups served by the cluster of the client.
+func newRESTMapper(client kubernetes.Interface) (meta.RESTMapper, error) {
+	groupResources, err := restmapper.GetAPIGroupResources(client.Discovery())
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover api group resources: %v", err)
+	}
+	return restmapper.NewDiscoveryRESTMapper(groupResources), nil
+}
+
+// sourceKind reports the objects of a custom resource, it reads the
+// service fields from the unstructured object with JSONPath expressions.
+type sourceKind struct {
+	kind string
+	gvr  schema.GroupVersionResource
+
+	// jsonpath templates keep state while executing, mu serializes their use
+	mu       sync.Mutex
+	namePath *jsonpath.JSONPath
+	// groupPath is nil when the group is read from the configured group keys
+	groupPath *jsonpath.JSONPath
+	readyPath *jsonpath.JSONPath
+	// desiredPath is nil when the source does not declare its desired pods
+	desiredPath *jsonpath.JSONPath
+}
+
+// newSourceKind validates the source against the RESTMapper and parses its JSONPath expressions.
+func newSourceKind(source WorkloadSource, mapper meta.RESTMapper) (*sourceKind, error) {
+	gvr := source.GroupVersionResource()
+	if gvr.Version == "" || gvr.Resource == "" {
+		return nil, fmt.Errorf("workload source %v must name a version and resource", gvr)
+	}
+	if source.ReadyPath == "" {
+		return nil, fmt.Errorf("workload source %v must have a readyPath", gvr)
+	}
+
+	// find the kind served for the GVR and make sure it is namespaced
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping gvr=%v to gvk, error=%v", gvr, err)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping gvk=%v to gvr, error=%v", gvk, err)
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil, fmt.Errorf("workload source %v is not namespaced", gvr)
+	}
+
+	kind := &sourceKind{kind: source.Kind, gvr: gvr}
+	if kind.kind == "" {
+		kind.kind = gvk.Kind
+	}
+	if kind.namePath, err = parseJSONPath("name", source.NamePath, defaultNamePath); err != nil {
+		return nil, err
+	}
+	if source.GroupPath != "" {
+		if kind.groupPath, err = parseJSONPath("group", source.GroupPath, ""); err != nil {
+			return nil, err
+		}
+	}
+	if kind.readyPath, err = parseJSONPath("ready", source.ReadyPath, ""); err != nil {
+		return nil, err
+	}
+	if source.DesiredPath != "" {
+		if kind.desiredPath, err = parseJSONPath("desired", source.DesiredPath, ""); err != nil {
+			return nil, err
+		}
+	}
+	return kind, nil
+}
+
+// parseJSONPath parses the expression, which may omit the surrounding braces.
+func parseJSONPath(name, expr, defaultExpr string) (*jsonpath.JSONPath, error) {
+	if expr == "" {
+		expr = defaultExpr
+	}
+	if !strings.HasPrefix(expr, "{") {
+		expr = "{" + expr + "}"
+	}
+	path := jsonpath.New(name).AllowMissingKeys(true)
+	if err := path.Parse(expr); err != nil {
+		return nil, fmt.Errorf("invalid %s path %q: %v", name, expr, err)
+	}
+	return path, nil
+}
+
+func (k *sourceKind) Kind() string { return k.kind }
+
+func (k *sourceKind) Informer(factories *namespaceFactories) cache.SharedIndexInformer {
+	return factories.dynamic.ForResource(k.gvr).Informer()
+}
+
+func (k *sourceKind) Service(obj interface{}) (models.Service, bool) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return models.Service{}, false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	name, err := executeJSONPath(k.namePath, u)
+	if err != nil || name == "" {
+		log.Errorf("unable to read name of %s %s/%s: %v", k.kind, u.GetNamespace(), u.GetName(), err)
+		return models.Service{}, false
+	}
+	group := applicationGroupOf(u)
+	if k.groupPath != nil {
+		if group, err = executeJSONPath(k.groupPath, u); err != nil {
+			log.Errorf("unable to read application group of %s %s/%s: %v", k.kind, u.GetNamespace(), u.GetName(), err)
+		}
+	}
+	ready, err := executeJSONPath(k.readyPath, u)
+	if err != nil {
+		log.Errorf("unable to read ready count of %s %s/%s: %v", k.kind, u.GetNamespace(), u.GetName(), err)
+	}
+	replicas := models.ReplicaStatus{Ready: int32(parseReadyCount(ready))}
+	replicas.Available = replicas.Ready
+	observedGeneration, _, _ := unstructured.NestedInt64(u.Object, "status", "observedGeneration")
+
+	var health string
+	if k.desiredPath == nil {
+		health = models.HealthDown
+		if replicas.Ready > 0 {
+			health = models.HealthHealthy
+		}
+	} else {
+		desired, err := executeJSONPath(k.desiredPath, u)
+		if err != nil {
+			log.Errorf("unable to read desired count of %s %s/%s: %v", k.kind, u.GetNamespace(), u.GetName(), err)
+		}
+		// custom resources do not report updated replicas
+		replicas.Desired = int32(parseReadyCount(desired))
+		replicas.Updated = replicas.Desired
+		replicas.Unavailable = unavailable(replicas.Desired, replicas.Available)
+		// resources without an observed generation are taken as observed
+		healthGeneration := observedGeneration
+		if healthGeneration == 0 {
+			healthGeneration = u.GetGeneration()
+		}
+		health = replicaHealth(u.GetGeneration(), healthGeneration, replicas)
+	}
+
+	svc := newService(k.kind, u, replicas, observedGeneration, health)
+	svc.Name = name
+	svc.ApplicationGroup = group
+	return svc, true
+}
+
+// PodSelector reads the label selector from spec.selector, which is where
+// workload-like custom resources such as Argo Rollouts keep it.
+func (k *sourceKind) PodSelector(obj interface{}) (*metav1.LabelSelector, bool) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false
+	}
+	raw, found, err := unstructured.NestedMap(u.Object, "spec", "selector")
+	if err != nil || !found {
+		return nil, false
+	}
+	selector := &metav1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, selector); err != nil {
+		log.Errorf("unable to read selector of %s %s/%s: %v", k.kind, u.GetNamespace(), u.GetName(), err)
+		return nil, false
+	}
+	return selector, true
+}
+
+func executeJSONPath(path *jsonpath.JSONPath, u *unstructured.Unstructured) (string, error) {
+	var buf bytes.Buffer
+	if err := path.Execute(&buf, u.UnstructuredContent()); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// parseReadyCount turns the result of a readyPath into a number of pods,
+// booleans such as "True" count as one pod.
+func parseReadyCount(ready string) int {
+	if count, err := strconv.Atoi(ready); err == nil {
+		return count
+	}
+	if isTrue, err := strconv.ParseBool(ready); err == nil && isTrue {
+		return 1
+	}
+	return 0
+}
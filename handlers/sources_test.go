@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var rolloutGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+var rolloutSource = WorkloadSource{
+	Group:     rolloutGVR.Group,
+	Version:   rolloutGVR.Version,
+	Resource:  rolloutGVR.Resource,
+	ReadyPath: ".status.readyReplicas",
+}
+
+func newRollout(name string, labels map[string]interface{}, status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": testNamespace,
+			"labels":    labels,
+		},
+		"status": status,
+	}}
+}
+
+// newFakeDiscoveryClient returns a fake clientset whose discovery serves the rollouts resource.
+func newFakeDiscoveryClient() *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: rolloutGVR.GroupVersion().String(),
+			APIResources: []metav1.APIResource{
+				{Name: "rollouts", Kind: "Rollout", Namespaced: true},
+				{Name: "clusteranalysistemplates", Kind: "ClusterAnalysisTemplate", Namespaced: false},
+			},
+		},
+	}
+	return client
+}
+
+func TestNewSourceKind(t *testing.T) {
+	kubeClient = newFakeDiscoveryClient()
+	mapper, err := newRESTMapper(kubeClient)
+	if err != nil {
+		t.Fatalf("failed to create rest mapper: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		source   WorkloadSource
+		wantKind string
+		wantErr  bool
+	}{
+		{name: "kind from api server", source: rolloutSource, wantKind: "Rollout"},
+		{name: "kind overridden", source: WorkloadSource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts", Kind: "ArgoRollout", ReadyPath: "{.status.readyReplicas}"}, wantKind: "ArgoRollout"},
+		{name: "resource not served", source: WorkloadSource{Group: "serving.knative.dev", Version: "v1", Resource: "services", ReadyPath: ".status"}, wantErr: true},
+		{name: "cluster scoped resource", source: WorkloadSource{Group: "argoproj.io", Version: "v1alpha1", Resource: "clusteranalysistemplates", ReadyPath: ".status"}, wantErr: true},
+		{name: "missing ready path", source: WorkloadSource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}, wantErr: true},
+		{name: "invalid jsonpath", source: WorkloadSource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts", ReadyPath: "{.status[}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newSourceKind(tt.source, mapper)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newSourceKind() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Kind() != tt.wantKind {
+				t.Errorf("newSourceKind() kind = %v, want %v", got.Kind(), tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestSourceKindService(t *testing.T) {
+	kubeClient = newFakeDiscoveryClient()
+	mapper, err := newRESTMapper(kubeClient)
+	if err != nil {
+		t.Fatalf("failed to create rest mapper: %v", err)
+	}
+	readyCondition := WorkloadSource{
+		Group:     rolloutGVR.Group,
+		Version:   rolloutGVR.Version,
+		Resource:  rolloutGVR.Resource,
+		NamePath:  "{.metadata.labels.app}",
+		GroupPath: "{.metadata.labels.team}",
+		ReadyPath: `{.status.conditions[?(@.type=="Ready")].status}`,
+	}
+	desiredReplicas := rolloutSource
+	desiredReplicas.DesiredPath = "{.status.replicas}"
+
+	tests := []struct {
+		name   string
+		source WorkloadSource
+		obj    interface{}
+		want   models.Service
+		wantOk bool
+	}{
+		{
+			name:   "ready replicas",
+			source: rolloutSource,
+			obj:    newRollout("checkout", map[string]interface{}{appGroup: testAppGrp}, map[string]interface{}{"readyReplicas": int64(3)}),
+			want: models.Service{Namespace: testNamespace, Kind: "Rollout", Name: "checkout", ApplicationGroup: testAppGrp, RunningPodsCount: 3,
+				Replicas: models.ReplicaStatus{Ready: 3, Available: 3}, Health: models.HealthHealthy},
+			wantOk: true,
+		},
+		{
+			name:   "desired replicas",
+			source: desiredReplicas,
+			obj: newRollout("checkout", map[string]interface{}{appGroup: testAppGrp}, map[string]interface{}{
+				"replicas": int64(4), "readyReplicas": int64(3), "observedGeneration": int64(1),
+			}),
+			want: models.Service{Namespace: testNamespace, Kind: "Rollout", Name: "checkout", ApplicationGroup: testAppGrp, RunningPodsCount: 3,
+				Replicas: models.ReplicaStatus{Desired: 4, Ready: 3, Updated: 4, Available: 3, Unavailable: 1}, ObservedGeneration: 1, Health: models.HealthDegraded},
+			wantOk: true,
+		},
+		{
+			name:   "missing status counts zero pods",
+			source: rolloutSource,
+			obj:    newRollout("checkout", nil, nil),
+			want:   models.Service{Namespace: testNamespace, Kind: "Rollout", Name: "checkout", Health: models.HealthDown},
+			wantOk: true,
+		},
+		{
+			name:   "custom paths and ready condition",
+			source: readyCondition,
+			obj: newRollout("checkout-7d9f", map[string]interface{}{"app": "checkout", "team": "payments"}, map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+			}),
+			want: models.Service{Namespace: testNamespace, Kind: "Rollout", Name: "checkout", ApplicationGroup: "payments", RunningPodsCount: 1,
+				Replicas: models.ReplicaStatus{Ready: 1, Available: 1}, Health: models.HealthHealthy},
+			wantOk: true,
+		},
+		{
+			name:   "missing name is not reported",
+			source: readyCondition,
+			obj:    newRollout("checkout-7d9f", nil, nil),
+			wantOk: false,
+		},
+		{
+			name:   "unexpected object",
+			source: rolloutSource,
+			obj:    fakeDeploymentSpec,
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, err := newSourceKind(tt.source, mapper)
+			if err != nil {
+				t.Fatalf("newSourceKind() error = %v", err)
+			}
+			got, ok := kind.Service(tt.obj)
+			if ok != tt.wantOk {
+				t.Errorf("Service() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListServicesWithSources(t *testing.T) {
+	kubeClient = newFakeDiscoveryClient()
+	dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{rolloutGVR: "RolloutList"},
+		newRollout("checkout", map[string]interface{}{appGroup: testAppGrp}, map[string]interface{}{"readyReplicas": int64(2)}),
+	)
+	if err := InitWorkloadCache(CacheConfig{Kinds: []string{"Deployment"}, Sources: []WorkloadSource{rolloutSource}}); err != nil {
+		t.Fatalf("failed to initialize workload cache: %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if !StartWorkloadCache(stopCh) {
+		t.Fatalf("workload cache did not sync")
+	}
+
+	want := []models.Service{{Namespace: testNamespace, Kind: "Rollout", Name: "checkout", ApplicationGroup: testAppGrp, RunningPodsCount: 2,
+		Replicas: models.ReplicaStatus{Ready: 2, Available: 2}, Health: models.HealthHealthy}}
+	for _, filter := range []ServiceFilter{{}, {ApplicationGroup: testAppGrp}, {Kind: "rollout"}} {
+		got, _, err := ListServices(filter)
+		if err != nil {
+			t.Errorf("ListServices(%+v) error = %v", filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ListServices(%+v) got = %v, want %v", filter, got, want)
+		}
+	}
+}
+
+func TestParseReadyCount(t *testing.T) {
+	tests := map[string]int{"3": 3, "0": 0, "True": 1, "true": 1, "False": 0, "": 0, "unknown": 0}
+	for ready, want := range tests {
+		if got := parseReadyCount(ready); got != want {
+			t.Errorf("parseReadyCount(%q) = %v, want %v", ready, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+)
+
+var errGroupNotFound = errors.New("application group not found")
+
+// healthSeverity orders the health states from best to worst.
+var healthSeverity = map[string]int{
+	models.HealthHealthy:     0,
+	models.HealthProgressing: 1,
+	models.HealthDegraded:    2,
+	models.HealthDown:        3,
+}
+
+// summarizeGroups aggregates the services per application group, services without
+// a group are left out. Groups are sorted by name, members keep the order of services.
+func summarizeGroups(services []models.Service) []models.GroupSummary {
+	byGroup := make(map[string]*models.GroupSummary)
+	names := make([]string, 0)
+	for _, svc := range services {
+		if svc.ApplicationGroup == "" {
+			continue
+		}
+		summary, ok := byGroup[svc.ApplicationGroup]
+		if !ok {
+			summary = &models.GroupSummary{
+				ApplicationGroup:  svc.ApplicationGroup,
+				Health:            svc.Health,
+				WorstService:      svc,
+				UnhealthyServices: make([]models.Service, 0),
+			}
+			byGroup[svc.ApplicationGroup] = summary
+			names = append(names, svc.ApplicationGroup)
+		}
+		summary.ServicesCount++
+		summary.TotalPodsCount += int(svc.Replicas.Desired)
+		summary.ReadyPodsCount += svc.RunningPodsCount
+		if healthSeverity[svc.Health] > healthSeverity[summary.Health] {
+			summary.Health = svc.Health
+			summary.WorstService = svc
+		}
+		if svc.Health != models.HealthHealthy {
+			summary.UnhealthyServices = append(summary.UnhealthyServices, svc)
+		}
+	}
+
+	sort.Strings(names)
+	summaries := make([]models.GroupSummary, 0, len(names))
+	for _, name := range names {
+		summaries = append(summaries, *byGroup[name])
+	}
+	return summaries
+}
+
+// GetGroups handler returns the summary of every application group of the
+// services in the watched namespaces. The optional namespace, kind and
+// selector query parameters limit the services which are aggregated.
+func GetGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log.Infof("Incomming request %s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+
+	filter, err := serviceFilter(r, "")
+	if err != nil {
+		writeError(w, r, "failed to list groups", err)
+		return
+	}
+	services, warnings, err := ListServices(filter)
+	writeWarnings(w, warnings)
+	if err != nil {
+		writeError(w, r, "failed to list groups", err)
+		return
+	}
+
+	respBytes, err := json.Marshal(summarizeGroups(services))
+	if err != nil {
+		writeEncodeError(w, r, err)
+		return
+	}
+
+	responseWriter(w, r, respBytes, http.StatusOK)
+	log.Infof("successfully written response")
+}
+
+// GetGroupSummary handler returns the summary of a single application group,
+// it accepts the same query parameters as GetGroups.
+func GetGroupSummary(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	log.Infof("Incomming request %s %s %s %s", r.Method, r.RequestURI, r.RemoteAddr, params.ByName(appGroup))
+
+	filter, err := serviceFilter(r, params.ByName(appGroup))
+	if err != nil {
+		writeError(w, r, "failed to summarize group", err)
+		return
+	}
+	services, warnings, err := ListServices(filter)
+	writeWarnings(w, warnings)
+	if err != nil {
+		writeError(w, r, "failed to summarize group", err)
+		return
+	}
+	summaries := summarizeGroups(services)
+	if len(summaries) == 0 {
+		writeError(w, r, "failed to summarize group", errGroupNotFound)
+		return
+	}
+
+	respBytes, err := json.Marshal(summaries[0])
+	if err != nil {
+		writeEncodeError(w, r, err)
+		return
+	}
+
+	responseWriter(w, r, respBytes, http.StatusOK)
+	log.Infof("successfully written response")
+}
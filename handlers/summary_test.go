@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSummarizeGroups(t *testing.T) {
+	healthy := models.Service{Name: "checkout", ApplicationGroup: testAppGrp, RunningPodsCount: 3,
+		Replicas: models.ReplicaStatus{Desired: 3, Ready: 3}, Health: models.HealthHealthy}
+	degraded := models.Service{Name: "payments", ApplicationGroup: testAppGrp, RunningPodsCount: 1,
+		Replicas: models.ReplicaStatus{Desired: 2, Ready: 1}, Health: models.HealthDegraded}
+	progressing := models.Service{Name: "search", ApplicationGroup: testAppGrp, RunningPodsCount: 2,
+		Replicas: models.ReplicaStatus{Desired: 2, Ready: 2}, Health: models.HealthProgressing}
+	down := models.Service{Name: "reports", ApplicationGroup: "beta",
+		Replicas: models.ReplicaStatus{Desired: 1}, Health: models.HealthDown}
+	ungrouped := models.Service{Name: "tooling", Replicas: models.ReplicaStatus{Desired: 1}, Health: models.HealthDown}
+
+	tests := []struct {
+		name     string
+		services []models.Service
+		want     []models.GroupSummary
+	}{
+		{
+			name: "no services",
+			want: []models.GroupSummary{},
+		},
+		{
+			name:     "worst member and unhealthy members per group",
+			services: []models.Service{down, healthy, progressing, degraded, ungrouped},
+			want: []models.GroupSummary{
+				{
+					ApplicationGroup:  testAppGrp,
+					ServicesCount:     3,
+					TotalPodsCount:    7,
+					ReadyPodsCount:    6,
+					Health:            models.HealthDegraded,
+					WorstService:      degraded,
+					UnhealthyServices: []models.Service{progressing, degraded},
+				},
+				{
+					ApplicationGroup:  "beta",
+					ServicesCount:     1,
+					TotalPodsCount:    1,
+					ReadyPodsCount:    0,
+					Health:            models.HealthDown,
+					WorstService:      down,
+					UnhealthyServices: []models.Service{down},
+				},
+			},
+		},
+		{
+			name:     "healthy group",
+			services: []models.Service{healthy},
+			want: []models.GroupSummary{
+				{
+					ApplicationGroup:  testAppGrp,
+					ServicesCount:     1,
+					TotalPodsCount:    3,
+					ReadyPodsCount:    3,
+					Health:            models.HealthHealthy,
+					WorstService:      healthy,
+					UnhealthyServices: []models.Service{},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeGroups(tt.services); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summarizeGroups() \n got = %+v,\n want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetGroupSummary(t *testing.T) {
+	otherGroupDeployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "another-service", Namespace: testNamespace, Labels: map[string]string{appGroup: "beta"}},
+	}
+
+	tests := []struct {
+		name         string
+		handle       httprouter.Handle
+		params       httprouter.Params
+		wantCode     int
+		wantGroups   []string
+		wantErrorMsg string
+	}{
+		{
+			name:       "Success, all groups",
+			handle:     GetGroups,
+			wantCode:   http.StatusOK,
+			wantGroups: []string{testAppGrp, "beta"},
+		},
+		{
+			name:       "Success, one group",
+			handle:     GetGroupSummary,
+			params:     httprouter.Params{{Key: appGroup, Value: "beta"}},
+			wantCode:   http.StatusOK,
+			wantGroups: []string{"beta"},
+		},
+		{
+			name:         "Failure, unknown group",
+			handle:       GetGroupSummary,
+			params:       httprouter.Params{{Key: appGroup, Value: "unknown"}},
+			wantCode:     http.StatusNotFound,
+			wantErrorMsg: CodeGroupNotFound,
+		},
+	}
+	startFakeCache(t, fakeDeploymentSpec, otherGroupDeployment)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handle(w, httptest.NewRequest("GET", "http://test-service.com/groups", nil), tt.params)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("mismatched status code: want=%v, got=%v", tt.wantCode, w.Code)
+			}
+			if strings.Contains(tt.name, "Failure") {
+				if !strings.Contains(w.Body.String(), tt.wantErrorMsg) {
+					t.Errorf("mismatched error: want=%v, got=%v", tt.wantErrorMsg, w.Body)
+				}
+				return
+			}
+
+			var summaries []models.GroupSummary
+			if len(tt.params) > 0 {
+				var summary models.GroupSummary
+				if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+					t.Fatalf("failed to unmarshal response %v", err)
+				}
+				summaries = append(summaries, summary)
+			} else if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+				t.Fatalf("failed to unmarshal response %v", err)
+			}
+			var got []string
+			for _, summary := range summaries {
+				got = append(got, summary.ApplicationGroup)
+			}
+			if !reflect.DeepEqual(got, tt.wantGroups) {
+				t.Errorf("mismatched groups: want=%v, got=%v", tt.wantGroups, got)
+			}
+		})
+	}
+}
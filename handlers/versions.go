@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+)
+
+// versions of the services api, v1 is served for requests without a version
+const (
+	APIVersionV1 = "v1"
+	APIVersionV2 = "v2"
+)
+
+type apiVersionKey struct{}
+
+// WithAPIVersion serves the handler in the given version of the services api.
+func WithAPIVersion(version string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		handle(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)), params)
+	}
+}
+
+// apiVersion returns the version of the services api requested by r.
+func apiVersion(r *http.Request) string {
+	if version, ok := r.Context().Value(apiVersionKey{}).(string); ok {
+		return version
+	}
+	return APIVersionV1
+}
+
+// versionedServices returns the services in the representation of the requested api version.
+func versionedServices(r *http.Request, services []models.Service) interface{} {
+	if apiVersion(r) != APIVersionV1 {
+		return services
+	}
+	v1 := make([]models.ServiceV1, 0, len(services))
+	for _, svc := range services {
+		v1 = append(v1, svc.V1())
+	}
+	return v1
+}
+
+// versionedEvent returns the event in the representation of the requested api version.
+func versionedEvent(r *http.Request, ev models.ServiceEvent) interface{} {
+	if apiVersion(r) != APIVersionV1 {
+		return ev
+	}
+	return ev.V1()
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// watchPath is the path segment of the watch endpoint below /services
+	watchPath = "watch"
+	// eventBufferSize is the number of recent events kept for resuming streams
+	eventBufferSize = 1024
+	// subscriberBufferSize is the number of events queued per client before it is dropped
+	subscriberBufferSize = 256
+)
+
+// heartbeatInterval is how often a comment is sent on an idle stream to keep proxies from closing it.
+var heartbeatInterval = 15 * time.Second
+
+// serviceEvents fans out the service changes seen by the informers to the watching clients.
+var serviceEvents = newEventHub(eventBufferSize)
+
+// sequencedEvent is a service event along with its id in the stream.
+type sequencedEvent struct {
+	id uint64
+	// epoch of the hub which numbered the event
+	epoch string
+	models.ServiceEvent
+	// labels of the workload, matched against the selector of the watchers
+	labels labels.Set
+	// object is the workload the event was published for, nil when unknown
+	object runtime.Object
+}
+
+// eventID returns the id of the event in the stream.
+func (ev sequencedEvent) eventID() string {
+	return formatEventID(ev.epoch, ev.id)
+}
+
+// eventHub numbers service events, keeps the most recent ones for
+// clients resuming with Last-Event-ID and fans them out to subscribers.
+type eventHub struct {
+	mu sync.Mutex
+	// epoch identifies the numbering of the hub in the event ids, the ids of another
+	// process or replica, or from before a reset, do not match it and are never resumed
+	epoch       string
+	seq         uint64
+	size        int
+	recent      []sequencedEvent
+	subscribers map[chan sequencedEvent]struct{}
+}
+
+func newEventHub(size int) *eventHub {
+	return &eventHub{epoch: newEpoch(), size: size, subscribers: make(map[chan sequencedEvent]struct{})}
+}
+
+// newEpoch returns a random epoch for the ids of an event hub.
+func newEpoch() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// formatEventID returns the id of the event with the given sequence number, <epoch>-<seq>.
+func formatEventID(epoch string, seq uint64) string {
+	return epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseEventID returns the sequence number of the event id, ok is false when the
+// id is malformed or was not numbered in the current epoch. h.mu must be held.
+func (h *eventHub) parseEventID(id string) (seq uint64, ok bool) {
+	epoch, n, found := strings.Cut(id, "-")
+	if !found || epoch != h.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(n, 10, 64)
+	return seq, err == nil
+}
+
+// publish numbers the event of the workload obj and sends it to every subscriber,
+// subscribers which can not keep up are dropped and have to resume the stream.
+func (h *eventHub) publish(ev models.ServiceEvent, obj interface{}) {
+	object, _ := obj.(runtime.Object)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	sev := sequencedEvent{id: h.seq, epoch: h.epoch, ServiceEvent: ev, labels: objectLabels(obj), object: object}
+	h.recent = append(h.recent, sev)
+	// trim in batches to not copy the buffer on every event
+	if len(h.recent) >= 2*h.size {
+		h.recent = append([]sequencedEvent(nil), h.recent[len(h.recent)-h.size:]...)
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- sev:
+		default:
+			log.Warnf("dropping slow service watcher")
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a new subscriber. When the events following lastEventID
+// are still buffered they are returned for replay, otherwise replayed is false and
+// the caller has to send a snapshot first. snapshotID is the id of the last event
+// published before the subscription.
+func (h *eventHub) subscribe(lastEventID string) (ch chan sequencedEvent, replay []sequencedEvent, replayed bool, snapshotID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch = make(chan sequencedEvent, subscriberBufferSize)
+	h.subscribers[ch] = struct{}{}
+
+	lastID, resume := h.parseEventID(lastEventID)
+	oldest := h.seq - uint64(len(h.recent)) + 1
+	if resume && lastID <= h.seq && lastID+1 >= oldest {
+		for _, ev := range h.recent {
+			if ev.id > lastID {
+				replay = append(replay, ev)
+			}
+		}
+		replayed = true
+	}
+	return ch, replay, replayed, formatEventID(h.epoch, h.seq)
+}
+
+// unsubscribe removes the subscriber, it is a no-op for dropped subscribers.
+func (h *eventHub) unsubscribe(ch chan sequencedEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// reset starts a new epoch without buffered events and drops every subscriber,
+// streams start over with a snapshot and in-process subscribers resync.
+func (h *eventHub) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.epoch = newEpoch()
+	h.seq = 0
+	h.recent = nil
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// WatchServiceEvents calls handle with every service change and the workload it
+// belongs to until stopCh is closed. When handle falls behind the changes in between
+// are dropped and resync is called before the following changes, the caller has to
+// reread the services.
+func WatchServiceEvents(stopCh <-chan struct{}, handle func(models.ServiceEvent, runtime.Object), resync func()) {
+	for {
+		ch, _, _, _ := serviceEvents.subscribe("")
+		for dropped := false; !dropped; {
+			select {
+			case <-stopCh:
+				serviceEvents.unsubscribe(ch)
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					dropped = true
+					break
+				}
+				handle(ev.ServiceEvent, ev.object)
+			}
+		}
+		resync()
+	}
+}
+
+// serviceEventHandler publishes the changes of the workloads of the given kind in the cluster
+// while the cache is installed. Updates are only published when the name, group, running pod
+// count or labels changed.
+func serviceEventHandler(wc *WorkloadCache, c *cluster, kind workloadKind) cache.ResourceEventHandler {
+	wi := workloadInformer{cluster: c, kind: kind}
+	publish := func(ev models.ServiceEvent, obj interface{}) {
+		// a cache syncing next to the installed one is not served yet
+		if wc.isInstalled() {
+			serviceEvents.publish(ev, obj)
+		}
+	}
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// nobody is watching before the cache synced
+			if isInInitialList {
+				return
+			}
+			if svc, ok := wi.service(obj); ok {
+				publish(models.ServiceEvent{Type: models.EventAdded, Service: svc}, obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSvc, oldOk := wi.service(oldObj)
+			newSvc, newOk := wi.service(newObj)
+			switch {
+			case oldOk && newOk && (oldSvc != newSvc || !labels.Equals(objectLabels(oldObj), objectLabels(newObj))):
+				publish(models.ServiceEvent{Type: models.EventModified, Service: newSvc}, newObj)
+			case oldOk && !newOk:
+				publish(models.ServiceEvent{Type: models.EventDeleted, Service: oldSvc}, oldObj)
+			case !oldOk && newOk:
+				publish(models.ServiceEvent{Type: models.EventAdded, Service: newSvc}, newObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if svc, ok := wi.service(obj); ok {
+				publish(models.ServiceEvent{Type: models.EventDeleted, Service: svc}, obj)
+			}
+		},
+	}
+}
+
+// matches reports whether the service, whose workload has the given labels, is selected by the filter.
+func (f ServiceFilter) matches(svc models.Service, objLabels labels.Set) bool {
+	return (f.Cluster == "" || f.Cluster == svc.Cluster) &&
+		(f.Namespace == "" || f.Namespace == svc.Namespace) &&
+		(f.ApplicationGroup == "" || f.ApplicationGroup == svc.ApplicationGroup) &&
+		(f.Kind == "" || strings.EqualFold(f.Kind, svc.Kind)) &&
+		f.allowed(svc) &&
+		(f.Selector == nil || f.Selector.Matches(objLabels))
+}
+
+// allowed reports whether the caller is authorized for the namespace of the service in its cluster.
+func (f ServiceFilter) allowed(svc models.Service) bool {
+	if f.AllowedNamespaces == nil {
+		return true
+	}
+	namespaces, ok := f.AllowedNamespaces[svc.Cluster]
+	return ok && (namespaces == nil || namespaces.Has(svc.Namespace))
+}
+
+// WatchServices handler streams service changes to the client as Server-Sent Events.
+// It starts with a SNAPSHOT event holding the current services, followed by ADDED,
+// MODIFIED and DELETED events. Clients reconnecting with a Last-Event-ID header
+// get the missed events replayed instead of a snapshot while they are still buffered
+// by the same process.
+// The optional applicationGroup, cluster, namespace and kind query parameters filter the stream.
+func WatchServices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log.Infof("Incomming request %s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorResponse(w, r, http.StatusInternalServerError, models.Error{Code: CodeInternal, Message: "streaming is not supported"})
+		return
+	}
+	filter, err := serviceFilter(r, r.URL.Query().Get(appGroup))
+	if err != nil {
+		writeError(w, r, "failed to watch services", err)
+		return
+	}
+	hub := serviceEvents
+	lastEventID := r.Header.Get("Last-Event-ID")
+	ch, replay, replayed, snapshotID := hub.subscribe(lastEventID)
+	defer hub.unsubscribe(ch)
+
+	var snapshot []models.Service
+	if !replayed {
+		// the snapshot is read after subscribing, so no change is missed in between
+		var warnings []string
+		snapshot, warnings, err = ListServices(filter)
+		writeWarnings(w, warnings)
+		if err != nil {
+			writeError(w, r, "failed to list services", err)
+			return
+		}
+	}
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if replayed {
+		log.Debugf("resuming service watch after event %s, replaying %d events", lastEventID, len(replay))
+		for _, ev := range replay {
+			if filter.matches(ev.Service, ev.labels) {
+				writeEvent(w, ev.eventID(), ev.Type, versionedEvent(r, ev.ServiceEvent))
+			}
+		}
+	} else {
+		writeEvent(w, snapshotID, "SNAPSHOT", versionedServices(r, snapshot))
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Debugf("service watcher %s disconnected", r.RemoteAddr)
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case ev, ok := <-ch:
+			if !ok {
+				// dropped for being too slow or by a reinitialized cache, the client
+				// resumes with Last-Event-ID
+				return
+			}
+			if filter.matches(ev.Service, ev.labels) {
+				writeEvent(w, ev.eventID(), ev.Type, versionedEvent(r, ev.ServiceEvent))
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with the JSON encoded data.
+func writeEvent(w http.ResponseWriter, id, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Errorf("error marshaling %s event %v", event, err)
+		return
+	}
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, payload); err != nil {
+		log.Errorf("failed to write event %v", err)
+	}
+}
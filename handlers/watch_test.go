@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEventHubSubscribe(t *testing.T) {
+	hub := newEventHub(2)
+	for i := 0; i < 3; i++ {
+		hub.publish(models.ServiceEvent{Type: models.EventModified, Service: models.Service{Name: testServiceName, RunningPodsCount: i}}, nil)
+	}
+
+	tests := []struct {
+		name         string
+		lastEventID  string
+		wantReplayed bool
+		wantIDs      []uint64
+	}{
+		{name: "no last event id", wantReplayed: false},
+		{name: "resume within buffer", lastEventID: formatEventID(hub.epoch, 1), wantReplayed: true, wantIDs: []uint64{2, 3}},
+		{name: "resume up to date", lastEventID: formatEventID(hub.epoch, 3), wantReplayed: true},
+		{name: "resume from future id", lastEventID: formatEventID(hub.epoch, 10), wantReplayed: false},
+		{name: "resume from another epoch", lastEventID: formatEventID("other", 1), wantReplayed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, replay, replayed, snapshotID := hub.subscribe(tt.lastEventID)
+			defer hub.unsubscribe(ch)
+
+			if want := formatEventID(hub.epoch, 3); snapshotID != want {
+				t.Errorf("subscribe() snapshotID = %v, want %v", snapshotID, want)
+			}
+			if replayed != tt.wantReplayed {
+				t.Errorf("subscribe() replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			var gotIDs []uint64
+			for _, ev := range replay {
+				gotIDs = append(gotIDs, ev.id)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("subscribe() replay ids = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+
+	t.Run("resume outside buffer", func(t *testing.T) {
+		// trimmed once twice the buffer size is reached
+		for i := 0; i < 2; i++ {
+			hub.publish(models.ServiceEvent{Type: models.EventModified}, nil)
+		}
+		ch, _, replayed, _ := hub.subscribe(formatEventID(hub.epoch, 1))
+		defer hub.unsubscribe(ch)
+		if replayed {
+			t.Errorf("subscribe() replayed events which are no longer buffered")
+		}
+	})
+
+	t.Run("resume after reset", func(t *testing.T) {
+		lastEventID := formatEventID(hub.epoch, 4)
+		ch, _, _, _ := hub.subscribe("")
+		hub.reset()
+		if _, ok := <-ch; ok {
+			t.Errorf("reset() kept the subscriber")
+		}
+		hub.publish(models.ServiceEvent{Type: models.EventModified}, nil)
+		hub.publish(models.ServiceEvent{Type: models.EventModified}, nil)
+		resumed, _, replayed, _ := hub.subscribe(lastEventID)
+		defer hub.unsubscribe(resumed)
+		if replayed {
+			t.Errorf("subscribe() replayed events of the epoch before the reset")
+		}
+	})
+}
+
+func TestEventHubParseEventID(t *testing.T) {
+	hub := newEventHub(eventBufferSize)
+	restarted := newEventHub(eventBufferSize)
+	tests := []struct {
+		name    string
+		id      string
+		wantSeq uint64
+		wantOk  bool
+	}{
+		{name: "id of the hub", id: formatEventID(hub.epoch, 42), wantSeq: 42, wantOk: true},
+		{name: "id of another process", id: formatEventID(restarted.epoch, 42)},
+		{name: "id without epoch", id: "42"},
+		{name: "malformed sequence", id: hub.epoch + "-x"},
+		{name: "empty id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, ok := hub.parseEventID(tt.id)
+			if seq != tt.wantSeq || ok != tt.wantOk {
+				t.Errorf("parseEventID(%q) = %v, %v, want %v, %v", tt.id, seq, ok, tt.wantSeq, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestEventHubDropsSlowSubscriber(t *testing.T) {
+	hub := newEventHub(eventBufferSize)
+	ch, _, _, _ := hub.subscribe("")
+	for i := 0; i <= subscriberBufferSize; i++ {
+		hub.publish(models.ServiceEvent{Type: models.EventAdded}, nil)
+	}
+	for i := 0; i < subscriberBufferSize; i++ {
+		<-ch
+	}
+	if _, ok := <-ch; ok {
+		t.Errorf("slow subscriber was not dropped")
+	}
+	// unsubscribing a dropped subscriber must not panic
+	hub.unsubscribe(ch)
+}
+
+func TestServiceEventHandler(t *testing.T) {
+	serviceEvents = newEventHub(eventBufferSize)
+	wc := &WorkloadCache{groupKeys: []groupKey{{name: appGroup}}}
+	InstallWorkloadCache(wc)
+	t.Cleanup(resetCache)
+	ch, _, _, _ := serviceEvents.subscribe("")
+	defer serviceEvents.unsubscribe(ch)
+
+	scaled := fakeDeploymentSpec.DeepCopy()
+	scaled.Status.ReadyReplicas = 3
+	relabelled := fakeDeploymentSpec.DeepCopy()
+	relabelled.Annotations = map[string]string{"unrelated": "change"}
+
+	handler := serviceEventHandler(wc, &cluster{}, deploymentKind{})
+	handler.OnAdd(fakeDeploymentSpec, true)
+	handler.OnAdd(fakeDeploymentSpec, false)
+	handler.OnUpdate(fakeDeploymentSpec, relabelled)
+	handler.OnUpdate(fakeDeploymentSpec, scaled)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/" + testServiceName, Obj: scaled})
+	// a cache which is not installed yet does not publish
+	serviceEventHandler(&WorkloadCache{}, &cluster{}, deploymentKind{}).OnAdd(fakeDeploymentSpec, false)
+
+	want := []string{models.EventAdded, models.EventModified, models.EventDeleted}
+	for _, wantType := range want {
+		select {
+		case ev := <-ch:
+			if ev.Type != wantType {
+				t.Errorf("event type = %v, want %v", ev.Type, wantType)
+			}
+		default:
+			t.Fatalf("missing %s event", wantType)
+		}
+	}
+	select {
+	case ev := <-ch:
+		t.Errorf("unexpected event %+v", ev)
+	default:
+	}
+}
+
+// sseEvent is a single event read from a Server-Sent Events stream.
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvent reads the next event from the stream, skipping heartbeats.
+func readEvent(t *testing.T, scanner *bufio.Scanner) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" && ev.event != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("stream ended before next event: %v", scanner.Err())
+	return ev
+}
+
+func TestWatchServices(t *testing.T) {
+	serviceEvents = newEventHub(eventBufferSize)
+	otherGroupDeployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "another-service", Namespace: testNamespace, Labels: map[string]string{appGroup: "beta"}},
+	}
+	startFakeCache(t, fakeDeploymentSpec, otherGroupDeployment)
+
+	router := httprouter.New()
+	router.GET("/services/:applicationGroup", GetServicesByAppLabel)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	watch := func(ctx context.Context, lastEventID string) *bufio.Scanner {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/services/watch?applicationGroup="+testAppGrp, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to watch services: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+			t.Fatalf("content-type = %v, want text/event-stream", ct)
+		}
+		return bufio.NewScanner(resp.Body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream := watch(ctx, "")
+
+	// initial snapshot only holds the services of the group
+	snapshot := readEvent(t, stream)
+	var services []models.Service
+	if err := json.Unmarshal([]byte(snapshot.data), &services); err != nil {
+		t.Fatalf("failed to unmarshal snapshot %v", err)
+	}
+	if snapshot.event != "SNAPSHOT" || len(services) != 1 || services[0].Name != testServiceName {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// scaling a deployment of another group is filtered, then scale the watched one
+	for _, deploy := range []*appv1.Deployment{otherGroupDeployment, fakeDeploymentSpec} {
+		scaled := deploy.DeepCopy()
+		scaled.Status.ReadyReplicas = 5
+		if _, err := kubeClient.AppsV1().Deployments(testNamespace).UpdateStatus(ctx, scaled, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to scale deployment: %v", err)
+		}
+	}
+	modified := readEvent(t, stream)
+	var event models.ServiceEvent
+	if err := json.Unmarshal([]byte(modified.data), &event); err != nil {
+		t.Fatalf("failed to unmarshal event %v", err)
+	}
+	if modified.event != models.EventModified || event.Service.Name != testServiceName || event.Service.RunningPodsCount != 5 {
+		t.Fatalf("unexpected event %+v", modified)
+	}
+
+	// resuming after the snapshot replays the missed change
+	resumed := readEvent(t, watch(ctx, snapshot.id))
+	if resumed != modified {
+		t.Errorf("resumed event = %+v, want %+v", resumed, modified)
+	}
+
+	// an id numbered by another process gets a snapshot instead of a replay
+	_, seq, _ := strings.Cut(snapshot.id, "-")
+	if restarted := readEvent(t, watch(ctx, "other-"+seq)); restarted.event != "SNAPSHOT" {
+		t.Errorf("resumed event of another process = %+v, want a SNAPSHOT", restarted)
+	}
+}
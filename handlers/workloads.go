@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// workloadKind describes how objects of one kind of workload are watched
+// and turned into the services exposed by the api.
+type workloadKind interface {
+	// Kind returns the name of the kind reported to clients, e.g. Deployment.
+	Kind() string
+	// Informer returns the informer watching objects of the kind from the given factories.
+	Informer(factories *namespaceFactories) cache.SharedIndexInformer
+	// Service converts a cached object into a service, it returns false
+	// when the object should not be reported.
+	Service(obj interface{}) (models.Service, bool)
+}
+
+// podSelectorKind is implemented by workload kinds whose pods
+// can be found through the label selector of the workload.
+type podSelectorKind interface {
+	// PodSelector returns the label selector of the pods of a cached object.
+	PodSelector(obj interface{}) (*metav1.LabelSelector, bool)
+}
+
+// namespaceFactories holds the shared informer factories of one watched namespace.
+type namespaceFactories struct {
+	typed   informers.SharedInformerFactory
+	dynamic dynamicinformer.DynamicSharedInformerFactory
+}
+
+// builtinKinds lists the built-in workload kinds, keyed on the lower-cased kind name.
+var builtinKinds = map[string]workloadKind{
+	"deployment":  deploymentKind{},
+	"statefulset": statefulSetKind{},
+	"daemonset":   daemonSetKind{},
+	"job":         jobKind{},
+	"cronjob":     cronJobKind{},
+}
+
+// BuiltinKinds returns the names of the built-in workload kinds.
+func BuiltinKinds() []string {
+	return []string{"Deployment", "StatefulSet", "DaemonSet", "Job", "CronJob"}
+}
+
+// lookupKind returns the built-in workload kind for the given name, names are case-insensitive.
+func lookupKind(name string) (workloadKind, error) {
+	kind, ok := builtinKinds[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownKind, name)
+	}
+	return kind, nil
+}
+
+// newService fills the fields of a service which are common to all workload kinds.
+func newService(kind string, obj metav1.Object, replicas models.ReplicaStatus, observedGeneration int64, health string) models.Service {
+	return models.Service{
+		Namespace:          obj.GetNamespace(),
+		Kind:               kind,
+		Name:               obj.GetName(),
+		ApplicationGroup:   applicationGroupOf(obj),
+		RunningPodsCount:   int(replicas.Ready),
+		Replicas:           replicas,
+		ObservedGeneration: observedGeneration,
+		Health:             health,
+	}
+}
+
+// replicaHealth computes the health of a workload which runs a number of replicas.
+// A workload without ready replicas is down once its controller has observed it,
+// even while it rolls out a change.
+func replicaHealth(generation, observedGeneration int64, replicas models.ReplicaStatus) string {
+	switch {
+	case replicas.Desired > 0 && replicas.Ready == 0 && observedGeneration > 0:
+		return models.HealthDown
+	case observedGeneration < generation || replicas.Updated < replicas.Desired:
+		return models.HealthProgressing
+	case replicas.Ready < replicas.Desired:
+		return models.HealthDegraded
+	default:
+		return models.HealthHealthy
+	}
+}
+
+// newReplicaService reports a workload which runs a number of replicas.
+func newReplicaService(kind string, obj metav1.Object, replicas models.ReplicaStatus, observedGeneration int64) models.Service {
+	return newService(kind, obj, replicas, observedGeneration, replicaHealth(obj.GetGeneration(), observedGeneration, replicas))
+}
+
+// deploymentKind reports deployments, healthy pods are the ready replicas.
+type deploymentKind struct{}
+
+func (deploymentKind) Kind() string { return "Deployment" }
+
+func (deploymentKind) Informer(factories *namespaceFactories) cache.SharedIndexInformer {
+	return factories.typed.Apps().V1().Deployments().Informer()
+}
+
+func (k deploymentKind) Service(obj interface{}) (models.Service, bool) {
+	deploy, ok := obj.(*appv1.Deployment)
+	if !ok {
+		return models.Service{}, false
+	}
+	return newReplicaService(k.Kind(), deploy, models.ReplicaStatus{
+		Desired:     replicasOrDefault(deploy.Spec.Replicas),
+		Ready:       deploy.Status.ReadyReplicas,
+		Updated:     deploy.Status.UpdatedReplicas,
+		Available:   deploy.Status.AvailableReplicas,
+		Unavailable: deploy.Status.UnavailableReplicas,
+	}, deploy.Status.ObservedGeneration), true
+}
+
+func (deploymentKind) PodSelector(obj interface{}) (*metav1.LabelSelector, bool) {
+	deploy, ok := obj.(*appv1.Deployment)
+	if !ok {
+		return nil, false
+	}
+	return deploy.Spec.Selector, deploy.Spec.Selector != nil
+}
+
+// statefulSetKind reports statefulsets, healthy pods are the ready replicas.
+type statefulSetKind struct{}
+
+func (statefulSetKind) Kind() string { return "StatefulSet" }
+
+func (statefulSetKind) Informer(factories *namespaceFactories) cache.SharedIndexInformer {
+	return factories.typed.Apps().V1().StatefulSets().Informer()
+}
+
+func (k statefulSetKind) Service(obj interface{}) (models.Service, bool) {
+	sts, ok := obj.(*appv1.StatefulSet)
+	if !ok {
+		return models.Service{}, false
+	}
+	desired := replicasOrDefault(sts.Spec.Replicas)
+	return newReplicaService(k.Kind(), sts, models.ReplicaStatus{
+		Desired:     desired,
+		Ready:       sts.Status.ReadyReplicas,
+		Updated:     sts.Status.UpdatedReplicas,
+		Available:   sts.Status.AvailableReplicas,
+		Unavailable: unavailable(desired, sts.Status.AvailableReplicas),
+	}, sts.Status.ObservedGeneration), true
+}
+
+func (statefulSetKind) PodSelector(obj interface{}) (*metav1.LabelSelector, bool) {
+	sts, ok := obj.(*appv1.StatefulSet)
+	if !ok {
+		return nil, false
+	}
+	return sts.Spec.Selector, sts.Spec.Selector != nil
+}
+
+// daemonSetKind reports daemonsets, healthy pods are the daemon pods which are ready.
+type daemonSetKind struct{}
+
+func (daemonSetKind) Kind() string { return "DaemonSet" }
+
+func (daemonSetKind) Informer(factories *namespaceFactories) cache.SharedIndexInformer {
+	return factories.typed.Apps().V1().DaemonSets().Informer()
+}
+
+func (k daemonSetKind) Service(obj interface{}) (models.Service, bool) {
+	ds, ok := obj.(*appv1.DaemonSet)
+	if !ok {
+		return models.Service{}, false
+	}
+	return newReplicaService(k.Kind(), ds, models.ReplicaStatus{
+		Desired:     ds.Status.DesiredNumberScheduled,
+		Ready:       ds.Status.NumberReady,
+		Updated:     ds.Status.UpdatedNumberScheduled,
+		Available:   ds.Status.NumberAvailable,
+		Unavailable: ds.Status.NumberUnavailable,
+	}, ds.Status.ObservedGeneration), true
+}
+
+func (daemonSetKind) PodSelector(obj interface{}) (*metav1.LabelSelector, bool) {
+	ds, ok := obj.(*appv1.DaemonSet)
+	if !ok {
+		return nil, false
+	}
+	return ds.Spec.Selector, ds.Spec.Selector != nil
+}
+
+// jobKind reports jobs which are not owned by a cronjob, those are reported through
+// their cronjob. Healthy pods are the succeeded pods once the job completed and the
+// ready pods while it is running. Jobs do not roll out changes, all their replicas
+// count as updated.
+type jobKind struct{}
+
+func (jobKind) Kind() string { return "Job" }
+
+func (jobKind) Informer(factories *namespaceFactories) cache.SharedIndexInformer {
+	return factories.typed.Batch().V1().Jobs().Informer()
+}
+
+func (k jobKind) Service(obj interface{}) (models.Service, bool) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return models.Service{}, false
+	}
+	for _, owner := range job.GetOwnerReferences() {
+		if owner.Kind == "CronJob" {
+			return models.Service{}, false
+		}
+	}
+	healthy := jobHealthyPods(job)
+	desired := replicasOrDefault(job.Spec.Completions)
+	return newService(k.Kind(), job, models.ReplicaStatus{
+		Desired:     desired,
+		Ready:       healthy,
+		Updated:     desired,
+		Available:   healthy,
+		Unavailable: unavailable(desired, healthy),
+	}, 0, jobHealth(job)), true
+}
+
+func (jobKind) PodSelector(obj interface{}) (*metav1.LabelSelector, bool) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return nil, false
+	}
+	return job.Spec.Selector, job.Spec.Selector != nil
+}
+
+// jobHealth reports completed jobs as healthy, failed jobs as down
+// and jobs which are still running as progressing.
+func jobHealth(job *batchv1.Job) string {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return models.HealthHealthy
+		case batchv1.JobFailed:
+			return models.HealthDown
+		}
+	}
+	return models.HealthProgressing
+}
+
+func jobHealthyPods(job *batchv1.Job) int32 {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobComplete && cond.Status == corev1.ConditionTrue {
+			return job.Status.Succeeded
+		}
+	}
+	if job.Status.Ready != nil {
+		return *job.Status.Ready
+	}
+	return job.Status.Active
+}
+
+// cronJobKind reports cronjobs, a cronjob has one healthy pod when its last run succeeded.
+type cronJobKind struct{}
+
+func (cronJobKind) Kind() string { return "CronJob" }
+
+func (cronJobKind) Informer(factories *namespaceFactories) cache.SharedIndexInformer {
+	return factories.typed.Batch().V1().CronJobs().Informer()
+}
+
+func (k cronJobKind) Service(obj interface{}) (models.Service, bool) {
+	cj, ok := obj.(*batchv1.CronJob)
+	if !ok {
+		return models.Service{}, false
+	}
+	healthy := cronJobHealthyPods(cj)
+	return newService(k.Kind(), cj, models.ReplicaStatus{
+		Desired:     1,
+		Ready:       healthy,
+		Updated:     1,
+		Available:   healthy,
+		Unavailable: 1 - healthy,
+	}, 0, cronJobHealth(cj, healthy)), true
+}
+
+// cronJobHealth reports cronjobs whose last run succeeded as healthy, also while the
+// next run is active, and cronjobs which did not succeed yet as progressing while
+// their first run is pending or active.
+func cronJobHealth(cj *batchv1.CronJob, healthy int32) string {
+	switch {
+	case healthy > 0:
+		return models.HealthHealthy
+	case cj.Status.LastSuccessfulTime == nil && (cj.Status.LastScheduleTime == nil || len(cj.Status.Active) > 0):
+		return models.HealthProgressing
+	default:
+		return models.HealthDown
+	}
+}
+
+func cronJobHealthyPods(cj *batchv1.CronJob) int32 {
+	lastSuccess := cj.Status.LastSuccessfulTime
+	if lastSuccess == nil {
+		return 0
+	}
+	// a run in progress keeps the result of the runs before it, as the status does not
+	// tell whether the run before the active one failed the last success counts. Once
+	// a run scheduled after the last success finished without succeeding it is down.
+	if len(cj.Status.Active) > 0 || cj.Status.LastScheduleTime == nil || !lastSuccess.Before(cj.Status.LastScheduleTime) {
+		return 1
+	}
+	return 0
+}
+
+// replicasOrDefault returns the replicas of a spec, the api server defaults unset replicas to one.
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// unavailable returns the number of desired replicas which are not available.
+func unavailable(desired, available int32) int32 {
+	if available >= desired {
+		return 0
+	}
+	return desired - available
+}
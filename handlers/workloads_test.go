@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWorkloadKindService(t *testing.T) {
+	ready := int32(2)
+	earlier := metav1.NewTime(time.Now().Add(-time.Hour))
+	later := metav1.NewTime(time.Now())
+
+	tests := []struct {
+		name     string
+		kind     workloadKind
+		obj      interface{}
+		wantPods int
+		wantOk   bool
+	}{
+		{
+			name:     "deployment counts ready replicas",
+			kind:     deploymentKind{},
+			obj:      &appv1.Deployment{Status: appv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 2}},
+			wantPods: 2,
+			wantOk:   true,
+		},
+		{
+			name:     "statefulset counts ready replicas",
+			kind:     statefulSetKind{},
+			obj:      &appv1.StatefulSet{Status: appv1.StatefulSetStatus{Replicas: 3, ReadyReplicas: 1}},
+			wantPods: 1,
+			wantOk:   true,
+		},
+		{
+			name:     "daemonset counts ready daemon pods",
+			kind:     daemonSetKind{},
+			obj:      &appv1.DaemonSet{Status: appv1.DaemonSetStatus{DesiredNumberScheduled: 5, NumberReady: 4}},
+			wantPods: 4,
+			wantOk:   true,
+		},
+		{
+			name:     "running job counts ready pods",
+			kind:     jobKind{},
+			obj:      &batchv1.Job{Status: batchv1.JobStatus{Active: 3, Ready: &ready}},
+			wantPods: 2,
+			wantOk:   true,
+		},
+		{
+			name: "completed job counts succeeded pods",
+			kind: jobKind{},
+			obj: &batchv1.Job{Status: batchv1.JobStatus{
+				Succeeded:  1,
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			}},
+			wantPods: 1,
+			wantOk:   true,
+		},
+		{
+			name: "job owned by cronjob is not reported",
+			kind: jobKind{},
+			obj: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "backup"}},
+			}},
+			wantOk: false,
+		},
+		{
+			name:     "cronjob whose last run succeeded",
+			kind:     cronJobKind{},
+			obj:      &batchv1.CronJob{Status: batchv1.CronJobStatus{LastScheduleTime: &earlier, LastSuccessfulTime: &later}},
+			wantPods: 1,
+			wantOk:   true,
+		},
+		{
+			name:     "cronjob whose last run failed",
+			kind:     cronJobKind{},
+			obj:      &batchv1.CronJob{Status: batchv1.CronJobStatus{LastScheduleTime: &later, LastSuccessfulTime: &earlier}},
+			wantPods: 0,
+			wantOk:   true,
+		},
+		{
+			name: "cronjob running again after a successful run",
+			kind: cronJobKind{},
+			obj: &batchv1.CronJob{Status: batchv1.CronJobStatus{
+				Active:             []corev1.ObjectReference{{Name: "backup-2"}},
+				LastScheduleTime:   &later,
+				LastSuccessfulTime: &earlier,
+			}},
+			wantPods: 1,
+			wantOk:   true,
+		},
+		{
+			name: "cronjob running again after failed runs",
+			kind: cronJobKind{},
+			obj: &batchv1.CronJob{Status: batchv1.CronJobStatus{
+				Active:           []corev1.ObjectReference{{Name: "backup-2"}},
+				LastScheduleTime: &later,
+			}},
+			wantPods: 0,
+			wantOk:   true,
+		},
+		{
+			name:     "cronjob which never succeeded",
+			kind:     cronJobKind{},
+			obj:      &batchv1.CronJob{},
+			wantPods: 0,
+			wantOk:   true,
+		},
+		{
+			name:   "unexpected object",
+			kind:   deploymentKind{},
+			obj:    &appv1.StatefulSet{},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.kind.Service(tt.obj)
+			if ok != tt.wantOk {
+				t.Errorf("Service() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if !ok {
+				return
+			}
+			if got.Kind != tt.kind.Kind() {
+				t.Errorf("Service() kind = %v, want %v", got.Kind, tt.kind.Kind())
+			}
+			if got.RunningPodsCount != tt.wantPods {
+				t.Errorf("Service() running pods = %v, want %v", got.RunningPodsCount, tt.wantPods)
+			}
+		})
+	}
+}
+
+func TestWorkloadKindReplicaStatus(t *testing.T) {
+	five := int32(5)
+	later := metav1.NewTime(time.Now())
+	earlier := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name         string
+		kind         workloadKind
+		obj          interface{}
+		wantReplicas models.ReplicaStatus
+		wantHealth   string
+	}{
+		{
+			name: "healthy deployment",
+			kind: deploymentKind{},
+			obj: &appv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Spec:       appv1.DeploymentSpec{Replicas: &five},
+				Status:     appv1.DeploymentStatus{ObservedGeneration: 2, ReadyReplicas: 5, UpdatedReplicas: 5, AvailableReplicas: 5},
+			},
+			wantReplicas: models.ReplicaStatus{Desired: 5, Ready: 5, Updated: 5, Available: 5},
+			wantHealth:   models.HealthHealthy,
+		},
+		{
+			name: "degraded deployment",
+			kind: deploymentKind{},
+			obj: &appv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Spec:       appv1.DeploymentSpec{Replicas: &five},
+				Status:     appv1.DeploymentStatus{ObservedGeneration: 2, ReadyReplicas: 3, UpdatedReplicas: 5, AvailableReplicas: 3, UnavailableReplicas: 2},
+			},
+			wantReplicas: models.ReplicaStatus{Desired: 5, Ready: 3, Updated: 5, Available: 3, Unavailable: 2},
+			wantHealth:   models.HealthDegraded,
+		},
+		{
+			name: "deployment rolling out",
+			kind: deploymentKind{},
+			obj: &appv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 3},
+				Spec:       appv1.DeploymentSpec{Replicas: &five},
+				Status:     appv1.DeploymentStatus{ObservedGeneration: 3, ReadyReplicas: 5, UpdatedReplicas: 2, AvailableReplicas: 5},
+			},
+			wantReplicas: models.ReplicaStatus{Desired: 5, Ready: 5, Updated: 2, Available: 5},
+			wantHealth:   models.HealthProgressing,
+		},
+		{
+			name: "deployment down while rolling out",
+			kind: deploymentKind{},
+			obj: &appv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 3},
+				Status:     appv1.DeploymentStatus{ObservedGeneration: 2, UnavailableReplicas: 1},
+			},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Unavailable: 1},
+			wantHealth:   models.HealthDown,
+		},
+		{
+			name:         "deployment scaled to zero",
+			kind:         deploymentKind{},
+			obj:          &appv1.Deployment{Spec: appv1.DeploymentSpec{Replicas: new(int32)}, Status: appv1.DeploymentStatus{ObservedGeneration: 1}},
+			wantReplicas: models.ReplicaStatus{},
+			wantHealth:   models.HealthHealthy,
+		},
+		{
+			name: "statefulset computes unavailable replicas",
+			kind: statefulSetKind{},
+			obj: &appv1.StatefulSet{
+				Spec:   appv1.StatefulSetSpec{Replicas: &five},
+				Status: appv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 4, UpdatedReplicas: 5, AvailableReplicas: 4},
+			},
+			wantReplicas: models.ReplicaStatus{Desired: 5, Ready: 4, Updated: 5, Available: 4, Unavailable: 1},
+			wantHealth:   models.HealthDegraded,
+		},
+		{
+			name: "daemonset scheduled nodes",
+			kind: daemonSetKind{},
+			obj: &appv1.DaemonSet{Status: appv1.DaemonSetStatus{
+				ObservedGeneration: 1, DesiredNumberScheduled: 4, NumberReady: 4, UpdatedNumberScheduled: 4, NumberAvailable: 4,
+			}},
+			wantReplicas: models.ReplicaStatus{Desired: 4, Ready: 4, Updated: 4, Available: 4},
+			wantHealth:   models.HealthHealthy,
+		},
+		{
+			name: "failed job",
+			kind: jobKind{},
+			obj: &batchv1.Job{
+				Spec: batchv1.JobSpec{Completions: &five},
+				Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+				}},
+			},
+			wantReplicas: models.ReplicaStatus{Desired: 5, Updated: 5, Unavailable: 5},
+			wantHealth:   models.HealthDown,
+		},
+		{
+			name:         "running job",
+			kind:         jobKind{},
+			obj:          &batchv1.Job{Status: batchv1.JobStatus{Active: 1}},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Ready: 1, Updated: 1, Available: 1},
+			wantHealth:   models.HealthProgressing,
+		},
+		{
+			name:         "cronjob which never ran",
+			kind:         cronJobKind{},
+			obj:          &batchv1.CronJob{},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Updated: 1, Unavailable: 1},
+			wantHealth:   models.HealthProgressing,
+		},
+		{
+			name: "cronjob running again after a successful run",
+			kind: cronJobKind{},
+			obj: &batchv1.CronJob{Status: batchv1.CronJobStatus{
+				Active:             []corev1.ObjectReference{{Name: "backup-2"}},
+				LastScheduleTime:   &later,
+				LastSuccessfulTime: &earlier,
+			}},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Ready: 1, Updated: 1, Available: 1},
+			wantHealth:   models.HealthHealthy,
+		},
+		{
+			name: "cronjob running again after failed runs",
+			kind: cronJobKind{},
+			obj: &batchv1.CronJob{Status: batchv1.CronJobStatus{
+				Active:           []corev1.ObjectReference{{Name: "backup-2"}},
+				LastScheduleTime: &later,
+			}},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Updated: 1, Unavailable: 1},
+			wantHealth:   models.HealthProgressing,
+		},
+		{
+			name:         "cronjob whose run after the last success failed",
+			kind:         cronJobKind{},
+			obj:          &batchv1.CronJob{Status: batchv1.CronJobStatus{LastScheduleTime: &later, LastSuccessfulTime: &earlier}},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Updated: 1, Unavailable: 1},
+			wantHealth:   models.HealthDown,
+		},
+		{
+			name:         "cronjob whose runs failed",
+			kind:         cronJobKind{},
+			obj:          &batchv1.CronJob{Status: batchv1.CronJobStatus{LastScheduleTime: &earlier}},
+			wantReplicas: models.ReplicaStatus{Desired: 1, Updated: 1, Unavailable: 1},
+			wantHealth:   models.HealthDown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.kind.Service(tt.obj)
+			if !ok {
+				t.Fatalf("Service() ok = false")
+			}
+			if got.Replicas != tt.wantReplicas {
+				t.Errorf("Service() replicas = %+v, want %+v", got.Replicas, tt.wantReplicas)
+			}
+			if got.Health != tt.wantHealth {
+				t.Errorf("Service() health = %v, want %v", got.Health, tt.wantHealth)
+			}
+			if got.RunningPodsCount != int(got.Replicas.Ready) {
+				t.Errorf("Service() running pods = %v, want ready replicas %v", got.RunningPodsCount, got.Replicas.Ready)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package health implements liveness and readiness endpoints in the style of
+// the kube-apiserver /livez and /readyz endpoints, every endpoint runs a set of
+// named checks which can be queried individually.
+package health
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Checker is a named health check.
+type Checker interface {
+	// Name returns the name of the check, it is used as path of the individual check endpoint.
+	Name() string
+	// Check returns an error when the check fails.
+	Check(r *http.Request) error
+}
+
+type namedCheck struct {
+	name  string
+	check func(r *http.Request) error
+}
+
+func (c *namedCheck) Name() string                { return c.name }
+func (c *namedCheck) Check(r *http.Request) error { return c.check(r) }
+
+// NamedCheck returns a health check with the given name backed by the check function.
+func NamedCheck(name string, check func(r *http.Request) error) Checker {
+	return &namedCheck{name: name, check: check}
+}
+
+// PingCheck always succeeds, it reports whether the health server answers at all.
+var PingCheck = NamedCheck("ping", func(_ *http.Request) error { return nil })
+
+// Flag is a health check which fails until it is set, e.g. while the
+// server is not yet, or no longer, accepting requests.
+type Flag struct {
+	name   string
+	reason string
+	set    atomic.Bool
+}
+
+// NewFlag returns an unset flag check, reason is reported while it is unset.
+func NewFlag(name, reason string) *Flag {
+	return &Flag{name: name, reason: reason}
+}
+
+// Set marks the flag as healthy or unhealthy.
+func (f *Flag) Set(healthy bool) { f.set.Store(healthy) }
+
+func (f *Flag) Name() string { return f.name }
+
+func (f *Flag) Check(_ *http.Request) error {
+	if !f.set.Load() {
+		return errors.New(f.reason)
+	}
+	return nil
+}
+
+// Registry holds the checks of one health endpoint, checks can be
+// added after the endpoint has been installed.
+type Registry struct {
+	name   string
+	mu     sync.RWMutex
+	checks []Checker
+}
+
+// NewRegistry returns an empty registry for the endpoint with the given name, e.g. readyz.
+func NewRegistry(name string) *Registry {
+	return &Registry{name: name}
+}
+
+// Add registers the checks, a check replaces an earlier one with the same name.
+func (reg *Registry) Add(checks ...Checker) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, check := range checks {
+		replaced := false
+		for i, existing := range reg.checks {
+			if existing.Name() == check.Name() {
+				reg.checks[i], replaced = check, true
+			}
+		}
+		if !replaced {
+			reg.checks = append(reg.checks, check)
+		}
+	}
+}
+
+// Checks returns the registered checks in registration order.
+func (reg *Registry) Checks() []Checker {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return append([]Checker(nil), reg.checks...)
+}
+
+// InstallHandler serves the registry at /<name> and every check at /<name>/<check>.
+func InstallHandler(mux *http.ServeMux, reg *Registry) {
+	InstallPathHandler(mux, "/"+reg.name, reg)
+}
+
+// InstallPathHandler serves the registry at the given path, e.g. to keep
+// serving a deprecated endpoint name.
+func InstallPathHandler(mux *http.ServeMux, path string, reg *Registry) {
+	mux.Handle(path, reg.handler())
+	mux.Handle(path+"/", http.StripPrefix(path+"/", reg.checkHandler()))
+}
+
+// handler runs all checks but the excluded ones. A failed check returns 500 and
+// the list of checks, ?verbose lists the checks of a passing endpoint as well.
+func (reg *Registry) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		excluded := make(map[string]bool)
+		for _, name := range r.URL.Query()["exclude"] {
+			excluded[strings.TrimSpace(name)] = true
+		}
+
+		var (
+			output bytes.Buffer
+			failed []string
+		)
+		for _, check := range reg.Checks() {
+			if excluded[check.Name()] {
+				delete(excluded, check.Name())
+				fmt.Fprintf(&output, "[+]%s excluded: ok\n", check.Name())
+				continue
+			}
+			if err := check.Check(r); err != nil {
+				// the reason is logged only, it may leak details of the cluster
+				log.Errorf("%s check %s failed: %v", reg.name, check.Name(), err)
+				fmt.Fprintf(&output, "[-]%s failed: reason withheld\n", check.Name())
+				failed = append(failed, check.Name())
+				continue
+			}
+			fmt.Fprintf(&output, "[+]%s ok\n", check.Name())
+		}
+		if len(excluded) > 0 {
+			names := make([]string, 0, len(excluded))
+			for name := range excluded {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(&output, "warn: some health checks cannot be excluded: no matches for %s\n", strings.Join(names, ","))
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if len(failed) > 0 {
+			log.Debugf("%s check failed: %s", reg.name, strings.Join(failed, ","))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%s%s check failed\n", output.String(), reg.name)
+			return
+		}
+		if _, verbose := r.URL.Query()["verbose"]; !verbose {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		fmt.Fprintf(w, "%s%s check passed\n", output.String(), reg.name)
+	})
+}
+
+// checkHandler runs the single check named by the request path.
+func (reg *Registry) checkHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range reg.Checks() {
+			if check.Name() != r.URL.Path {
+				continue
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if err := check.Check(r); err != nil {
+				// the reason is logged only, like in the aggregated checks
+				log.Errorf("%s check %s failed: %v", reg.name, check.Name(), err)
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "[-]%s failed: reason withheld\n", check.Name())
+				return
+			}
+			fmt.Fprint(w, "ok")
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestMux(checks ...Checker) *http.ServeMux {
+	reg := NewRegistry("readyz")
+	reg.Add(checks...)
+	mux := http.NewServeMux()
+	InstallHandler(mux, reg)
+	return mux
+}
+
+func TestHandler(t *testing.T) {
+	failing := NamedCheck("apiserver", func(_ *http.Request) error { return errors.New("connection refused") })
+	router := NewFlag("router", "server is not accepting requests")
+	router.Set(true)
+
+	tests := []struct {
+		name     string
+		checks   []Checker
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "passing",
+			checks:   []Checker{PingCheck, router},
+			path:     "/readyz",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "passing verbose",
+			checks:   []Checker{PingCheck, router},
+			path:     "/readyz?verbose",
+			wantCode: http.StatusOK,
+			wantBody: "[+]ping ok\n[+]router ok\nreadyz check passed\n",
+		},
+		{
+			name:     "failing check withholds the reason",
+			checks:   []Checker{PingCheck, failing},
+			path:     "/readyz",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "[+]ping ok\n[-]apiserver failed: reason withheld\nreadyz check failed\n",
+		},
+		{
+			name:     "excluded check",
+			checks:   []Checker{PingCheck, failing},
+			path:     "/readyz?verbose&exclude=apiserver&exclude=etcd",
+			wantCode: http.StatusOK,
+			wantBody: "[+]ping ok\n[+]apiserver excluded: ok\nwarn: some health checks cannot be excluded: no matches for etcd\nreadyz check passed\n",
+		},
+		{
+			name:     "unset flag",
+			checks:   []Checker{PingCheck, NewFlag("router", "server is not accepting requests")},
+			path:     "/readyz",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "[+]ping ok\n[-]router failed: reason withheld\nreadyz check failed\n",
+		},
+		{
+			name:     "individual check",
+			checks:   []Checker{PingCheck, failing},
+			path:     "/readyz/ping",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "individual failing check",
+			checks:   []Checker{PingCheck, failing},
+			path:     "/readyz/apiserver",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "[-]apiserver failed: reason withheld\n",
+		},
+		{
+			name:     "unknown individual check",
+			checks:   []Checker{PingCheck},
+			path:     "/readyz/etcd",
+			wantCode: http.StatusNotFound,
+			wantBody: "404 page not found\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newTestMux(tt.checks...).ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("mismatched status code: want=%v, got=%v", tt.wantCode, w.Code)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("mismatched body:\nwant=%q\ngot=%q", tt.wantBody, got)
+			}
+		})
+	}
+}
+
+func TestRegistryAddReplaces(t *testing.T) {
+	reg := NewRegistry("livez")
+	reg.Add(PingCheck, NewFlag("router", "not ready"))
+	reg.Add(NamedCheck("ping", func(_ *http.Request) error { return errors.New("down") }))
+
+	checks := reg.Checks()
+	if len(checks) != 2 || checks[0].Name() != "ping" || checks[1].Name() != "router" {
+		t.Fatalf("unexpected checks %v", checks)
+	}
+	if err := checks[0].Check(nil); err == nil {
+		t.Errorf("expected the ping check to be replaced")
+	}
+}
@@ -0,0 +1,179 @@
+// Package leader elects the replica of the controller which runs the stateful and
+// mutating loops through a coordination.k8s.io Lease, every replica keeps serving
+// reads from its own cache.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/health"
+	"github.com/shani1998/k8s-utility-controller/manager"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// healthzTimeout is how long the lease may be overdue for renewal before the
+// liveness check of the leader fails.
+const healthzTimeout = 20 * time.Second
+
+// Config configures the leader election.
+type Config struct {
+	// Enabled campaigns for the lease, a replica without leader election always leads.
+	Enabled bool
+	// Namespace and Name of the Lease object.
+	Namespace string
+	Name      string
+	// Identity of this replica in the lease, e.g. the pod name.
+	Identity string
+	// LeaseDuration is how long followers wait before taking over a lease which is not renewed.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing the lease before it steps down.
+	RenewDeadline time.Duration
+	// RetryPeriod is how long to wait between attempts to acquire or renew the lease.
+	RetryPeriod time.Duration
+	// ShutdownTimeout bounds how long the leader waits for its runnables to stop.
+	ShutdownTimeout time.Duration
+}
+
+// Elector runs runnables only while this replica holds the lease.
+type Elector struct {
+	cfg       Config
+	client    func() kubernetes.Interface
+	runnables []manager.Runnable
+	healthz   *leaderelection.HealthzAdaptor
+
+	leader   atomic.Value
+	isLeader atomic.Bool
+}
+
+// New returns an elector for cfg, client returns the kube client once it has been initialized.
+func New(cfg Config, client func() kubernetes.Interface) *Elector {
+	e := &Elector{cfg: cfg, client: client, healthz: leaderelection.NewLeaderHealthzAdaptor(healthzTimeout)}
+	e.leader.Store("")
+	return e
+}
+
+// Add registers runnables which run only while this replica leads, they are started
+// in order when the lease is acquired and stopped in reverse order when it is lost.
+func (e *Elector) Add(runnables ...manager.Runnable) {
+	e.runnables = append(e.runnables, runnables...)
+}
+
+// Status returns the identity of the current leader and whether this replica leads.
+func (e *Elector) Status() models.LeaderStatus {
+	return models.LeaderStatus{
+		Enabled:  e.cfg.Enabled,
+		Identity: e.cfg.Identity,
+		Leader:   e.leader.Load().(string),
+		IsLeader: e.isLeader.Load(),
+	}
+}
+
+// HealthCheck returns a liveness check which fails when this replica leads but
+// has not renewed the lease in time, it never fails on followers.
+func (e *Elector) HealthCheck() health.Checker {
+	return health.NamedCheck("leader-election", e.healthz.Check)
+}
+
+func (e *Elector) Name() string { return "leader election" }
+
+// Start campaigns for the lease until ctx is cancelled and runs the runnables while
+// this replica leads. It is ready right away, followers serve reads as well. When
+// the lease is lost the runnables are stopped and the replica campaigns again, when
+// one of them fails Start releases the lease and returns its error.
+func (e *Elector) Start(ctx context.Context, ready func()) error {
+	ready()
+	if !e.cfg.Enabled {
+		e.leader.Store(e.cfg.Identity)
+		return e.lead(ctx)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: e.cfg.Namespace, Name: e.cfg.Name},
+		Client:     e.client().CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.cfg.Identity},
+	}
+	for ctx.Err() == nil {
+		// a failing runnable gives up the lease and ends Start, the process exits and
+		// another replica takes over
+		runCtx, cancel := context.WithCancel(ctx)
+		var (
+			mu      sync.Mutex
+			over    bool
+			started bool
+			runErr  error
+		)
+		done := make(chan struct{})
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   e.cfg.LeaseDuration,
+			RenewDeadline:   e.cfg.RenewDeadline,
+			RetryPeriod:     e.cfg.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            e.cfg.Name,
+			WatchDog:        e.healthz,
+			Callbacks: leaderelection.LeaderCallbacks{
+				// client-go starts the callback in a goroutine, it may run after
+				// elector.Run has returned and must not lead then
+				OnStartedLeading: func(leadCtx context.Context) {
+					mu.Lock()
+					started = !over
+					mu.Unlock()
+					if !started {
+						return
+					}
+					defer close(done)
+					if runErr = e.lead(leadCtx); runErr != nil {
+						cancel()
+					}
+				},
+				OnStoppedLeading: func() {
+					e.isLeader.Store(false)
+				},
+				OnNewLeader: func(identity string) {
+					e.leader.Store(identity)
+					log.Infof("%s leads %s/%s", identity, e.cfg.Namespace, e.cfg.Name)
+				},
+			},
+		})
+		if err != nil {
+			cancel()
+			return fmt.Errorf("invalid leader election config: %w", err)
+		}
+		elector.Run(runCtx)
+		cancel()
+		// the runnables have to stop before campaigning again
+		mu.Lock()
+		over = true
+		leading := started
+		mu.Unlock()
+		if leading {
+			<-done
+			if runErr != nil {
+				return runErr
+			}
+		}
+	}
+	return nil
+}
+
+// lead runs the runnables until ctx is cancelled, it fails when one of them fails.
+func (e *Elector) lead(ctx context.Context) error {
+	e.isLeader.Store(true)
+	defer e.isLeader.Store(false)
+	log.Infof("%s is leading, starting %d leader runnables", e.cfg.Identity, len(e.runnables))
+
+	mgr := manager.New(e.cfg.ShutdownTimeout)
+	mgr.Add(e.runnables...)
+	err := mgr.Run(ctx)
+	log.Infof("%s stopped leading", e.cfg.Identity)
+	return err
+}
@@ -0,0 +1,183 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/manager"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testConfig returns an enabled leader election config with short periods.
+func testConfig(identity string) Config {
+	return Config{
+		Enabled:         true,
+		Namespace:       "default",
+		Name:            "k8s-utility-controller",
+		Identity:        identity,
+		LeaseDuration:   600 * time.Millisecond,
+		RenewDeadline:   400 * time.Millisecond,
+		RetryPeriod:     100 * time.Millisecond,
+		ShutdownTimeout: time.Second,
+	}
+}
+
+// countingRunnable counts the replicas running it at the same time.
+func countingRunnable(running *atomic.Int32) manager.Runnable {
+	return manager.RunnableFunc("counter", func(ctx context.Context, ready func()) error {
+		running.Add(1)
+		defer running.Add(-1)
+		ready()
+		<-ctx.Done()
+		return nil
+	})
+}
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElectorDisabled(t *testing.T) {
+	var running atomic.Int32
+	cfg := testConfig("replica-a")
+	cfg.Enabled = false
+	elector := New(cfg, func() kubernetes.Interface { return nil })
+	elector.Add(countingRunnable(&running))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- elector.Start(ctx, func() {}) }()
+
+	waitFor(t, "leader runnable", func() bool { return running.Load() == 1 })
+	status := elector.Status()
+	if !status.IsLeader || status.Leader != "replica-a" || status.Enabled {
+		t.Errorf("unexpected status without leader election: %+v", status)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if running.Load() != 0 || elector.Status().IsLeader {
+		t.Errorf("leader runnable still running after stop")
+	}
+}
+
+func TestElectorHandsOver(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var running atomic.Int32
+	electors := make([]*Elector, 0, 2)
+	cancels := make([]context.CancelFunc, 0, 2)
+	dones := make([]chan error, 0, 2)
+	for _, identity := range []string{"replica-a", "replica-b"} {
+		elector := New(testConfig(identity), func() kubernetes.Interface { return client })
+		elector.Add(countingRunnable(&running))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- elector.Start(ctx, func() {}) }()
+		electors, cancels, dones = append(electors, elector), append(cancels, cancel), append(dones, done)
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	waitFor(t, "a leader", func() bool { return running.Load() == 1 })
+	leading, following := 0, 1
+	if electors[1].Status().IsLeader {
+		leading, following = 1, 0
+	}
+	leaderIdentity := electors[leading].Status().Identity
+	waitFor(t, "the follower to observe the leader", func() bool { return electors[following].Status().Leader == leaderIdentity })
+	if electors[following].Status().IsLeader {
+		t.Fatalf("both replicas lead")
+	}
+
+	// the stopped leader releases the lease, the follower takes over
+	cancels[leading]()
+	if err := <-dones[leading]; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	waitFor(t, "the follower to take over", func() bool { return electors[following].Status().IsLeader })
+	waitFor(t, "the runnables of the new leader", func() bool { return running.Load() == 1 })
+	waitFor(t, "the new leader to be reported", func() bool {
+		return electors[following].Status().Leader == electors[following].Status().Identity
+	})
+}
+
+func TestElectorLostRenewal(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	// the lease can't be renewed while the runnables run, so every term ends on a lost renewal
+	var failing atomic.Bool
+	client.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing.Load() {
+			return true, nil, errors.New("api server unavailable")
+		}
+		return false, nil, nil
+	})
+
+	var running, overlaps, terms atomic.Int32
+	elector := New(testConfig("replica-a"), func() kubernetes.Interface { return client })
+	elector.Add(manager.RunnableFunc("slow", func(ctx context.Context, ready func()) error {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		defer running.Add(-1)
+		terms.Add(1)
+		failing.Store(true)
+		ready()
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		failing.Store(false)
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- elector.Start(ctx, func() {}) }()
+	waitFor(t, "three terms", func() bool { return terms.Load() >= 3 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("the runnables of %d terms overlapped with the previous one", n)
+	}
+	if running.Load() != 0 {
+		t.Errorf("leader runnable still running after stop")
+	}
+}
+
+func TestElectorRunnableFailure(t *testing.T) {
+	elector := New(testConfig("replica-a"), func() kubernetes.Interface { return fake.NewSimpleClientset() })
+	elector.Add(manager.RunnableFunc("reconciler", func(context.Context, func()) error {
+		return errors.New("boom")
+	}))
+
+	done := make(chan error, 1)
+	go func() { done <- elector.Start(context.Background(), func() {}) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "reconciler failed: boom") {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("elector did not stop after its runnable failed")
+	}
+}
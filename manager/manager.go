@@ -0,0 +1,127 @@
+// Package manager runs the servers and background loops of the controller with a
+// shared lifecycle. Runnables are started one after the other in the order they are
+// added and stopped in reverse order, so every runnable outlives the ones depending on it.
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Runnable is a component run by the manager.
+type Runnable interface {
+	// Name identifies the runnable in logs and errors.
+	Name() string
+	// Start runs the component until ctx is cancelled. It calls ready once the runnables
+	// added after it can start, returning nil counts as ready. Returning an error stops
+	// the manager.
+	Start(ctx context.Context, ready func()) error
+}
+
+type runnableFunc struct {
+	name  string
+	start func(ctx context.Context, ready func()) error
+}
+
+func (r runnableFunc) Name() string { return r.name }
+
+func (r runnableFunc) Start(ctx context.Context, ready func()) error { return r.start(ctx, ready) }
+
+// RunnableFunc returns a runnable with the given name which runs start.
+func RunnableFunc(name string, start func(ctx context.Context, ready func()) error) Runnable {
+	return runnableFunc{name: name, start: start}
+}
+
+// Manager starts runnables with a shared context and stops them in reverse order.
+type Manager struct {
+	runnables []Runnable
+	// shutdownTimeout bounds how long the manager waits for each runnable to stop
+	shutdownTimeout time.Duration
+}
+
+// New returns a manager which waits up to shutdownTimeout for each of its runnables to stop.
+func New(shutdownTimeout time.Duration) *Manager {
+	return &Manager{shutdownTimeout: shutdownTimeout}
+}
+
+// Add appends runnables, they start after the runnables added before are ready.
+func (m *Manager) Add(runnables ...Runnable) {
+	m.runnables = append(m.runnables, runnables...)
+}
+
+// running is a started runnable.
+type running struct {
+	Runnable
+	cancel context.CancelFunc
+	// done is closed once Start returned
+	done chan struct{}
+	err  error
+}
+
+// Run starts the runnables and blocks until ctx is cancelled or a runnable fails, then
+// stops the started runnables in reverse order. It returns an error naming the runnable
+// which failed, nil when ctx was cancelled.
+func (m *Manager) Run(ctx context.Context) error {
+	failed := make(chan *running, len(m.runnables))
+	started := make([]*running, 0, len(m.runnables))
+	defer func() { m.stop(started) }()
+
+	for _, r := range m.runnables {
+		log.Infof("starting %s", r.Name())
+		rctx, cancel := context.WithCancel(context.Background())
+		run := &running{Runnable: r, cancel: cancel, done: make(chan struct{})}
+		readyCh := make(chan struct{})
+		var readyOnce sync.Once
+		ready := func() { readyOnce.Do(func() { close(readyCh) }) }
+		started = append(started, run)
+		go func() {
+			defer close(run.done)
+			if run.err = r.Start(rctx, ready); run.err != nil {
+				failed <- run
+				return
+			}
+			ready()
+		}()
+
+		select {
+		case <-readyCh:
+		case failedRun := <-failed:
+			return fmt.Errorf("startup aborted, %s failed: %w", failedRun.Name(), failedRun.err)
+		case <-ctx.Done():
+			log.Infof("startup interrupted while starting %s", r.Name())
+			return nil
+		}
+		log.Infof("started %s", r.Name())
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case run := <-failed:
+		return fmt.Errorf("%s failed: %w", run.Name(), run.err)
+	}
+}
+
+// stop cancels the runnables in reverse order and waits up to the shutdown timeout
+// for each of them to return. A runnable which does not stop in time is left behind,
+// so the runnables before it still get to stop, e.g. to release the leader lease.
+func (m *Manager) stop(started []*running) {
+	for i := len(started) - 1; i >= 0; i-- {
+		run := started[i]
+		log.Infof("stopping %s", run.Name())
+		run.cancel()
+		select {
+		case <-run.done:
+			if run.err != nil && !errors.Is(run.err, context.Canceled) {
+				log.Errorf("%s stopped with error: %v", run.Name(), run.err)
+			}
+		case <-time.After(m.shutdownTimeout):
+			log.Errorf("%s did not stop within %v, stopping the remaining runnables", run.Name(), m.shutdownTimeout)
+		}
+	}
+}
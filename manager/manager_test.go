@@ -0,0 +1,283 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/health"
+)
+
+// recorder records the order in which runnables start and stop.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// blocking returns a runnable which is ready right away and runs until it is stopped.
+func (r *recorder) blocking(name string) Runnable {
+	return RunnableFunc(name, func(ctx context.Context, ready func()) error {
+		r.record("start " + name)
+		ready()
+		<-ctx.Done()
+		r.record("stop " + name)
+		return nil
+	})
+}
+
+func TestManagerRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		runnables func(rec *recorder) []Runnable
+		// cancelAfter cancels the context once the event was recorded
+		cancelAfter string
+		wantErr     string
+		wantEvents  []string
+	}{
+		{
+			name: "stops in reverse order on cancel",
+			runnables: func(rec *recorder) []Runnable {
+				return []Runnable{rec.blocking("health"), rec.blocking("cache"), rec.blocking("api")}
+			},
+			cancelAfter: "start api",
+			wantEvents:  []string{"start health", "start cache", "start api", "stop api", "stop cache", "stop health"},
+		},
+		{
+			name: "one-shot runnable counts as ready",
+			runnables: func(rec *recorder) []Runnable {
+				return []Runnable{
+					RunnableFunc("client", func(context.Context, func()) error {
+						rec.record("start client")
+						return nil
+					}),
+					rec.blocking("api"),
+				}
+			},
+			cancelAfter: "start api",
+			wantEvents:  []string{"start client", "start api", "stop api"},
+		},
+		{
+			name: "Failure during startup names the runnable",
+			runnables: func(rec *recorder) []Runnable {
+				return []Runnable{
+					rec.blocking("health"),
+					RunnableFunc("cache", func(context.Context, func()) error { return errors.New("forbidden") }),
+					rec.blocking("api"),
+				}
+			},
+			wantErr:    "startup aborted, cache failed: forbidden",
+			wantEvents: []string{"start health", "stop health"},
+		},
+		{
+			name: "Failure after startup stops the others",
+			runnables: func(rec *recorder) []Runnable {
+				return []Runnable{
+					rec.blocking("health"),
+					RunnableFunc("api", func(_ context.Context, ready func()) error {
+						rec.record("start api")
+						ready()
+						return errors.New("address in use")
+					}),
+				}
+			},
+			wantErr:    "api failed: address in use",
+			wantEvents: []string{"start health", "start api", "stop health"},
+		},
+		{
+			name: "interrupted while starting",
+			runnables: func(rec *recorder) []Runnable {
+				return []Runnable{
+					rec.blocking("health"),
+					RunnableFunc("client", func(ctx context.Context, _ func()) error {
+						rec.record("start client")
+						<-ctx.Done()
+						rec.record("stop client")
+						return ctx.Err()
+					}),
+					rec.blocking("api"),
+				}
+			},
+			cancelAfter: "start client",
+			wantEvents:  []string{"start health", "start client", "stop client", "stop health"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			mgr := New(time.Second)
+			mgr.Add(tt.runnables(rec)...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter != "" {
+				go func() {
+					for !strings.Contains(strings.Join(rec.get(), ","), tt.cancelAfter) {
+						time.Sleep(time.Millisecond)
+					}
+					cancel()
+				}()
+			}
+			err := mgr.Run(ctx)
+			if (err != nil) != strings.Contains(tt.name, "Failure") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("mismatched error: want=%q, got=%v", tt.wantErr, err)
+			}
+			if got := rec.get(); strings.Join(got, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("mismatched events:\nwant=%v\ngot=%v", tt.wantEvents, got)
+			}
+		})
+	}
+}
+
+func TestManagerShutdownTimeout(t *testing.T) {
+	stopped := make(chan struct{})
+	lease := RunnableFunc("lease", func(ctx context.Context, ready func()) error {
+		ready()
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+	stuck := RunnableFunc("stuck", func(_ context.Context, ready func()) error {
+		ready()
+		select {}
+	})
+	mgr := New(10 * time.Millisecond)
+	mgr.Add(lease, stuck)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error)
+	go func() { done <- mgr.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("manager did not give up on a stuck runnable")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("runnable before the stuck one was not stopped")
+	}
+}
+
+func TestServerCancelsRequestsOnShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// the handler streams until its request is cancelled
+	streaming := make(chan struct{})
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(streaming)
+		<-r.Context().Done()
+	})}
+	mgr := New(5 * time.Second)
+	mgr.Add(NewServer("api server", srv, ServerOptions{ShutdownTimeout: 5 * time.Second}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- mgr.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var resp *http.Response
+	for resp, err = http.Get("http://" + addr); err != nil; resp, err = http.Get("http://" + addr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+	<-streaming
+
+	start := time.Now()
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("open stream held the shutdown for %v", elapsed)
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ready := health.NewFlag("router", "server is not accepting requests")
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "ok")
+	})}
+	mgr := New(5 * time.Second)
+	mgr.Add(NewServer("api server", srv, ServerOptions{Ready: ready, DrainPeriod: 200 * time.Millisecond, ShutdownTimeout: time.Second}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- mgr.Run(ctx) }()
+
+	get := func() error {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for get() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := ready.Check(nil); err != nil {
+		t.Errorf("server not ready while serving: %v", err)
+	}
+
+	cancel()
+	// readiness fails while the server keeps serving for the drain period
+	time.Sleep(50 * time.Millisecond)
+	if err := ready.Check(nil); err == nil {
+		t.Errorf("server still ready while draining")
+	}
+	if err := get(); err != nil {
+		t.Errorf("server stopped serving while draining: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := get(); err == nil {
+		t.Errorf("server still serving after shutdown")
+	}
+}
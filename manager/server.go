@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/health"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerOptions configures how a server runnable reports readiness and shuts down.
+type ServerOptions struct {
+	// Ready is set while the server accepts requests and cleared once shutdown starts.
+	Ready *health.Flag
+	// DrainPeriod is how long the server keeps serving after clearing Ready, so load
+	// balancers stop sending requests before the listener closes.
+	DrainPeriod time.Duration
+	// ShutdownTimeout bounds the graceful shutdown of the open connections after the drain period.
+	ShutdownTimeout time.Duration
+}
+
+type server struct {
+	name string
+	srv  *http.Server
+	opts ServerOptions
+}
+
+// NewServer returns a runnable serving srv on srv.Addr, over TLS when srv.TLSConfig is set.
+// It is ready once the address is bound. The contexts of the requests are cancelled once
+// shutdown starts, so long-lived requests such as event streams return instead of
+// holding the shutdown until it times out.
+func NewServer(name string, srv *http.Server, opts ServerOptions) Runnable {
+	return &server{name: name, srv: srv, opts: opts}
+}
+
+func (s *server) Name() string { return s.name }
+
+func (s *server) Start(ctx context.Context, ready func()) error {
+	listener, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	s.srv.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	s.srv.RegisterOnShutdown(cancelRequests)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.srv.TLSConfig != nil {
+			serveErr <- s.srv.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- s.srv.Serve(listener)
+		}
+	}()
+	if s.opts.Ready != nil {
+		s.opts.Ready.Set(true)
+	}
+	log.Infof("%s listening on %s", s.name, listener.Addr())
+	ready()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// stop receiving traffic before the server shuts down
+	if s.opts.Ready != nil {
+		s.opts.Ready.Set(false)
+		log.Infof("draining %s for %v", s.name, s.opts.DrainPeriod)
+		time.Sleep(s.opts.DrainPeriod)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Infof("gracefully stopped %s listening on %s", s.name, listener.Addr())
+	return nil
+}
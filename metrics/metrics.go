@@ -0,0 +1,106 @@
+// Package metrics exposes the Prometheus metrics of the controller: http
+// request metrics per route, client-go rest client metrics and the
+// collectors registered by the other packages, e.g. the workload cache.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+)
+
+// Registry holds every metric exposed by Handler.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of http requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of http requests by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	restClientRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rest_client_request_duration_seconds",
+		Help:    "Latency of kubernetes api server requests by verb and host.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"verb", "host"})
+	restClientRateLimiterDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rest_client_rate_limiter_duration_seconds",
+		Help:    "Time kubernetes api server requests waited on the client-side rate limiter by verb and host.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"verb", "host"})
+	restClientRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rest_client_requests_total",
+		Help: "Number of kubernetes api server requests by status code, method and host.",
+	}, []string{"code", "method", "host"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		restClientRequestDuration,
+		restClientRateLimiterDuration,
+		restClientRequestsTotal,
+	)
+}
+
+// RegisterClientGoMetrics routes the rest client metrics of client-go to the
+// registry, it has to be called before the first kubernetes client is created.
+func RegisterClientGoMetrics() {
+	clientmetrics.Register(clientmetrics.RegisterOpts{
+		RequestLatency:     &latencyAdapter{metric: restClientRequestDuration},
+		RateLimiterLatency: &latencyAdapter{metric: restClientRateLimiterDuration},
+		RequestResult:      &resultAdapter{metric: restClientRequestsTotal},
+	})
+}
+
+// latencyAdapter records client-go latencies by host, the url path is left
+// out to keep the cardinality bounded.
+type latencyAdapter struct {
+	metric *prometheus.HistogramVec
+}
+
+func (l *latencyAdapter) Observe(_ context.Context, verb string, u url.URL, latency time.Duration) {
+	l.metric.WithLabelValues(verb, u.Host).Observe(latency.Seconds())
+}
+
+type resultAdapter struct {
+	metric *prometheus.CounterVec
+}
+
+func (r *resultAdapter) Increment(_ context.Context, code, method, host string) {
+	r.metric.WithLabelValues(code, method, host).Inc()
+}
+
+// Handler serves the metrics of the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// InstrumentRoute records the requests count and latency of the handler, labeled
+// with the route pattern rather than the request path to bound the cardinality.
+func InstrumentRoute(route string, handle httprouter.Handle) httprouter.Handle {
+	labels := prometheus.Labels{"route": route}
+	instrumented := promhttp.InstrumentHandlerCounter(httpRequestsTotal.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(httpRequestDuration.MustCurryWith(labels),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handle(w, r, httprouter.ParamsFromContext(r.Context()))
+			})))
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		instrumented.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)))
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+	}{
+		{name: "success", path: "/services/alpha", wantCode: http.StatusOK},
+		{name: "Failure", path: "/services/beta", wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := httprouter.New()
+			router.GET("/services/:applicationGroup", InstrumentRoute("/services/:applicationGroup",
+				func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+					if _, ok := w.(http.Flusher); !ok {
+						t.Errorf("instrumented response writer does not support flushing")
+					}
+					if params.ByName("applicationGroup") == "" {
+						t.Errorf("route params are not passed to the handler")
+					}
+					if strings.Contains(tt.name, "Failure") {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.WriteHeader(http.StatusOK)
+				}))
+
+			counter := httpRequestsTotal.WithLabelValues("/services/:applicationGroup", "get", strconv.Itoa(tt.wantCode))
+			before := testutil.ToFloat64(counter)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("mismatched status code: want=%v, got=%v", tt.wantCode, w.Code)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("expected one request to be counted, got %v", got)
+			}
+		})
+	}
+}
+
+func TestClientGoAdapters(t *testing.T) {
+	u := url.URL{Scheme: "https", Host: "10.0.0.1:443", Path: "/apis/apps/v1/deployments"}
+	(&latencyAdapter{metric: restClientRequestDuration}).Observe(context.Background(), "GET", u, 20*time.Millisecond)
+	(&resultAdapter{metric: restClientRequestsTotal}).Increment(context.Background(), "200", "GET", u.Host)
+
+	if got := testutil.CollectAndCount(restClientRequestDuration, "rest_client_request_duration_seconds"); got != 1 {
+		t.Errorf("expected one latency series, got %v", got)
+	}
+	if got := testutil.ToFloat64(restClientRequestsTotal.WithLabelValues("200", "GET", "10.0.0.1:443")); got != 1 {
+		t.Errorf("expected one request result, got %v", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatched status code: want=%v, got=%v", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("expected go runtime metrics in %s", w.Body.String())
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// ConfigVersion model describes the configuration the controller currently runs with.
+type ConfigVersion struct {
+	// the Version of the applied configuration, incremented on every applied change
+	Version int64 `json:"version"`
+	// the Checksum of the applied settings
+	Checksum string `json:"checksum"`
+	// the time the configuration was AppliedAt
+	AppliedAt time.Time `json:"appliedAt"`
+	// the settings which changed in the config file but only take effect after a restart
+	PendingRestart []string `json:"pendingRestart"`
+}
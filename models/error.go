@@ -0,0 +1,19 @@
+package models
+
+// Error model to expose the reason a request
+// failed in a machine-readable form.
+type Error struct {
+	// the machine-readable Code of the failure, e.g. Forbidden or CacheNotSynced
+	Code string `json:"code"`
+	// the human readable Message describing the failure
+	Message string `json:"message"`
+	// the RequestID of the failed request, also returned in the X-Request-ID header
+	RequestID string `json:"requestId"`
+	// whether the request may succeed when Retryable later
+	Retryable bool `json:"retryable"`
+}
+
+// ErrorResponse model is the envelope of every error response.
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// the types of change reported by a ServiceEvent
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+)
+
+// ServiceEvent model to expose a change
+// of a service to watching clients.
+type ServiceEvent struct {
+	// the Type of change, one of ADDED, MODIFIED or DELETED
+	Type string `json:"type"`
+	// the Service after the change, or before it was deleted
+	Service Service `json:"service"`
+}
+
+// ServiceEventV1 model is the service
+// event as served by the v1 api.
+type ServiceEventV1 struct {
+	// the Type of change, one of ADDED, MODIFIED or DELETED
+	Type string `json:"type"`
+	// the Service after the change, or before it was deleted
+	Service ServiceV1 `json:"service"`
+}
+
+// V1 returns the event as served by the v1 api.
+func (e ServiceEvent) V1() ServiceEventV1 {
+	return ServiceEventV1{Type: e.Type, Service: e.Service.V1()}
+}
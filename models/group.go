@@ -0,0 +1,20 @@
+package models
+
+// GroupSummary model to expose the aggregated
+// state of the services of an application group.
+type GroupSummary struct {
+	// the ApplicationGroup the services belong to
+	ApplicationGroup string `json:"applicationGroup"`
+	// total number of services in the group
+	ServicesCount int `json:"servicesCount"`
+	// total number of desired pods of the services
+	TotalPodsCount int `json:"totalPodsCount"`
+	// total number of ready pods of the services
+	ReadyPodsCount int `json:"readyPodsCount"`
+	// the Health of the worst member, one of Healthy, Degraded, Down or Progressing
+	Health string `json:"health"`
+	// the WorstService of the group, the first one by namespace, kind and name on a tie
+	WorstService Service `json:"worstService"`
+	// the UnhealthyServices of the group, every member which is not Healthy
+	UnhealthyServices []Service `json:"unhealthyServices"`
+}
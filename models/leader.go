@@ -0,0 +1,14 @@
+package models
+
+// LeaderStatus model to expose the leader
+// election as seen by a replica.
+type LeaderStatus struct {
+	// whether leader election is Enabled, a replica without it always leads
+	Enabled bool `json:"enabled"`
+	// the Identity of the replica answering
+	Identity string `json:"identity"`
+	// the identity of the current Leader, empty until one has been observed
+	Leader string `json:"leader"`
+	// whether the answering replica IsLeader
+	IsLeader bool `json:"isLeader"`
+}
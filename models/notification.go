@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// the severities of a Notification, the types of the Kubernetes Event recorded along with it
+const (
+	SeverityNormal  = "Normal"
+	SeverityWarning = "Warning"
+)
+
+// Notification model describes a health transition
+// of a service or an ApplicationGroup, it is sent
+// to the webhook targets.
+type Notification struct {
+	// the ID of the notification, the same on every delivery attempt
+	ID string `json:"id"`
+	// the Time of the transition
+	Time time.Time `json:"time"`
+	// the Reason of the transition, e.g. ServiceDown or GroupRecovered
+	Reason string `json:"reason"`
+	// the Severity, Warning for degradations and Normal for recoveries
+	Severity string `json:"severity"`
+	// the Message describing the transition
+	Message string `json:"message"`
+	// the Cluster the object lives in, empty unless a cluster name is configured
+	Cluster string `json:"cluster,omitempty"`
+	// the Namespace, Kind and Name of the workload or ApplicationGroup
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	// the ApplicationGroup label of the workload, empty for ApplicationGroups
+	ApplicationGroup string `json:"applicationGroup,omitempty"`
+	// the Health after and the PreviousHealth before the transition
+	Health         string `json:"health"`
+	PreviousHealth string `json:"previousHealth"`
+}
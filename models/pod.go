@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Pod model to expose the state of a single
+// pod backing a service.
+type Pod struct {
+	// the pod Name
+	Name string `json:"name"`
+	// the lifecycle Phase of the pod, e.g. Running or Pending
+	Phase string `json:"phase"`
+	// whether the pod passes its readiness checks
+	Ready bool `json:"ready"`
+	// the Node the pod is scheduled on
+	Node string `json:"node,omitempty"`
+	// the PodIP assigned to the pod
+	PodIP string `json:"podIP,omitempty"`
+	// the Containers of the pod and their restarts
+	Containers []Container `json:"containers"`
+	// the CreatedAt time of the pod
+	CreatedAt time.Time `json:"createdAt"`
+	// the Age of the pod in human readable form, e.g. 3d4h
+	Age string `json:"age"`
+}
+
+// Container model to expose the restarts
+// of a single container of a pod.
+type Container struct {
+	// the container Name
+	Name string `json:"name"`
+	// whether the container passes its readiness checks
+	Ready bool `json:"ready"`
+	// number of times the container has been restarted
+	RestartCount int `json:"restartCount"`
+	// the reason the previous instance of the container terminated, e.g. OOMKilled
+	LastTerminationReason string `json:"lastTerminationReason,omitempty"`
+}
@@ -1,12 +1,82 @@
 package models
 
+// the computed health states of a service
+const (
+	// HealthHealthy services run all desired replicas
+	HealthHealthy = "Healthy"
+	// HealthDegraded services run some but not all desired replicas
+	HealthDegraded = "Degraded"
+	// HealthDown services run none of their desired replicas
+	HealthDown = "Down"
+	// HealthProgressing services are rolling out a change
+	HealthProgressing = "Progressing"
+)
+
 // Service model to expose the information
 // about application running on cluster.
+// It is served by the v2 api, zero values are always serialized
+// except for the cluster.
 type Service struct {
+	// the Cluster the workload runs in, empty unless a cluster name is configured
+	Cluster string `json:"cluster,omitempty"`
+	// the namespace the deployment lives in
+	Namespace string `json:"namespace"`
+	// the kind of workload, e.g. Deployment or StatefulSet
+	Kind string `json:"kind"`
+	// the deployment of Name
+	Name string `json:"name"`
+	// the workload belongs to which ApplicationGroup label
+	ApplicationGroup string `json:"applicationGroup"`
+	// total number of running pod corresponding to serviceName
+	RunningPodsCount int `json:"runningPodsCount"`
+	// the Replicas of the workload by state
+	Replicas ReplicaStatus `json:"replicas"`
+	// the generation of the workload spec the ObservedGeneration of the status belongs to
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// the Health computed from the replicas, one of Healthy, Degraded, Down or Progressing
+	Health string `json:"health"`
+}
+
+// ReplicaStatus model to expose the
+// replicas of a workload by state.
+type ReplicaStatus struct {
+	// the number of Desired replicas
+	Desired int32 `json:"desired"`
+	// the number of Ready replicas
+	Ready int32 `json:"ready"`
+	// the number of replicas Updated to the latest spec
+	Updated int32 `json:"updated"`
+	// the number of replicas Available for at least minReadySeconds
+	Available int32 `json:"available"`
+	// the number of Unavailable replicas
+	Unavailable int32 `json:"unavailable"`
+}
+
+// ServiceV1 model is the service as
+// served by the v1 api for existing clients.
+type ServiceV1 struct {
+	// the Cluster the workload runs in, empty unless a cluster name is configured
+	Cluster string `json:"cluster,omitempty"`
+	// the namespace the deployment lives in
+	Namespace string `json:"namespace,omitempty"`
+	// the kind of workload, e.g. Deployment or StatefulSet
+	Kind string `json:"kind,omitempty"`
 	// the deployment of Name
 	Name string `json:"name,omitempty"`
-	// the deployment belongs to which ApplicationGroup label
+	// the workload belongs to which ApplicationGroup label
 	ApplicationGroup string `json:"applicationGroup,omitempty"`
-	// total number of running pod corresponding to serviceName
-	RunningPodsCount int `json:"runningPodsCount,omitempty"`
+	// total number of running pod corresponding to serviceName, zero is serialized as well
+	RunningPodsCount int `json:"runningPodsCount"`
+}
+
+// V1 returns the service as served by the v1 api.
+func (s Service) V1() ServiceV1 {
+	return ServiceV1{
+		Cluster:          s.Cluster,
+		Namespace:        s.Namespace,
+		Kind:             s.Kind,
+		Name:             s.Name,
+		ApplicationGroup: s.ApplicationGroup,
+		RunningPodsCount: s.RunningPodsCount,
+	}
 }
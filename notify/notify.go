@@ -0,0 +1,467 @@
+// Package notify posts the health transitions of services and ApplicationGroups
+// to webhooks. Every target has its own queue, delivered in order and retried
+// with exponential backoff, which is persisted to disk so pending notifications
+// survive restarts. Notifications are sent by the leader only, a queue directory
+// shared by the replicas hands the pending ones over to the next leader.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shani1998/k8s-utility-controller/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// the headers sent with every notification
+const (
+	notificationIDHeader = "X-Notification-ID"
+	signatureHeader      = "X-Signature-256"
+	userAgent            = "k8s-utility-controller"
+)
+
+// the results of a delivery attempt reported in the metrics
+const (
+	resultDelivered = "delivered"
+	resultRetried   = "retried"
+	resultDropped   = "dropped"
+)
+
+var (
+	deliveriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_deliveries_total",
+		Help: "Number of webhook delivery attempts by target and result, one of delivered, retried or dropped.",
+	}, []string{"target", "result"})
+	queueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_queue_length",
+		Help: "Number of notifications waiting to be delivered by target.",
+	}, []string{"target"})
+)
+
+// Collectors returns the metrics of the notifier, to be registered with the metrics registry.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{deliveriesTotal, queueLength}
+}
+
+// Config configures the notifier.
+type Config struct {
+	// Targets the notifications are sent to.
+	Targets []Target
+	// QueueDir persists the pending notifications of every target in a sub directory,
+	// empty keeps them in memory only. The queues are loaded whenever the notifier
+	// starts, so a directory shared by the replicas lets a new leader deliver what
+	// the previous one left pending.
+	QueueDir string
+	// QueueSize bounds the pending notifications per target, the oldest are dropped.
+	QueueSize int
+	// MaxAttempts is the number of delivery attempts before a notification is dropped.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles with every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// delivery is a notification waiting to be delivered to a target, as persisted in the queue.
+type delivery struct {
+	Notification models.Notification `json:"notification"`
+	Attempts     int                 `json:"attempts"`
+	NextAttempt  time.Time           `json:"nextAttempt"`
+	// file the delivery is persisted in, empty without a queue directory
+	file string
+	// synced is set once the delivery was written to its file, or failed to be in which
+	// case it is kept in memory only, guarded by the mutex of the target
+	synced bool
+}
+
+// target is a webhook along with its queue.
+type target struct {
+	Target
+	template *template.Template
+	dir      string
+
+	mu      sync.Mutex
+	pending []*delivery
+	// dropped holds the deliveries dropped from the full queue whose files are
+	// still to be removed
+	dropped []*delivery
+	// wake is signalled when a notification is queued
+	wake chan struct{}
+}
+
+// Notifier queues the notifications and delivers them to the targets.
+type Notifier struct {
+	cfg     Config
+	client  *http.Client
+	targets []*target
+}
+
+// New returns a notifier for cfg, the targets are expected to be validated by LoadTargets.
+func New(cfg Config) (*Notifier, error) {
+	n := &Notifier{cfg: cfg, client: &http.Client{}}
+	for _, t := range cfg.Targets {
+		tmpl, err := t.parseTemplate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification target %s: %w", t.Name, err)
+		}
+		wt := &target{Target: t, template: tmpl, wake: make(chan struct{}, 1)}
+		if cfg.QueueDir != "" {
+			wt.dir = filepath.Join(cfg.QueueDir, t.Name)
+			if err := os.MkdirAll(wt.dir, 0o700); err != nil {
+				return nil, fmt.Errorf("failed to create queue of notification target %s: %w", t.Name, err)
+			}
+		}
+		n.targets = append(n.targets, wt)
+		queueLength.WithLabelValues(t.Name).Set(0)
+	}
+	return n, nil
+}
+
+func (n *Notifier) Name() string { return "webhook notifier" }
+
+// Notify queues the notification for every target which sends its reason. It does
+// not block, the queue is written to disk by the delivery loop of each target and
+// notifications queued while the notifier is stopped are delivered once it runs again.
+func (n *Notifier) Notify(notification models.Notification) {
+	for _, t := range n.targets {
+		if !t.sends(notification) {
+			continue
+		}
+		d := &delivery{Notification: notification, NextAttempt: time.Now()}
+		if t.dir != "" {
+			d.file = filepath.Join(t.dir, fmt.Sprintf("%020d-%s.json", notification.Time.UnixNano(), notification.ID))
+		}
+		t.push(d, n.cfg.QueueSize)
+	}
+}
+
+// Start delivers the queued notifications until ctx is cancelled, it is ready once
+// the persisted queues have been loaded.
+func (n *Notifier) Start(ctx context.Context, ready func()) error {
+	for _, t := range n.targets {
+		if t.dir != "" {
+			if err := t.load(); err != nil {
+				return err
+			}
+		}
+	}
+	ready()
+
+	var wg sync.WaitGroup
+	for _, t := range n.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			n.run(ctx, t)
+		}(t)
+	}
+	wg.Wait()
+	return nil
+}
+
+// run delivers the notifications of the target in order until ctx is cancelled.
+func (n *Notifier) run(ctx context.Context, t *target) {
+	// the notifications queued until the shutdown are written before the notifier stops
+	defer t.sync()
+	for {
+		t.sync()
+		d := t.head()
+		if d != nil && !time.Now().Before(d.NextAttempt) {
+			n.attempt(ctx, t, d)
+			// an attempt cut short by the shutdown leaves the delivery due
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		// an empty queue waits for the next notification
+		var wait <-chan time.Time
+		if d != nil {
+			wait = time.After(time.Until(d.NextAttempt))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.wake:
+		case <-wait:
+		}
+	}
+}
+
+// attempt delivers the notification once, it is retried later when the target is
+// temporarily unavailable and dropped once delivered or out of attempts.
+func (n *Notifier) attempt(ctx context.Context, t *target, d *delivery) {
+	err := n.send(ctx, t, d.Notification)
+	if ctx.Err() != nil {
+		// the attempt was cut short by the shutdown, it is repeated on the next start
+		return
+	}
+	d.Attempts++
+	var retryErr *retryableError
+	switch {
+	case err == nil:
+		deliveriesTotal.WithLabelValues(t.Name, resultDelivered).Inc()
+		log.Debugf("delivered notification %s to target %s", d.Notification.ID, t.Name)
+	case errors.As(err, &retryErr) && d.Attempts < n.cfg.MaxAttempts:
+		deliveriesTotal.WithLabelValues(t.Name, resultRetried).Inc()
+		delay := n.backoff(d.Attempts)
+		if retryErr.after > delay {
+			delay = retryErr.after
+		}
+		d.NextAttempt = time.Now().Add(delay)
+		log.Warnf("failed to deliver notification %s to target %s, retrying in %v: %v", d.Notification.ID, t.Name, delay, err)
+		t.update(d)
+		return
+	default:
+		deliveriesTotal.WithLabelValues(t.Name, resultDropped).Inc()
+		log.Errorf("dropping notification %s for target %s after %d attempts: %v", d.Notification.ID, t.Name, d.Attempts, err)
+	}
+	t.remove(d)
+}
+
+// backoff returns the delay before the retry following the given number of attempts.
+func (n *Notifier) backoff(attempts int) time.Duration {
+	delay := n.cfg.Backoff
+	for i := 1; i < attempts && delay < n.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, n.cfg.MaxBackoff)
+}
+
+// retryableError is a failed delivery which is retried, e.g. the target answered
+// with a 503, after is the delay the target asked for with Retry-After.
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+// send posts the notification to the target once.
+func (n *Notifier) send(ctx context.Context, t *target, notification models.Notification) error {
+	body, contentType, err := t.render(t.template, notification)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range t.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(notificationIDHeader, notification.ID)
+	if t.SecretFile != "" {
+		secret, err := os.ReadFile(t.SecretFile)
+		if err != nil {
+			return &retryableError{err: fmt.Errorf("failed to read secret: %w", err)}
+		}
+		mac := hmac.New(sha256.New, bytes.TrimSpace(secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		retryErr := &retryableError{err: fmt.Errorf("target answered %s", resp.Status)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryErr.after = time.Duration(seconds) * time.Second
+		}
+		return retryErr
+	default:
+		return fmt.Errorf("target rejected the notification with %s", resp.Status)
+	}
+}
+
+// push queues the delivery, the oldest deliveries are dropped beyond size.
+func (t *target) push(d *delivery, size int) {
+	t.mu.Lock()
+	t.pending = append(t.pending, d)
+	for len(t.pending) > size {
+		dropped := t.pending[0]
+		t.pending = t.pending[1:]
+		t.dropped = append(t.dropped, dropped)
+		deliveriesTotal.WithLabelValues(t.Name, resultDropped).Inc()
+		log.Errorf("dropping notification %s for target %s, its queue is full", dropped.Notification.ID, t.Name)
+	}
+	queueLength.WithLabelValues(t.Name).Set(float64(len(t.pending)))
+	t.mu.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// head returns the oldest delivery, nil when the queue is empty.
+func (t *target) head() *delivery {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 {
+		return nil
+	}
+	return t.pending[0]
+}
+
+// remove drops the delivery from the queue and from disk.
+func (t *target) remove(d *delivery) {
+	t.mu.Lock()
+	for i, pending := range t.pending {
+		if pending == d {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			break
+		}
+	}
+	queueLength.WithLabelValues(t.Name).Set(float64(len(t.pending)))
+	t.mu.Unlock()
+	d.delete()
+}
+
+// update persists the retry of the delivery.
+func (t *target) update(d *delivery) {
+	t.persist(d)
+}
+
+// sync writes the queued deliveries which are not persisted yet and removes the files
+// of the deliveries dropped from the full queue. It is called by the delivery loop
+// only, so the files are never written while holding the lock of the queue.
+func (t *target) sync() {
+	if t.dir == "" {
+		return
+	}
+	t.mu.Lock()
+	dropped := t.dropped
+	t.dropped = nil
+	var queued []*delivery
+	for _, d := range t.pending {
+		if !d.synced {
+			queued = append(queued, d)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, d := range dropped {
+		d.delete()
+	}
+	t.persist(queued...)
+}
+
+// persist writes the deliveries to their files, the file of a delivery dropped from
+// the full queue meanwhile is removed again.
+func (t *target) persist(deliveries ...*delivery) {
+	for _, d := range deliveries {
+		err := d.persist()
+		if err != nil {
+			log.Errorf("failed to persist notification %s for target %s, it is kept in memory only: %v", d.Notification.ID, t.Name, err)
+		}
+		t.mu.Lock()
+		queued := slices.Contains(t.pending, d)
+		d.synced = true
+		t.mu.Unlock()
+		if !queued && err == nil {
+			d.delete()
+		}
+	}
+}
+
+// load replaces the queue with the deliveries persisted in the directory of the target,
+// along with the deliveries queued while the notifier was stopped which are not
+// persisted yet.
+func (t *target) load() error {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read queue of notification target %s: %w", t.Name, err)
+	}
+	// the file names start with the time of the notification
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	pending := make([]*delivery, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		file := filepath.Join(t.dir, entry.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read queued notification %s: %w", file, err)
+		}
+		d := &delivery{file: file, synced: true}
+		if err := json.Unmarshal(data, d); err != nil {
+			log.Errorf("dropping unreadable queued notification %s: %v", file, err)
+			d.delete()
+			continue
+		}
+		pending = append(pending, d)
+	}
+	if len(pending) > 0 {
+		log.Infof("loaded %d queued notifications for target %s", len(pending), t.Name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.pending {
+		if !d.synced {
+			pending = append(pending, d)
+		}
+	}
+	// the file names start with the time of the notification
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].file < pending[j].file })
+	t.pending = pending
+	queueLength.WithLabelValues(t.Name).Set(float64(len(t.pending)))
+	return nil
+}
+
+// persist writes the delivery to its file, it is a no-op without a queue directory.
+func (d *delivery) persist() error {
+	if d.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	// replace the file in one step so a crash never leaves half a notification behind
+	tmp := d.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.file)
+}
+
+// delete removes the file of the delivery.
+func (d *delivery) delete() {
+	if d.file == "" {
+		return
+	}
+	if err := os.Remove(d.file); err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to remove queued notification %s: %v", d.file, err)
+	}
+}
@@ -0,0 +1,412 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+)
+
+var checkoutDown = models.Notification{
+	ID:               "6f1c2a9e-0d6b-4c1e-9a57-1d2f0c3b4a5e",
+	Time:             time.Date(2026, 10, 18, 9, 12, 44, 0, time.UTC),
+	Reason:           "ServiceDown",
+	Severity:         models.SeverityWarning,
+	Message:          "Deployment checkout has no ready pods",
+	Namespace:        "shop",
+	Kind:             "Deployment",
+	Name:             "checkout",
+	ApplicationGroup: "shop",
+	Health:           models.HealthDown,
+	PreviousHealth:   models.HealthHealthy,
+}
+
+// request is a notification received by the test receiver.
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook answering with the given status codes in turn, the last one repeatedly.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	codes    []int
+	requests []request
+}
+
+func newReceiver(t *testing.T, codes ...int) *receiver {
+	r := &receiver{codes: codes}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, request{header: req.Header, body: body})
+		code := r.codes[0]
+		if len(r.codes) > 1 {
+			r.codes = r.codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startNotifier runs a notifier for the targets until the test finishes, it returns
+// a function stopping it earlier.
+func startNotifier(t *testing.T, cfg Config) (*Notifier, func()) {
+	t.Helper()
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize, cfg.MaxAttempts, cfg.Backoff, cfg.MaxBackoff = 10, 3, 10*time.Millisecond, 50*time.Millisecond
+	}
+	notifier, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	readyCh := make(chan struct{})
+	go func() { done <- notifier.Start(ctx, func() { close(readyCh) }) }()
+	<-readyCh
+	stop := func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	t.Cleanup(cancel)
+	return notifier, stop
+}
+
+func TestNotifierDelivery(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		target       Target
+		codes        []int
+		wantRequests int
+		wantBody     string
+		wantType     string
+	}{
+		{
+			name:         "notification as json",
+			target:       Target{Name: "oncall"},
+			codes:        []int{http.StatusOK},
+			wantRequests: 1,
+			wantBody:     `{"id":"6f1c2a9e-0d6b-4c1e-9a57-1d2f0c3b4a5e","time":"2026-10-18T09:12:44Z","reason":"ServiceDown","severity":"Warning","message":"Deployment checkout has no ready pods","namespace":"shop","kind":"Deployment","name":"checkout","applicationGroup":"shop","health":"Down","previousHealth":"Healthy"}`,
+			wantType:     "application/json",
+		},
+		{
+			name:         "template signed with the secret",
+			target:       Target{Name: "chat", Template: `{"text": {{ json (printf "%s: %s" .Reason .Message) }}}`, SecretFile: secretFile},
+			codes:        []int{http.StatusOK},
+			wantRequests: 1,
+			wantBody:     `{"text": "ServiceDown: Deployment checkout has no ready pods"}`,
+			wantType:     "application/json",
+		},
+		{
+			name:         "cloudevent with plain text data",
+			target:       Target{Name: "events", Format: FormatCloudEvents, Template: "{{ .Message }}", ContentType: "text/plain"},
+			codes:        []int{http.StatusAccepted},
+			wantRequests: 1,
+			wantBody:     `{"specversion":"1.0","id":"6f1c2a9e-0d6b-4c1e-9a57-1d2f0c3b4a5e","source":"k8s-utility-controller","type":"io.shani1998.utility.ServiceDown","subject":"shop/Deployment/checkout","time":"2026-10-18T09:12:44Z","datacontenttype":"text/plain","data_base64":"RGVwbG95bWVudCBjaGVja291dCBoYXMgbm8gcmVhZHkgcG9kcw=="}`,
+			wantType:     "application/cloudevents+json",
+		},
+		{
+			name:         "retried while the target is unavailable",
+			target:       Target{Name: "oncall", Template: "{{ .Reason }}"},
+			codes:        []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			wantRequests: 3,
+			wantBody:     "ServiceDown",
+			wantType:     "application/json",
+		},
+		{
+			name:         "dropped after the last attempt",
+			target:       Target{Name: "oncall", Template: "{{ .Reason }}"},
+			codes:        []int{http.StatusBadGateway},
+			wantRequests: 3,
+		},
+		{
+			name:         "rejected notification is not retried",
+			target:       Target{Name: "oncall", Template: "{{ .Reason }}"},
+			codes:        []int{http.StatusBadRequest},
+			wantRequests: 1,
+		},
+		{
+			name:   "other reasons are not sent",
+			target: Target{Name: "oncall", Reasons: []string{"GroupDegraded"}},
+			codes:  []int{http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := newReceiver(t, tt.codes...)
+			tt.target.URL = webhook.URL
+			notifier, stop := startNotifier(t, Config{Targets: []Target{tt.target}})
+			notifier.Notify(checkoutDown)
+
+			waitFor(t, "the queue to drain", func() bool { return notifier.targets[0].head() == nil })
+			stop()
+			requests := webhook.received()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("mismatched number of requests: want=%d, got=%d", tt.wantRequests, len(requests))
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			last := requests[len(requests)-1]
+			if string(last.body) != tt.wantBody {
+				t.Errorf("mismatched body:\nwant=%s\ngot= %s", tt.wantBody, last.body)
+			}
+			if got := last.header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("mismatched content type: want=%s, got=%s", tt.wantType, got)
+			}
+			if got := last.header.Get(notificationIDHeader); got != checkoutDown.ID {
+				t.Errorf("mismatched notification id %q", got)
+			}
+			signature := last.header.Get(signatureHeader)
+			if tt.target.SecretFile == "" {
+				if signature != "" {
+					t.Errorf("unexpected signature %q", signature)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte("s3cr3t"))
+			mac.Write(last.body)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+				t.Errorf("mismatched signature: want=%s, got=%s", want, signature)
+			}
+		})
+	}
+}
+
+func TestNotifierPersistsQueue(t *testing.T) {
+	queueDir := t.TempDir()
+	down := newReceiver(t, http.StatusServiceUnavailable)
+	cfg := Config{Targets: []Target{{Name: "oncall", URL: down.URL}}, QueueDir: queueDir,
+		QueueSize: 10, MaxAttempts: 100, Backoff: time.Hour, MaxBackoff: time.Hour}
+
+	notifier, stop := startNotifier(t, cfg)
+	notifier.Notify(checkoutDown)
+	waitFor(t, "the first attempt", func() bool { return len(down.received()) == 1 })
+	stop()
+
+	files, _ := filepath.Glob(filepath.Join(queueDir, "oncall", "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("mismatched queued files: %v", files)
+	}
+	var queued delivery
+	data, _ := os.ReadFile(files[0])
+	if err := json.Unmarshal(data, &queued); err != nil || queued.Attempts != 1 || queued.Notification.ID != checkoutDown.ID {
+		t.Fatalf("unexpected queued notification %s: %v", data, err)
+	}
+
+	// the queue is delivered after a restart, once the retry is due
+	queued.NextAttempt = time.Now()
+	data, _ = json.Marshal(queued)
+	if err := os.WriteFile(files[0], data, 0o600); err != nil {
+		t.Fatalf("failed to rewrite queued notification %v", err)
+	}
+	up := newReceiver(t, http.StatusOK)
+	cfg.Targets[0].URL = up.URL
+	_, stop = startNotifier(t, cfg)
+	waitFor(t, "the delivery after the restart", func() bool { return len(up.received()) == 1 })
+	waitFor(t, "the queued file to be removed", func() bool {
+		files, _ := filepath.Glob(filepath.Join(queueDir, "oncall", "*.json"))
+		return len(files) == 0
+	})
+	stop()
+}
+
+func TestNotifierPersistsQueueInBackground(t *testing.T) {
+	queueDir := t.TempDir()
+	down := newReceiver(t, http.StatusServiceUnavailable)
+	cfg := Config{Targets: []Target{{Name: "oncall", URL: down.URL}}, QueueDir: queueDir,
+		QueueSize: 10, MaxAttempts: 100, Backoff: time.Hour, MaxBackoff: time.Hour}
+	notifier, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+
+	// notifications are queued in memory, the caller does not wait for the disk
+	notifier.Notify(checkoutDown)
+	queued := func() int {
+		files, _ := filepath.Glob(filepath.Join(queueDir, "oncall", "*.json"))
+		return len(files)
+	}
+	if got := queued(); got != 0 {
+		t.Fatalf("mismatched queued files before the notifier runs: want=0, got=%d", got)
+	}
+
+	// loading the queue keeps the notifications which are not persisted yet
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- notifier.Start(ctx, func() {}) }()
+	waitFor(t, "the first attempt", func() bool { return len(down.received()) == 1 })
+	waitFor(t, "the queued file", func() bool { return queued() == 1 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestNotifierHandsOverSharedQueue(t *testing.T) {
+	// both replicas mount the same queue directory, the first one leads while its target is down
+	queueDir := t.TempDir()
+	down, up := newReceiver(t, http.StatusServiceUnavailable), newReceiver(t, http.StatusOK)
+	cfg := Config{Targets: []Target{{Name: "oncall", URL: down.URL}}, QueueDir: queueDir,
+		QueueSize: 10, MaxAttempts: 100, Backoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	first, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	lead := func(n *Notifier) func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		readyCh := make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := n.Start(ctx, func() { close(readyCh) }); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}()
+		<-readyCh
+		return func() { cancel(); <-done }
+	}
+
+	stop := lead(first)
+	first.Notify(checkoutDown)
+	waitFor(t, "the first attempt", func() bool { return len(down.received()) > 0 })
+	stop()
+
+	// the next leader delivers what the first one left pending
+	cfg.Targets = []Target{{Name: "oncall", URL: up.URL}}
+	_, stop = startNotifier(t, cfg)
+	waitFor(t, "the delivery by the next leader", func() bool { return len(up.received()) == 1 })
+	waitFor(t, "the queued file to be removed", func() bool {
+		files, _ := filepath.Glob(filepath.Join(queueDir, "oncall", "*.json"))
+		return len(files) == 0
+	})
+	stop()
+
+	// once leading again the first replica does not retry the delivered notification
+	attempts := len(down.received())
+	stop = lead(first)
+	defer stop()
+	time.Sleep(100 * time.Millisecond)
+	if got := len(down.received()); got != attempts {
+		t.Errorf("mismatched attempts after leading again: want=%d, got=%d", attempts, got)
+	}
+}
+
+func TestNotifierBackoff(t *testing.T) {
+	n := &Notifier{cfg: Config{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := n.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantNames []string
+	}{
+		{
+			name: "targets",
+			content: `
+- name: oncall
+  url: https://hooks.example.com/oncall
+  reasons: [ServiceDown, GroupDegraded]
+  template: '{"text": {{ json .Message }}}'
+  timeout: 5s
+- name: events
+  url: http://broker.knative-eventing.svc/default
+  format: cloudevents
+`,
+			wantNames: []string{"oncall", "events"},
+		},
+		{
+			name:    "Failure invalid url",
+			content: "- name: oncall\n  url: hooks.example.com\n",
+		},
+		{
+			name:    "Failure invalid template",
+			content: "- name: oncall\n  url: https://hooks.example.com\n  template: '{{ .Message '\n",
+		},
+		{
+			name:    "Failure unknown format",
+			content: "- name: oncall\n  url: https://hooks.example.com\n  format: xml\n",
+		},
+		{
+			name:    "Failure target listed twice",
+			content: "- name: oncall\n  url: https://hooks.example.com/a\n- name: oncall\n  url: https://hooks.example.com/b\n",
+		},
+		{
+			name:    "Failure unknown field",
+			content: "- name: oncall\n  url: https://hooks.example.com\n  secret: s3cr3t\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("failed to write targets %v", err)
+			}
+			targets, err := LoadTargets(path)
+			if strings.Contains(tt.name, "Failure") {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			names := make([]string, 0, len(targets))
+			for _, target := range targets {
+				names = append(names, target.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("mismatched targets: want=%v, got=%v", tt.wantNames, names)
+			}
+		})
+	}
+}
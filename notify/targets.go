@@ -0,0 +1,204 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/shani1998/k8s-utility-controller/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// the formats of the request body of a target
+const (
+	FormatJSON        = "json"
+	FormatCloudEvents = "cloudevents"
+)
+
+const (
+	defaultContentType = "application/json"
+	defaultTimeout     = 10 * time.Second
+	// cloudEventTypePrefix prefixes the reason of a notification in the type of its CloudEvent
+	cloudEventTypePrefix = "io.shani1998.utility."
+	cloudEventSource     = "k8s-utility-controller"
+)
+
+// Target is a webhook the notifications are sent to.
+type Target struct {
+	// Name of the target, used in logs, metrics and as the directory of its queue.
+	Name string `json:"name"`
+	// URL the notifications are posted to.
+	URL string `json:"url"`
+	// Reasons limits the notifications sent, e.g. [ServiceDown, GroupDegraded], empty sends every one.
+	Reasons []string `json:"reasons,omitempty"`
+	// Format of the body, json posts the rendered notification and cloudevents wraps it
+	// in a structured mode CloudEvent. Defaults to json.
+	Format string `json:"format,omitempty"`
+	// Template renders the body, or the data of the CloudEvent, from the notification with
+	// text/template, defaults to the notification as JSON.
+	Template string `json:"template,omitempty"`
+	// ContentType of the rendered template, defaults to application/json.
+	ContentType string `json:"contentType,omitempty"`
+	// SecretFile holds the key the body is signed with, the signature is sent as
+	// X-Signature-256: sha256=<hex HMAC-SHA256>. It is read on every delivery to pick up rotations.
+	SecretFile string `json:"secretFile,omitempty"`
+	// Headers sent with every request, e.g. an Authorization header.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout of a single delivery attempt, defaults to 10s.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// LoadTargets reads and validates the list of webhook targets from the given YAML or JSON file.
+func LoadTargets(path string) ([]Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read notification targets file %s: %v", path, err)
+	}
+	var targets []Target
+	if err := yaml.UnmarshalStrict(data, &targets); err != nil {
+		return nil, fmt.Errorf("unable to parse notification targets file %s: %v", path, err)
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("invalid notification target %q in %s: %v", t.Name, path, err)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("notification target %q is listed twice in %s", t.Name, path)
+		}
+		seen[t.Name] = true
+	}
+	return targets, nil
+}
+
+func (t Target) validate() error {
+	if errs := validation.IsDNS1123Label(t.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name: %v", errs)
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, got %q", t.URL)
+	}
+	if t.Format != "" && t.Format != FormatJSON && t.Format != FormatCloudEvents {
+		return fmt.Errorf("format must be %s or %s, got %q", FormatJSON, FormatCloudEvents, t.Format)
+	}
+	if _, err := t.parseTemplate(); err != nil {
+		return err
+	}
+	if t.SecretFile != "" {
+		if _, err := os.Stat(t.SecretFile); err != nil {
+			return err
+		}
+	}
+	if t.Timeout != nil && t.Timeout.Duration <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+// parseTemplate returns the template of the body, nil when the notification is sent as JSON.
+func (t Target) parseTemplate() (*template.Template, error) {
+	if t.Template == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Funcs(template.FuncMap{"json": toJSON}).Parse(t.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// toJSON renders a value in templates as JSON, e.g. to quote a message: {{ json .Message }}.
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// sends reports whether the notification is sent to the target.
+func (t Target) sends(n models.Notification) bool {
+	if len(t.Reasons) == 0 {
+		return true
+	}
+	for _, reason := range t.Reasons {
+		if reason == n.Reason {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Target) timeout() time.Duration {
+	if t.Timeout == nil {
+		return defaultTimeout
+	}
+	return t.Timeout.Duration
+}
+
+// cloudEvent is a CloudEvent in the structured JSON format.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	// DataBase64 carries rendered templates which are not JSON
+	DataBase64 []byte `json:"data_base64,omitempty"`
+}
+
+// render returns the body of the request for the notification and its content type.
+func (t Target) render(tmpl *template.Template, n models.Notification) ([]byte, string, error) {
+	contentType := defaultContentType
+	var body []byte
+	if tmpl == nil {
+		data, err := json.Marshal(n)
+		if err != nil {
+			return nil, "", err
+		}
+		body = data
+	} else {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return nil, "", fmt.Errorf("failed to render template: %v", err)
+		}
+		body = buf.Bytes()
+		if t.ContentType != "" {
+			contentType = t.ContentType
+		}
+	}
+	if t.Format != FormatCloudEvents {
+		return body, contentType, nil
+	}
+
+	source := cloudEventSource
+	if n.Cluster != "" {
+		source += "/" + n.Cluster
+	}
+	event := cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              n.ID,
+		Source:          source,
+		Type:            cloudEventTypePrefix + n.Reason,
+		Subject:         n.Namespace + "/" + n.Kind + "/" + n.Name,
+		Time:            n.Time,
+		DataContentType: contentType,
+	}
+	if json.Valid(body) {
+		event.Data = body
+	} else {
+		event.DataBase64 = body
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, "application/cloudevents+json", nil
+}